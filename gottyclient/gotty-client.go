@@ -72,6 +72,13 @@ func GetWebsocketURL(httpURL string) (*url.URL, *http.Header, error) {
 	return target, &header, nil
 }
 
+// websocket subprotocols of the server, the binary one sends the output
+// as binary frames of its type byte and the raw bytes instead of base64
+const (
+	ProtocolText   = "gotty"
+	ProtocolBinary = "gotty.v2"
+)
+
 type Client struct {
 	Dialer         *websocket.Dialer
 	Conn           *websocket.Conn
@@ -82,6 +89,9 @@ type Client struct {
 	QuitChan       chan struct{}
 	QuitChanClosed bool
 	SkipTLSVerify  bool
+	// the subprotocol the server chose, older servers only speak
+	// ProtocolText
+	Protocol string
 }
 
 type querySingleType struct {
@@ -95,6 +105,26 @@ func (c *Client) write(data []byte) error {
 	return c.Conn.WriteMessage(websocket.TextMessage, data)
 }
 
+// writeInput sends keys as they are, in a binary frame if the server
+// speaks the binary protocol
+func (c *Client) writeInput(data []byte) error {
+	msg := append([]byte("0"), data...)
+	if c.Protocol != ProtocolBinary {
+		return c.write(msg)
+	}
+	c.WriteMutex.Lock()
+	defer c.WriteMutex.Unlock()
+	return c.Conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// decodeOutput returns the terminal output of an output message
+func decodeOutput(messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.BinaryMessage {
+		return data[1:], nil
+	}
+	return base64.StdEncoding.DecodeString(string(data[1:]))
+}
+
 // GetAuthToken retrieves an Auth Token from dynamic auth_token.js file
 func (c *Client) GetAuthToken() (string, error) {
 	target, header, err := GetAuthTokenURL(c.URL)
@@ -156,12 +186,15 @@ func (c *Client) Connect() error {
 	if c.SkipTLSVerify {
 		c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	c.Dialer.Subprotocols = []string{ProtocolBinary, ProtocolText}
 	conn, _, err := c.Dialer.Dial(target.String(), *header)
 	if err != nil {
 		return err
 	}
 	c.Conn = conn
 	c.Connected = true
+	c.Protocol = conn.Subprotocol()
+	glog.V(3).Infof("Websocket protocol: %q", c.Protocol)
 
 	// Pass arguments and auth-token
 	query, err := GetURLQuery(c.URL)
@@ -300,7 +333,7 @@ func (c *Client) writeLoop(done chan bool, wg *sync.WaitGroup) {
 				return
 			}
 			data := buff[:size]
-			err = c.write(append([]byte("0"), data...))
+			err = c.writeInput(data)
 			if err != nil {
 				done <- true
 				return
@@ -319,6 +352,7 @@ func (c *Client) readLoop(done chan bool, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	type MessageNonBlocking struct {
+		Type int
 		Data []byte
 		Err  error
 	}
@@ -326,8 +360,8 @@ func (c *Client) readLoop(done chan bool, wg *sync.WaitGroup) {
 
 	for {
 		go func() {
-			_, data, err := c.Conn.ReadMessage()
-			msgChan <- MessageNonBlocking{Data: data, Err: err}
+			t, data, err := c.Conn.ReadMessage()
+			msgChan <- MessageNonBlocking{Type: t, Data: data, Err: err}
 		}()
 
 		select {
@@ -347,8 +381,8 @@ func (c *Client) readLoop(done chan bool, wg *sync.WaitGroup) {
 				return
 			}
 			switch msg.Data[0] {
-			case '0': // data
-				buf, err := base64.StdEncoding.DecodeString(string(msg.Data[1:]))
+			case '0': // data, raw in binary frames or base64 in text frames
+				buf, err := decodeOutput(msg.Type, msg.Data)
 				if err != nil {
 					glog.V(1).Infof("Invalid base64 content: %q", msg.Data[1:])
 					break
@@ -362,6 +396,8 @@ func (c *Client) readLoop(done chan bool, wg *sync.WaitGroup) {
 				glog.V(3).Infof("Unhandled protocol message: json pref: %s", string(msg.Data[1:]))
 			case '4': // autoreconnect
 				glog.V(3).Infof("Unhandled protocol message: autoreconnect: %s", string(msg.Data))
+			case '9': // resume token
+				glog.V(3).Infof("Unhandled protocol message: resume: %s", string(msg.Data))
 			default:
 				glog.V(1).Infof("Unhandled protocol message: %s", string(msg.Data))
 			}
@@ -396,7 +432,7 @@ func NewClient(inputURL string) (*Client, error) {
 		return nil, err
 	}
 	return &Client{
-		Dialer:     &websocket.Dialer{},
+		Dialer:     &websocket.Dialer{EnableCompression: true},
 		URL:        url,
 		WriteMutex: &sync.Mutex{},
 		Output:     os.Stdout,
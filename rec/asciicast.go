@@ -0,0 +1,230 @@
+package rec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	FORMAT_GOB       = "gob"
+	FORMAT_ASCIICAST = "asciicast"
+)
+
+// CastHeader is the first line of an asciicast v2 file
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castWriter streams asciicast v2, every event is one line so a file
+// cut short by a crash is still valid up to its last complete line
+type castWriter struct {
+	w       io.Writer
+	start   int64
+	header  bool
+	pending []byte
+}
+
+func (c *castWriter) writeHeader(env *ArgEnvTerminal, width, height int, now int64) error {
+	h := CastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: now / 1000000000,
+		Command:   env.Command,
+		Env:       map[string]string{"TERM": env.Term, "SHELL": env.Shell},
+	}
+	if env.User != "" {
+		h.Env["USER"] = env.User
+	}
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	c.start = now
+	c.header = true
+	_, err = c.w.Write(append(buf, '\n'))
+	return err
+}
+
+func (c *castWriter) event(now int64, typ string, data []byte) error {
+	s, _ := json.Marshal(string(data))
+	_, err := fmt.Fprintf(c.w, "[%.6f, %q, %s]\n", nano2sec(now-c.start), typ, s)
+	return err
+}
+
+// write converts a recorder frame, the header is written with the size
+// of the first resize or 80x24 if output comes first
+func (c *castWriter) write(env *ArgEnvTerminal, now int64, d []byte) error {
+	switch d[0] {
+	case SysEnv:
+		// before the header the env is part of it, later it can't change
+		return nil
+	case ResizeTerminal:
+		var args ArgResizeTerminal
+		if err := json.Unmarshal(d[1:], &args); err != nil {
+			return err
+		}
+		if !c.header {
+			return c.writeHeader(env, int(args.Columns), int(args.Rows), now)
+		}
+		return c.event(now, "r", []byte(fmt.Sprintf("%dx%d",
+			int(args.Columns), int(args.Rows))))
+	case Output:
+		if !c.header {
+			if err := c.writeHeader(env, 80, 24, now); err != nil {
+				return err
+			}
+		}
+		// keep a rune split between two reads for the next event
+		data := append(c.pending, d[1:]...)
+		n := completeUTF8(data)
+		c.pending = append([]byte(nil), data[n:]...)
+		if n == 0 {
+			return nil
+		}
+		return c.event(now, "o", data[:n])
+	case UserInput:
+		var in ArgUserInput
+		if err := json.Unmarshal(d[1:], &in); err != nil {
+			return err
+		}
+		if !c.header {
+			if err := c.writeHeader(env, 80, 24, now); err != nil {
+				return err
+			}
+		}
+		return c.event(now, "i", []byte(in.Data))
+	}
+	return nil
+}
+
+// completeUTF8 returns the length of p without a trailing incomplete rune
+func completeUTF8(p []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(p); i++ {
+		b := p[len(p)-i]
+		if b < utf8.RuneSelf {
+			return len(p)
+		}
+		if utf8.RuneStart(b) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return len(p)
+			}
+			return len(p) - i
+		}
+	}
+	return len(p)
+}
+
+// castDecoder reads asciicast v2 as the frames of the gob format, the
+// header becomes a SysEnv and a ResizeTerminal frame
+type castDecoder struct {
+	r      *countReader
+	frames []RecData
+	start  int64
+	// offset of the line of the frames left and of the last one returned
+	line int64
+	off  int64
+}
+
+func newCastDecoder(r io.Reader) (*castDecoder, error) {
+	cr, ok := r.(*countReader)
+	if !ok {
+		cr = newCountReader(r)
+	}
+	d := &castDecoder{r: cr, line: cr.n}
+	line, err := d.r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+
+	var h CastHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return nil, err
+	}
+	if h.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	d.start = h.Timestamp * 1000000000
+
+	env, _ := json.Marshal(ArgEnvTerminal{Term: h.Env["TERM"],
+		Shell: h.Env["SHELL"], Command: h.Command, User: h.Env["USER"]})
+	size, _ := json.Marshal(ArgResizeTerminal{Columns: float64(h.Width),
+		Rows: float64(h.Height)})
+	d.frames = []RecData{
+		{Time: d.start, Data: append([]byte{SysEnv}, env...)},
+		{Time: d.start, Data: append([]byte{ResizeTerminal}, size...)},
+	}
+	return d, nil
+}
+
+// Decode fills e, a *RecData, with the next output, input or resize
+// event
+func (d *castDecoder) Decode(e interface{}) error {
+	rd, ok := e.(*RecData)
+	if !ok {
+		return errors.New("castDecoder: Decode needs a *RecData")
+	}
+
+	for len(d.frames) == 0 {
+		d.line = d.r.n
+		line, rerr := d.r.ReadBytes('\n')
+		if rerr != nil && len(line) == 0 {
+			return rerr
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var ev []interface{}
+		if err := json.Unmarshal(line, &ev); err != nil {
+			if rerr != nil {
+				// the partial last line of a crashed recording
+				return io.EOF
+			}
+			return err
+		}
+		if len(ev) != 3 {
+			return fmt.Errorf("malformed asciicast event %s", line)
+		}
+		sec, _ := ev[0].(float64)
+		typ, _ := ev[1].(string)
+		data, _ := ev[2].(string)
+		t := d.start + int64(sec*1e9)
+
+		switch typ {
+		case "o":
+			d.frames = append(d.frames, RecData{Time: t,
+				Data: append([]byte{Output}, data...)})
+		case "i":
+			in, _ := json.Marshal(ArgUserInput{Data: data})
+			d.frames = append(d.frames, RecData{Time: t,
+				Data: append([]byte{UserInput}, in...)})
+		case "r":
+			var cols, rows int
+			if i := strings.IndexByte(data, 'x'); i > 0 {
+				cols, _ = strconv.Atoi(data[:i])
+				rows, _ = strconv.Atoi(data[i+1:])
+			}
+			size, _ := json.Marshal(ArgResizeTerminal{Columns: float64(cols),
+				Rows: float64(rows)})
+			d.frames = append(d.frames, RecData{Time: t,
+				Data: append([]byte{ResizeTerminal}, size...)})
+		}
+	}
+
+	*rd = d.frames[0]
+	d.frames = d.frames[1:]
+	d.off = d.line
+	return nil
+}
@@ -0,0 +1,116 @@
+package rec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAsciicastRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_ASCIICAST, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(r.FileName, ".cast") {
+		t.Errorf("file name %s", r.FileName)
+	}
+	r.SetUser("alice")
+	r.Write([]byte(`2{"Columns":100,"Rows":30}`))
+	// "ż" split between two reads
+	r.Write([]byte("0a\xc5"))
+	r.Write([]byte("0\xbcb"))
+	r.WriteInput(&ArgUserInput{User: "alice", Data: "ls\r"})
+	r.Write([]byte(`2{"Columns":120,"Rows":40}`))
+	r.Close()
+
+	buf, _ := ioutil.ReadFile(r.FileName)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines:\n%s", len(lines), buf)
+	}
+	var h CastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Width != 100 || h.Height != 30 ||
+		h.Command != "bash" || h.Env["USER"] != "alice" {
+		t.Errorf("header %s", lines[0])
+	}
+	for i, want := range []string{`"o", "a"]`, `"o", "żb"]`, `"i", "ls\r"]`, `"r", "120x40"]`} {
+		if !strings.HasSuffix(lines[i+1], want) {
+			t.Errorf("event %d: %s, want suffix %s", i, lines[i+1], want)
+		}
+	}
+
+	// a crash may leave a partial last line
+	f, _ := os.OpenFile(r.FileName, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte(`[1.5, "o", "trunc`))
+	f.Close()
+
+	f, _ = os.Open(r.FileName)
+	defer f.Close()
+	dec, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	var types []byte
+	for {
+		var d RecData
+		if err := dec.Decode(&d); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		types = append(types, d.Data[0])
+		if d.Data[0] == Output {
+			out.Write(d.Data[1:])
+		}
+	}
+	if string(types) != string([]byte{SysEnv, ResizeTerminal, Output, Output, UserInput, ResizeTerminal}) {
+		t.Errorf("frame types %q", types)
+	}
+	if out.String() != "ażb" {
+		t.Errorf("output %q", out.String())
+	}
+}
+
+func TestGobDecoder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("0hello"))
+	r.Close()
+
+	f, _ := os.Open(r.FileName)
+	defer f.Close()
+	dec, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d RecData
+	if dec.Decode(&d); d.Data[0] != SysEnv {
+		t.Fatalf("first frame %q", d.Data)
+	}
+	if dec.Decode(&d); string(d.Data) != "0hello" {
+		t.Fatalf("second frame %q", d.Data)
+	}
+}
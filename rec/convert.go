@@ -2,7 +2,6 @@
 package rec
 
 import (
-	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -95,12 +94,15 @@ func Save(asciicast *Asciicast, path string) error {
 func Convert(src, dst string, wait int64) error {
 	var buf RecData
 
-	fp, err := os.OpenFile(src, os.O_RDONLY, 0)
+	fp, err := OpenRecording(src)
 	if err != nil {
 		return err
 	}
 	defer fp.Close()
-	dec := gob.NewDecoder(fp)
+	dec, err := NewDecoder(fp)
+	if err != nil {
+		return err
+	}
 	s := &Stream{maxWait: wait * 1000000000}
 
 	asciicast := &Asciicast{Version: 1, Env: &Env{}}
@@ -108,6 +110,7 @@ func Convert(src, dst string, wait int64) error {
 		if err == io.EOF {
 			return errors.New("empty file")
 		}
+		return err
 	}
 
 	//s.lastWriteTime = buf.Time
@@ -135,15 +138,19 @@ func Convert(src, dst string, wait int64) error {
 			}
 		case Output:
 			s.Write(buf.Time, buf.Data[1:])
+		case Trailer, UserInput, Chat:
 		default:
 			fmt.Fprintf(os.Stderr, "unknow type(%d) context(%s)",
 				buf.Data[0], string(buf.Data[1:]))
 		}
 
 		if err = dec.Decode(&buf); err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				// keep the frames before a truncated or corrupt one
+				fmt.Fprintf(os.Stderr, "%s: %v, converted up to here\n",
+					src, err)
 			}
+			break
 		}
 	}
 	asciicast.Stdout = s.Frames
@@ -0,0 +1,517 @@
+package rec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// an encrypted recording starts with cryptMagic, a version byte, the
+// length and id of its key and a random salt. The recording follows in
+// chunks, each a big-endian uint32 length and the chunk sealed with
+// AES-256-GCM under a key derived from the key and the salt with
+// HKDF-SHA256. The nonce is the chunk's number, its first byte is 1 for
+// the last chunk, the header is the additional data.
+//
+// Version 1 recordings are sealed with the key itself and have a 4 byte
+// nonce prefix instead of the salt and no last chunk, they can be read
+// and are rekeyed to version 2.
+const (
+	cryptMagic     = "GOTTYENC"
+	cryptVersion   = 2
+	cryptVersionV1 = 1
+	// plaintext is sealed when this much is buffered or on a flush
+	cryptChunk    = 64 << 10
+	cryptSalt     = 32
+	cryptPrefixV1 = 4
+	cryptKeyLen   = 32
+	cryptInfo     = "gotty recording"
+)
+
+var errNoKeys = errors.New("the recording is encrypted, set rec_key_file")
+
+// Keyring holds the keys of the encrypted recordings, new recordings
+// are encrypted with the first key
+type Keyring struct {
+	Current string
+	keys    map[string][]byte
+}
+
+var (
+	keyringLock sync.RWMutex
+	keyring     *Keyring
+)
+
+// SetKeyring sets the keys recordings are encrypted and decrypted with,
+// nil records in plain text
+func SetKeyring(k *Keyring) {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	keyring = k
+}
+
+func getKeyring() *Keyring {
+	keyringLock.RLock()
+	defer keyringLock.RUnlock()
+	return keyring
+}
+
+// CurrentKey returns the id of the key new recordings are encrypted
+// with, "" if they are not
+func CurrentKey() string {
+	if k := getKeyring(); k != nil {
+		return k.Current
+	}
+	return ""
+}
+
+// LoadKeyring reads a key file, one "KEYID HEXKEY" per line with a 32
+// byte key, e.g. made with `openssl rand -hex 32`. Empty lines and
+// lines starting with # are skipped.
+func LoadKeyring(filename string) (*Keyring, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &Keyring{keys: make(map[string][]byte)}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) > 255 {
+			return nil, fmt.Errorf("%s:%d: want KEYID HEXKEY", filename, n)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != cryptKeyLen {
+			return nil, fmt.Errorf("%s:%d: the key must be %d hex bytes",
+				filename, n, cryptKeyLen)
+		}
+		if _, ok := k.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s",
+				filename, n, fields[0])
+		}
+		if k.Current == "" {
+			k.Current = fields[0]
+		}
+		k.keys[fields[0]] = key
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if k.Current == "" {
+		return nil, fmt.Errorf("%s: no keys", filename)
+	}
+	return k, nil
+}
+
+// fileKey derives the key of a recording from key and its salt with
+// HKDF-SHA256 (RFC 5869), a single block is the key length
+func fileKey(key, salt []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(key)
+	mac = hmac.New(sha256.New, mac.Sum(nil))
+	mac.Write([]byte(cryptInfo))
+	mac.Write([]byte{1})
+	return mac.Sum(nil)
+}
+
+// aead returns the cipher of the recording with the header h
+func (k *Keyring) aead(h []byte) (cipher.AEAD, error) {
+	id := cryptKeyId(h)
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown recording key %q", id)
+	}
+	if h[len(cryptMagic)] != cryptVersionV1 {
+		key = fileKey(key, cryptTail(h))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cryptKeyId returns the key id of the header h
+func cryptKeyId(h []byte) string {
+	n := len(cryptMagic) + 2
+	return string(h[n : n+int(h[n-1])])
+}
+
+// cryptTail returns the salt of the header h, or the nonce prefix of a
+// version 1 recording
+func cryptTail(h []byte) []byte {
+	return h[len(cryptMagic)+2+int(h[len(cryptMagic)+1]):]
+}
+
+// cryptNonce returns the nonce of chunk n, prefix is nil but for
+// version 1 recordings
+func cryptNonce(aead cipher.AEAD, prefix []byte, n uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+	if last {
+		nonce[0] = 1
+	}
+	return nonce
+}
+
+// sealWriter encrypts a recording as it is written
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	n      uint64
+	buf    []byte
+	closed bool
+}
+
+// newSealWriter writes the header of a recording encrypted with the
+// current key of k to w
+func newSealWriter(w io.Writer, k *Keyring) (*sealWriter, error) {
+	salt := make([]byte, cryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	h := append([]byte(cryptMagic), cryptVersion, byte(len(k.Current)))
+	h = append(append(h, k.Current...), salt...)
+	aead, err := k.aead(h)
+	if err != nil {
+		return nil, err
+	}
+	s := &sealWriter{w: w, aead: aead, header: h}
+	if _, err := w.Write(s.header); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= cryptChunk {
+		if err := s.seal(s.buf[:cryptChunk], false); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[cryptChunk:]
+	}
+	return len(p), nil
+}
+
+// Flush seals what is buffered, before the file is synced
+func (s *sealWriter) Flush() error {
+	if len(s.buf) == 0 || s.closed {
+		return nil
+	}
+	err := s.seal(s.buf, false)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// Close seals what is buffered as the last chunk, a recording without
+// it was cut short
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.seal(s.buf, true)
+	s.buf = nil
+	return err
+}
+
+func (s *sealWriter) seal(p []byte, last bool) error {
+	out := make([]byte, 4, 4+len(p)+s.aead.Overhead())
+	out = s.aead.Seal(out, cryptNonce(s.aead, nil, s.n, last), p, s.header)
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	s.n++
+	_, err := s.w.Write(out)
+	return err
+}
+
+// openReader decrypts a recording and can seek in its plaintext, the
+// chunks are located when it is opened and decrypted as they are read
+type openReader struct {
+	f      io.ReaderAt
+	aead   cipher.AEAD
+	header []byte
+	// the nonce prefix of a version 1 recording
+	prefix []byte
+	// file offset and plaintext offset of every chunk, and the size of
+	// the plaintext
+	chunks []int64
+	starts []int64
+	size   int64
+	// the last chunk is missing, reading ends with io.ErrUnexpectedEOF
+	truncated bool
+
+	off int64
+	// the chunk decrypted last
+	cur   int
+	plain []byte
+}
+
+// KeyId returns the id of the key the recording filename is encrypted
+// with, "" if it is not encrypted
+func KeyId(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h, err := readCryptHeader(f)
+	if h == nil || err != nil {
+		return "", err
+	}
+	return cryptKeyId(h), nil
+}
+
+// NeedsRekey reports whether Rekey would change the recording filename,
+// it is plain, encrypted with another key than the current one or in
+// the version 1 format
+func NeedsRekey(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h, err := readCryptHeader(f)
+	if h == nil || err != nil {
+		return err == nil, err
+	}
+	return cryptKeyId(h) != CurrentKey() || h[len(cryptMagic)] != cryptVersion, nil
+}
+
+// readCryptHeader returns the header of an encrypted recording, nil if
+// r is not encrypted
+func readCryptHeader(r io.ReaderAt) ([]byte, error) {
+	b := make([]byte, len(cryptMagic)+2+255+cryptSalt)
+	n, err := r.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	b = b[:n]
+	if !bytes.HasPrefix(b, []byte(cryptMagic)) {
+		return nil, nil
+	}
+	if len(b) < len(cryptMagic)+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	tail := cryptSalt
+	switch b[len(cryptMagic)] {
+	case cryptVersion:
+	case cryptVersionV1:
+		tail = cryptPrefixV1
+	default:
+		return nil, errors.New("unsupported encrypted recording")
+	}
+	end := len(cryptMagic) + 2 + int(b[len(cryptMagic)+1]) + tail
+	if len(b) < end {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b[:end], nil
+}
+
+func newOpenReader(f io.ReaderAt, fsize int64, header []byte) (*openReader, error) {
+	k := getKeyring()
+	if k == nil {
+		return nil, errNoKeys
+	}
+	aead, err := k.aead(header)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &openReader{f: f, aead: aead, header: header, cur: -1}
+	v1 := header[len(cryptMagic)] == cryptVersionV1
+	if v1 {
+		o.prefix = cryptTail(header)
+	}
+	var lb [4]byte
+	for off := int64(len(header)); off+4 <= fsize; {
+		if _, err := f.ReadAt(lb[:], off); err != nil {
+			return nil, err
+		}
+		n := int64(binary.BigEndian.Uint32(lb[:]))
+		if n < int64(aead.Overhead()) || off+4+n > fsize {
+			// the chunk being written when the recorder crashed
+			break
+		}
+		o.chunks = append(o.chunks, off)
+		o.starts = append(o.starts, o.size)
+		o.size += n - int64(aead.Overhead())
+		off += 4 + n
+	}
+	if !v1 && (len(o.chunks) == 0 || o.chunk(len(o.chunks)-1) != nil) {
+		// cut short, or the last chunk was changed and fails to open
+		// when it is read
+		o.truncated = true
+	}
+	return o, nil
+}
+
+func (o *openReader) chunk(i int) error {
+	if o.cur == i {
+		return nil
+	}
+	off := o.chunks[i]
+	var lb [4]byte
+	if _, err := o.f.ReadAt(lb[:], off); err != nil {
+		return err
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(lb[:]))
+	if _, err := o.f.ReadAt(sealed, off+4); err != nil {
+		return err
+	}
+	last := o.prefix == nil && !o.truncated && i == len(o.chunks)-1
+	plain, err := o.aead.Open(sealed[:0],
+		cryptNonce(o.aead, o.prefix, uint64(i), last), sealed, o.header)
+	if err != nil {
+		return fmt.Errorf("chunk %d of the encrypted recording: %v", i, err)
+	}
+	o.cur, o.plain = i, plain
+	return nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		if o.truncated {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, io.EOF
+	}
+	// the last chunk which starts at or before off
+	i := sort.Search(len(o.starts), func(i int) bool {
+		return o.starts[i] > o.off
+	}) - 1
+	if err := o.chunk(i); err != nil {
+		return 0, err
+	}
+	n := copy(p, o.plain[o.off-o.starts[i]:])
+	o.off += int64(n)
+	return n, nil
+}
+
+func (o *openReader) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		off += o.off
+	case io.SeekEnd:
+		off += o.size
+	}
+	if off < 0 {
+		return 0, errors.New("seek before the start of the recording")
+	}
+	o.off = off
+	return off, nil
+}
+
+// recFile is an open encrypted recording
+type recFile struct {
+	*openReader
+	f *os.File
+}
+
+func (r *recFile) Close() error {
+	return r.f.Close()
+}
+
+// OpenRecording opens a recording to read its plaintext, encrypted ones
+// need the keyring
+func OpenRecording(filename string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readCryptHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if h == nil {
+		return f, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	o, err := newOpenReader(f, fi.Size(), h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &recFile{openReader: o, f: f}, nil
+}
+
+// Rekey encrypts the recording filename with the current key in place,
+// it may be plain or encrypted with an older key. The plain text
+// sidecars of a plain recording are removed.
+func Rekey(filename string) error {
+	k := getKeyring()
+	if k == nil {
+		return errors.New("no keys to encrypt with, set rec_key_file")
+	}
+	in, err := OpenRecording(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dir, base := path.Split(filename)
+	out, err := ioutil.TempFile(dir, "."+base)
+	if err != nil {
+		return err
+	}
+	s, err := newSealWriter(out, k)
+	if err == nil {
+		_, err = io.Copy(s, in)
+	}
+	if err == nil {
+		err = s.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	os.Remove(filename + TextSuffix)
+	os.Remove(filename + IndexSuffix)
+	m, err := LoadMeta(filename)
+	if err != nil {
+		return err
+	}
+	m.Key = k.Current
+	return m.Save(filename)
+}
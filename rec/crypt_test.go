@@ -0,0 +1,206 @@
+package rec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "k1 000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"
+	testKey2 = "k2 1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100\n"
+)
+
+func setTestKeys(t *testing.T, dir, keys string) {
+	file := dir + "/keys"
+	if err := ioutil.WriteFile(file, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyring(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(k)
+}
+
+func TestEncryptedRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+
+	setTestKeys(t, dir, "# the first key is current\n"+testKey1)
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("0$ export PASSWORD=hunter2\r\n"))
+	// more than a chunk
+	big := strings.Repeat("x", cryptChunk+100)
+	r.Write([]byte("0" + big))
+	r.Write([]byte("0\r\ndone\r\n"))
+	r.Close()
+
+	raw, _ := ioutil.ReadFile(r.FileName)
+	if !bytes.HasPrefix(raw, []byte(cryptMagic)) || bytes.Contains(raw, []byte("hunter2")) {
+		t.Fatal("recording is not encrypted")
+	}
+	if _, err := os.Stat(r.FileName + TextSuffix); !os.IsNotExist(err) {
+		t.Fatal("plain text of an encrypted recording kept")
+	}
+	if m, _ := LoadMeta(r.FileName); m.Key != "k1" {
+		t.Fatalf("meta %+v", m)
+	}
+	if v, err := Verify(r.FileName); err != nil || !v.Ok() || v.Frames != 4 {
+		t.Fatalf("verify %v %v", v, err)
+	}
+	p, err := NewPlayer(r.FileName, 64, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := readAll(p); out != "$ export PASSWORD=hunter2\r\n"+big+"\r\ndone\r\n" {
+		t.Fatalf("played %d bytes", len(out))
+	}
+	p.Close()
+
+	// k2 becomes current, k1 still decrypts until the recording is rekeyed
+	setTestKeys(t, dir, testKey2+testKey1)
+	if v, err := Verify(r.FileName); err != nil || !v.Ok() {
+		t.Fatalf("verify with an old key %v %v", v, err)
+	}
+	if err := Rekey(r.FileName); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := KeyId(r.FileName); id != "k2" {
+		t.Fatalf("rekeyed with %q", id)
+	}
+	if m, _ := LoadMeta(r.FileName); m.Key != "k2" {
+		t.Fatalf("meta %+v", m)
+	}
+	setTestKeys(t, dir, testKey2)
+	if v, err := Verify(r.FileName); err != nil || !v.Ok() || v.Frames != 4 {
+		t.Fatalf("verify after rekey %v %v", v, err)
+	}
+
+	// a recording cut short keeps its whole chunks
+	raw, _ = ioutil.ReadFile(r.FileName)
+	cut := dir + "/cut"
+	ioutil.WriteFile(cut, raw[:len(raw)-10], 0600)
+	if v, err := Verify(cut); err != nil || v.Ok() || v.Frames == 0 {
+		t.Fatalf("verify truncated %v %v", v, err)
+	}
+	// so does one which lost its last chunk
+	h, _ := readCryptHeader(bytes.NewReader(raw))
+	last := len(h)
+	for off := last; off < len(raw); off += 4 + int(binary.BigEndian.Uint32(raw[off:])) {
+		last = off
+	}
+	ioutil.WriteFile(cut, raw[:last], 0600)
+	if v, err := Verify(cut); err != nil || !v.Truncated || v.Frames == 0 {
+		t.Fatalf("verify without the last chunk %v %v", v, err)
+	}
+	// a changed byte fails authentication
+	raw[len(raw)-20] ^= 1
+	bad := dir + "/bad"
+	ioutil.WriteFile(bad, raw, 0600)
+	if v, err := Verify(bad); err == nil && v.Err == nil {
+		t.Fatalf("verify tampered %v", v)
+	}
+
+	SetKeyring(nil)
+	if _, err := OpenRecording(r.FileName); err == nil {
+		t.Fatal("opened without keys")
+	}
+}
+
+func TestRekeyPlain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+
+	r, err := NewRecorder(FORMAT_ASCIICAST, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte(`2{"Columns":80,"Rows":24}`))
+	r.Write([]byte("0secret\r\n"))
+	r.Close()
+	if _, err := os.Stat(r.FileName + TextSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	setTestKeys(t, dir, testKey1)
+	if err := Rekey(r.FileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(r.FileName + TextSuffix); !os.IsNotExist(err) {
+		t.Fatal("plain text kept")
+	}
+	if err := BuildTextIndex(r.FileName); err == nil {
+		t.Fatal("encrypted recording indexed")
+	}
+	dst := dir + "/out.txt"
+	if err := Export(r.FileName, dst, EXPORT_TEXT, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "[00:00.000] secret\n" {
+		t.Fatalf("exported %q", b)
+	}
+}
+
+func TestEncryptedV1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+	setTestKeys(t, dir, testKey1)
+
+	// sealed with the key itself and a nonce prefix, without a last chunk
+	prefix := []byte{1, 2, 3, 4}
+	h := append([]byte(cryptMagic), cryptVersionV1, 2)
+	h = append(append(h, "k1"...), prefix...)
+	block, _ := aes.NewCipher(getKeyring().keys["k1"])
+	aead, _ := cipher.NewGCM(block)
+	file := append([]byte(nil), h...)
+	for i, p := range []string{"hello ", "world"} {
+		c := aead.Seal(nil, cryptNonce(aead, prefix, uint64(i), false), []byte(p), h)
+		var lb [4]byte
+		binary.BigEndian.PutUint32(lb[:], uint32(len(c)))
+		file = append(append(file, lb[:]...), c...)
+	}
+	name := dir + "/v1"
+	ioutil.WriteFile(name, file, 0600)
+
+	f, err := OpenRecording(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "hello world" {
+		t.Fatalf("read %q %v", b, err)
+	}
+
+	// and is moved to the current format
+	if need, _ := NeedsRekey(name); !need {
+		t.Fatal("version 1 recording not rekeyed")
+	}
+	ioutil.WriteFile(name+MetaSuffix, []byte(`{"id":"v1"}`), 0600)
+	if err := Rekey(name); err != nil {
+		t.Fatal(err)
+	}
+	if need, _ := NeedsRekey(name); need {
+		t.Fatal("rekeyed recording needs a rekey")
+	}
+}
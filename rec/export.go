@@ -0,0 +1,433 @@
+package rec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"os"
+)
+
+// formats of Export
+const (
+	EXPORT_JSON = "json" // asciicast v1, as Convert
+	EXPORT_TEXT = "text"
+	EXPORT_HTML = "html"
+	EXPORT_SVG  = "svg"
+	EXPORT_GIF  = "gif"
+)
+
+// exportEvent is an output, a resize (if cols > 0), an input (if in is
+// set) or a chat message (if chat is set) of a recording, time is in
+// nanoseconds since the first output with idle time cut
+type exportEvent struct {
+	time       int64
+	cols, rows int
+	data       []byte
+	in         *ArgUserInput
+	chat       *ArgChat
+}
+
+type exportRec struct {
+	// terminal size at the first output
+	cols, rows int
+	command    string
+	events     []exportEvent
+	duration   int64
+}
+
+// Export writes recording src to dst in format, pauses longer than wait
+// seconds (if > 0) are cut to wait. The text and html transcripts have
+// the chat of the session and the lines typed by the participants input
+// selects, see MatchInput.
+func Export(src, dst, format string, wait int64, input string) error {
+	var write func(e *exportRec, w io.Writer) error
+
+	switch format {
+	case EXPORT_JSON, "":
+		return Convert(src, dst, wait)
+	case EXPORT_TEXT:
+		write = (*exportRec).writeText
+	case EXPORT_HTML:
+		write = (*exportRec).writeHtml
+	case EXPORT_SVG:
+		write = (*exportRec).writeSvg
+	case EXPORT_GIF:
+		write = (*exportRec).writeGif
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	e, err := loadExport(src, wait, input)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = write(e, w); err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func loadExport(src string, wait int64, input string) (*exportRec, error) {
+	f, err := OpenRecording(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec, err := NewDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &exportRec{cols: 80, rows: 24}
+	maxWait := wait * 1000000000
+	started := false
+	var last int64
+	// input and chat do not move the clock, the output after them does
+	between := func(t int64) int64 {
+		if !started {
+			return 0
+		}
+		delta := t - last
+		if maxWait > 0 && delta > maxWait {
+			delta = maxWait
+		}
+		return e.duration + delta
+	}
+	for {
+		var d RecData
+		if err := dec.Decode(&d); err != nil {
+			if err != io.EOF {
+				// keep the frames before a truncated or corrupt one
+				fmt.Fprintf(os.Stderr, "%s: %v, exported up to here\n",
+					src, err)
+			}
+			break
+		}
+		if len(d.Data) == 0 {
+			continue
+		}
+
+		switch d.Data[0] {
+		case ResizeTerminal:
+			var args ArgResizeTerminal
+			if json.Unmarshal(d.Data[1:], &args) != nil || args.Columns < 1 ||
+				args.Rows < 1 {
+				continue
+			}
+			if !started {
+				e.cols, e.rows = int(args.Columns), int(args.Rows)
+			} else {
+				e.events = append(e.events, exportEvent{time: e.duration,
+					cols: int(args.Columns), rows: int(args.Rows)})
+			}
+		case SysEnv:
+			var args ArgEnvTerminal
+			if json.Unmarshal(d.Data[1:], &args) == nil {
+				e.command = args.Command
+			}
+		case Output:
+			if !started {
+				started = true
+				last = d.Time
+			}
+			delta := d.Time - last
+			if maxWait > 0 && delta > maxWait {
+				delta = maxWait
+			}
+			last = d.Time
+			e.duration += delta
+			e.events = append(e.events, exportEvent{time: e.duration,
+				data: d.Data[1:]})
+		case UserInput:
+			in := &ArgUserInput{}
+			if input == "" || json.Unmarshal(d.Data[1:], in) != nil ||
+				!MatchInput(input, in) {
+				continue
+			}
+			e.events = append(e.events, exportEvent{time: between(d.Time),
+				in: in})
+		case Chat:
+			c := &ArgChat{}
+			if json.Unmarshal(d.Data[1:], c) != nil {
+				continue
+			}
+			e.events = append(e.events, exportEvent{time: between(d.Time),
+				chat: c})
+		}
+	}
+	return e, nil
+}
+
+// screenFrame is the screen shown from time on
+type screenFrame struct {
+	time  int64
+	cols  int
+	rows  int
+	lines [][]Cell
+}
+
+func (f *screenFrame) equal(o *screenFrame) bool {
+	if f.cols != o.cols || f.rows != o.rows {
+		return false
+	}
+	for y, line := range f.lines {
+		for x, c := range line {
+			if c != o.lines[y][x] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// screenFrames replays e through a VT, the output of interval
+// nanoseconds is shown at once. It returns the frames which differ from
+// the one before and the largest terminal size.
+func (e *exportRec) screenFrames(interval int64) ([]*screenFrame, int, int) {
+	vt := NewVT(e.cols, e.rows)
+	cols, rows := vt.Cols, vt.Rows
+	frames := []*screenFrame{}
+
+	snap := func(t int64) {
+		f := &screenFrame{time: t, cols: vt.Cols, rows: vt.Rows,
+			lines: make([][]Cell, vt.Rows)}
+		for y := range f.lines {
+			f.lines[y] = append([]Cell(nil), vt.Line(y)...)
+		}
+		if n := len(frames); n > 0 && frames[n-1].equal(f) {
+			return
+		}
+		frames = append(frames, f)
+	}
+
+	first, last := int64(-1), int64(0)
+	for _, ev := range e.events {
+		if first >= 0 && ev.time-first >= interval {
+			snap(last)
+			first = -1
+		}
+		if first < 0 {
+			first = ev.time
+		}
+		last = ev.time
+		if ev.cols > 0 {
+			vt.Resize(ev.cols, ev.rows)
+			if vt.Cols > cols {
+				cols = vt.Cols
+			}
+			if vt.Rows > rows {
+				rows = vt.Rows
+			}
+		} else if ev.in == nil {
+			vt.Write(ev.data)
+		}
+	}
+	if first >= 0 || len(frames) == 0 {
+		snap(last)
+	}
+	return frames, cols, rows
+}
+
+// clock formats nanoseconds as [h:]mm:ss.mmm
+func clock(d int64) string {
+	ms := d / 1000000
+	s := fmt.Sprintf("%02d:%02d.%03d", ms/60000%60, ms/1000%60, ms%1000)
+	if ms >= 3600000 {
+		s = fmt.Sprintf("%d:%s", ms/3600000, s)
+	}
+	return s
+}
+
+// transcript calls line with every line of text of the output, every
+// line a participant typed and every chat message, kind is the frame
+// type and who the participant of input and chat
+func (e *exportRec) transcript(line func(t int64, kind byte, who string, text []byte) error) error {
+	// entered input and chat wait for the output line they were sent on
+	type queued struct {
+		t    int64
+		kind byte
+		who  string
+		text []byte
+	}
+	var queue []queued
+	drain := func() error {
+		for _, q := range queue {
+			if err := line(q.t, q.kind, q.who, q.text); err != nil {
+				return err
+			}
+		}
+		queue = queue[:0]
+		return nil
+	}
+	a := &ansiStripper{emit: func(t int64, text []byte) error {
+		if err := line(t, Output, "", text); err != nil {
+			return err
+		}
+		return drain()
+	}}
+	typing := make(map[string][]rune)
+	var order []string
+	for _, ev := range e.events {
+		switch {
+		case ev.chat != nil:
+			queue = append(queue, queued{ev.time, Chat,
+				ev.chat.Participant(), []byte(ev.chat.Text)})
+		case ev.in != nil:
+			who := ev.in.Participant()
+			if _, ok := typing[who]; !ok {
+				order = append(order, who)
+			}
+			typed := typing[who]
+			for _, r := range ev.in.Data {
+				switch r {
+				case '\r', '\n':
+					queue = append(queue, queued{ev.time, UserInput, who,
+						[]byte(string(typed))})
+					typed = typed[:0]
+				case '\b', 0x7f:
+					if len(typed) > 0 {
+						typed = typed[:len(typed)-1]
+					}
+				default:
+					typed = append(typed, []rune(InputText(string(r)))...)
+				}
+			}
+			typing[who] = typed
+		case ev.cols == 0:
+			if err := a.write(ev.time, ev.data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := a.flush(); err != nil {
+		return err
+	}
+	if err := drain(); err != nil {
+		return err
+	}
+	// what was typed but not entered
+	for _, who := range order {
+		if len(typing[who]) > 0 {
+			if err := line(e.duration, UserInput, who,
+				[]byte(string(typing[who]))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *exportRec) writeText(w io.Writer) error {
+	return e.transcript(func(t int64, kind byte, who string, text []byte) error {
+		var err error
+		switch kind {
+		case UserInput:
+			_, err = fmt.Fprintf(w, "[%s] %s> %s\n", clock(t), who, text)
+		case Chat:
+			_, err = fmt.Fprintf(w, "[%s] %s: %s\n", clock(t), who, text)
+		default:
+			_, err = fmt.Fprintf(w, "[%s] %s\n", clock(t), text)
+		}
+		return err
+	})
+}
+
+func (e *exportRec) writeHtml(w io.Writer) error {
+	title := html.EscapeString(e.command)
+	fmt.Fprintf(w, `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body {font-family: monospace; background: #fff; color: #000;}
+td {vertical-align: top; white-space: pre-wrap; padding: 0 1em 0 0;}
+td.t {color: #888;}
+td.i {color: #00c;}
+td.c {color: #080; font-style: italic;}
+</style>
+</head>
+<body>
+<h3>%s</h3>
+<p>%s</p>
+<table>
+`, title, title, clock(e.duration))
+	err := e.transcript(func(t int64, kind byte, who string, text []byte) error {
+		var err error
+		switch kind {
+		case UserInput:
+			_, err = fmt.Fprintf(w, "<tr><td class=\"t\">%s</td><td class=\"i\">%s&gt; %s</td></tr>\n",
+				clock(t), html.EscapeString(who), html.EscapeString(string(text)))
+		case Chat:
+			_, err = fmt.Fprintf(w, "<tr><td class=\"t\">%s</td><td class=\"c\">%s: %s</td></tr>\n",
+				clock(t), html.EscapeString(who), html.EscapeString(string(text)))
+		default:
+			_, err = fmt.Fprintf(w, "<tr><td class=\"t\">%s</td><td>%s</td></tr>\n",
+				clock(t), html.EscapeString(string(text)))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "</table>\n</body>\n</html>\n")
+	return err
+}
+
+// xtermPalette is the xterm 256 color palette, the default foreground
+// and background are colors 7 and 0
+var xtermPalette = func() color.Palette {
+	base := []uint32{
+		0x000000, 0xcd0000, 0x00cd00, 0xcdcd00,
+		0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+		0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00,
+		0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+	}
+	p := make(color.Palette, 0, 256)
+	for _, c := range base {
+		p = append(p, color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xff})
+	}
+	levels := []uint8{0, 95, 135, 175, 215, 255}
+	for i := 0; i < 216; i++ {
+		p = append(p, color.RGBA{levels[i/36], levels[i/6%6], levels[i%6], 0xff})
+	}
+	for i := 0; i < 24; i++ {
+		g := uint8(8 + 10*i)
+		p = append(p, color.RGBA{g, g, g, 0xff})
+	}
+	return p
+}()
+
+func resolveColor(c int32, def int32) color.RGBA {
+	if c == COLOR_DEFAULT {
+		c = def
+	}
+	if c&COLOR_RGB != 0 {
+		return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xff}
+	}
+	return xtermPalette[c&0xff].(color.RGBA)
+}
+
+// colors returns the foreground and background a cell is drawn with
+func (a Attr) colors() (fg, bg color.RGBA) {
+	f := a.Fg
+	if a.Bold && f >= 0 && f < 8 {
+		f += 8
+	}
+	fg, bg = resolveColor(f, 7), resolveColor(a.Bg, 0)
+	if a.Reverse {
+		fg, bg = bg, fg
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package rec
+
+import (
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestVT(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want string
+	}{
+		{"hello\r\nworld", "hello\nworld"},
+		{"abc\x1b[2Dx", "axc"},
+		{"\x1b[3;4Hx\x1b[Hy", "y\n\n   x"},
+		{"abcdef\x1b[1;3H\x1b[K", "ab"},
+		{"one\r\ntwo\x1b[2J\x1b[Hthree", "three"},
+		// the last column wraps on the next character only
+		{"0123456789ab", "0123456789\nab"},
+		// the alternate screen is dropped when the program exits
+		{"$ vi\x1b[?1049h\x1b[2J\x1b[Hfile\x1b[?1049l\r\n$", "$ vi\n$"},
+		{"\x1b[1;31mred\x1b[0m ümlaut", "red ümlaut"},
+	} {
+		vt := NewVT(10, 4)
+		vt.Write([]byte(c.in))
+		if got := vt.String(); got != c.want {
+			t.Errorf("%q: screen %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	vt := NewVT(10, 3)
+	vt.Write([]byte("1\r\n2\r\n3\r\n4"))
+	if got := vt.String(); got != "2\n3\n4" {
+		t.Errorf("scrolled screen %q", got)
+	}
+	vt.Write([]byte("\x1b[1;31;44mx"))
+	if c := vt.Line(2)[1]; c.Rune != 'x' || !c.Bold || c.Fg != 1 || c.Bg != 4 {
+		t.Errorf("cell %+v", c)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const s = 1000000000
+	src := dir + "/rec"
+	writeGob(t, src, []RecData{
+		{0, []byte(`3{"TERM":"xterm","COMMAND":"bash"}`)},
+		{0, []byte(`2{"columns":20,"rows":5}`)},
+		{1 * s, []byte("0$ ls\r\n")},
+		// an hour later
+		{3601 * s, []byte("0\x1b[32mREADME\x1b[0m <a&b>\r\n")},
+	})
+
+	export := func(format string, wait int64) string {
+		dst := path.Join(dir, "out."+format)
+		if err := Export(src, dst, format, wait, ""); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		b, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if got, want := export(EXPORT_TEXT, 0),
+		"[00:00.000] $ ls\n[1:00:00.000] README <a&b>\n"; got != want {
+		t.Errorf("text %q, want %q", got, want)
+	}
+	if got, want := export(EXPORT_TEXT, 2),
+		"[00:00.000] $ ls\n[00:02.000] README <a&b>\n"; got != want {
+		t.Errorf("text with max wait %q, want %q", got, want)
+	}
+	if got := export(EXPORT_HTML, 2); !strings.Contains(got,
+		"<td class=\"t\">00:02.000</td><td>README &lt;a&amp;b&gt;</td>") {
+		t.Errorf("html %s", got)
+	}
+
+	svg := export(EXPORT_SVG, 2)
+	for _, s := range []string{`width="168.0" height="85"`, "&lt;a&amp;b&gt;",
+		`fill="#00cd00">README`, `begin="2.000s"`} {
+		if !strings.Contains(svg, s) {
+			t.Errorf("svg has no %s: %s", s, svg)
+		}
+	}
+
+	if err := Export(src, path.Join(dir, "out.gif"), EXPORT_GIF, 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join(dir, "out.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 20*glyphWidth || g.Config.Height != 5*glyphHeight ||
+		len(g.Image) != 2 || g.Delay[0] != 200 {
+		t.Errorf("gif %dx%d, %d frames, delays %v", g.Config.Width,
+			g.Config.Height, len(g.Image), g.Delay)
+	}
+
+	if err := Export(src, path.Join(dir, "out.x"), "png", 0, ""); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
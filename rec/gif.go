@@ -0,0 +1,114 @@
+package rec
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// gif frames are at least this far apart (nanoseconds), the last one
+// is shown for gifHold hundredths of a second
+const (
+	gifInterval = 100000000
+	gifHold     = 200
+)
+
+// paletteIndex finds the palette color of c, exact for the 256 colors
+// a terminal names and the nearest for true colors
+func paletteIndex(cache map[color.RGBA]uint8, c color.RGBA) uint8 {
+	if i, ok := cache[c]; ok {
+		return i
+	}
+	i := uint8(xtermPalette.Index(c))
+	cache[c] = i
+	return i
+}
+
+// drawCell draws c at column x, row y of img
+func drawCell(img *image.Paletted, cache map[color.RGBA]uint8, x, y int, c Cell) {
+	fgc, bgc := c.colors()
+	fg, bg := paletteIndex(cache, fgc), paletteIndex(cache, bgc)
+	g := glyphOf(c.Rune)
+	x0, y0 := x*glyphWidth, y*glyphHeight
+	for gy := 0; gy < glyphHeight; gy++ {
+		// the glyph's 6 pixels and a blank column, bit 6 is the leftmost
+		bits := g[gy] << 1
+		if c.Bold {
+			bits |= bits >> 1
+		}
+		if c.Underline && gy == glyphHeight-2 {
+			bits = 0x7f
+		}
+		off := img.PixOffset(x0, y0+gy)
+		for gx := 0; gx < glyphWidth; gx++ {
+			pix := bg
+			if bits&(0x40>>uint(gx)) != 0 {
+				pix = fg
+			}
+			img.Pix[off+gx] = pix
+		}
+	}
+}
+
+func (e *exportRec) writeGif(w io.Writer) error {
+	frames, cols, rows := e.screenFrames(gifInterval)
+	anim := &gif.GIF{Config: image.Config{ColorModel: xtermPalette,
+		Width: cols * glyphWidth, Height: rows * glyphHeight}}
+	cache := make(map[color.RGBA]uint8)
+	blank := Cell{Rune: ' ', Attr: defaultAttr}
+
+	// the screen drawn so far, each frame only covers what changed
+	screen := make([][]Cell, rows)
+	for y := range screen {
+		screen[y] = make([]Cell, cols)
+	}
+	for i, f := range frames {
+		cell := func(x, y int) Cell {
+			if y < f.rows && x < f.cols {
+				return f.lines[y][x]
+			}
+			return blank
+		}
+		x0, y0, x1, y1 := cols, rows, 0, 0
+		for y := 0; y < rows; y++ {
+			for x := 0; x < cols; x++ {
+				if c := cell(x, y); i == 0 || c != screen[y][x] {
+					screen[y][x] = c
+					if x < x0 {
+						x0 = x
+					}
+					if x >= x1 {
+						x1 = x + 1
+					}
+					if y < y0 {
+						y0 = y
+					}
+					y1 = y + 1
+				}
+			}
+		}
+		if x1 == 0 {
+			// nothing changed, e.g. the first frame repeated
+			continue
+		}
+
+		img := image.NewPaletted(image.Rect(x0*glyphWidth, y0*glyphHeight,
+			x1*glyphWidth, y1*glyphHeight), xtermPalette)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				drawCell(img, cache, x, y, screen[y][x])
+			}
+		}
+		delay := gifHold
+		if i+1 < len(frames) {
+			delay = int((frames[i+1].time - f.time) / 10000000)
+		}
+		if delay < 2 {
+			delay = 2
+		}
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
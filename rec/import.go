@@ -0,0 +1,449 @@
+package rec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats Import reads
+const (
+	IMPORT_ASCIICAST = "asciicast" // v1 or v2
+	IMPORT_TTYREC    = "ttyrec"
+	IMPORT_SCRIPT    = "script" // util-linux script with a timing file
+)
+
+// ImportOptions describe the recording to import, the zero value
+// detects the format and uses the defaults
+type ImportOptions struct {
+	Format string
+	// the timing file of a script typescript, default SRC.timing
+	Timing string
+	// the terminal size of formats which don't record it, default 80x24
+	Columns int
+	Rows    int
+	// session name of the imported recording, default the file name
+	Name string
+}
+
+// DetectFormat guesses the format of the recording src
+func DetectFormat(src string) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b := make([]byte, 64)
+	n, _ := io.ReadFull(f, b)
+	b = b[:n]
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")):
+		return IMPORT_ASCIICAST, nil
+	case bytes.HasPrefix(b, []byte("Script started")):
+		return IMPORT_SCRIPT, nil
+	}
+	if _, err := os.Stat(src + ".timing"); err == nil {
+		return IMPORT_SCRIPT, nil
+	}
+	if isTtyrec(f) {
+		return IMPORT_TTYREC, nil
+	}
+	return "", fmt.Errorf("%s: unknown recording format", src)
+}
+
+// isTtyrec checks the headers of the first frames of f
+func isTtyrec(f *os.File) bool {
+	br := bufio.NewReader(io.NewSectionReader(f, 0, 1<<30))
+	var h ttyrecHeader
+	var last uint32
+	for i := 0; i < 3; i++ {
+		if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+			return i > 0 && err == io.EOF
+		}
+		if h.Usec >= 1000000 || h.Len > 1<<24 || h.Sec < last {
+			return false
+		}
+		last = h.Sec
+		if _, err := br.Discard(int(h.Len)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportFrames reads a recording of another program as the frames of
+// a gob recording, times are unix nanoseconds
+func ImportFrames(src string, opt *ImportOptions) ([]RecData, error) {
+	if opt == nil {
+		opt = &ImportOptions{}
+	}
+	format := opt.Format
+	if format == "" && opt.Timing != "" {
+		format = IMPORT_SCRIPT
+	} else if format == "" {
+		var err error
+		if format, err = DetectFormat(src); err != nil {
+			return nil, err
+		}
+	}
+
+	var frames []RecData
+	var err error
+	switch format {
+	case IMPORT_ASCIICAST:
+		frames, err = importCast(src)
+	case IMPORT_TTYREC:
+		frames, err = importTtyrec(src, opt)
+	case IMPORT_SCRIPT:
+		frames, err = importScript(src, opt)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", src)
+	}
+	return frames, nil
+}
+
+// atMtime moves frames timed from 0 to end at the modification time of
+// src, for formats which don't record when they were made
+func atMtime(src string, frames []RecData) {
+	fi, err := os.Stat(src)
+	if err != nil || len(frames) == 0 {
+		return
+	}
+	shift := fi.ModTime().UnixNano() - frames[len(frames)-1].Time
+	for i := range frames {
+		frames[i].Time += shift
+	}
+}
+
+func envFrame(t int64, env ArgEnvTerminal) RecData {
+	buf, _ := json.Marshal(env)
+	return RecData{Time: t, Data: append([]byte{SysEnv}, buf...)}
+}
+
+func resizeFrame(t int64, cols, rows int) RecData {
+	buf, _ := json.Marshal(ArgResizeTerminal{Columns: float64(cols),
+		Rows: float64(rows)})
+	return RecData{Time: t, Data: append([]byte{ResizeTerminal}, buf...)}
+}
+
+func outputFrame(t int64, d []byte) RecData {
+	return RecData{Time: t, Data: append([]byte{Output}, d...)}
+}
+
+// castV1 is an asciicast v1 file, a single json object
+type castV1 struct {
+	Version int               `json:"version"`
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Command string            `json:"command"`
+	Title   string            `json:"title"`
+	Env     map[string]string `json:"env"`
+	Stdout  [][]interface{}   `json:"stdout"`
+}
+
+func importCast(src string) ([]RecData, error) {
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var v1 castV1
+	if json.Unmarshal(buf, &v1) == nil && v1.Version == 1 {
+		frames := []RecData{
+			envFrame(0, ArgEnvTerminal{Term: v1.Env["TERM"],
+				Shell: v1.Env["SHELL"], Command: v1.Command}),
+			resizeFrame(0, v1.Width, v1.Height),
+		}
+		var t float64
+		for _, ev := range v1.Stdout {
+			if len(ev) != 2 {
+				return nil, fmt.Errorf("malformed asciicast frame %v", ev)
+			}
+			delay, _ := ev[0].(float64)
+			data, _ := ev[1].(string)
+			t += delay
+			frames = append(frames, outputFrame(int64(t*1e9), []byte(data)))
+		}
+		atMtime(src, frames)
+		return frames, nil
+	}
+
+	// v2, one event per line
+	dec, err := newCastDecoder(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	var frames []RecData
+	for {
+		var d RecData
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		frames = append(frames, d)
+	}
+	if dec.start == 0 {
+		atMtime(src, frames)
+	}
+	return frames, nil
+}
+
+// ttyrecHeader precedes the output of every ttyrec frame
+type ttyrecHeader struct {
+	Sec  uint32
+	Usec uint32
+	Len  uint32
+}
+
+func importTtyrec(src string, opt *ImportOptions) ([]RecData, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var frames []RecData
+	for {
+		var h ttyrecHeader
+		if err := binary.Read(br, binary.LittleEndian, &h); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ttyrec header: %v", err)
+		}
+		if h.Len > 1<<24 {
+			return nil, fmt.Errorf("ttyrec frame of %d bytes", h.Len)
+		}
+		d := make([]byte, h.Len)
+		if _, err := io.ReadFull(br, d); err != nil {
+			// keep the frames of a recording cut short
+			break
+		}
+		t := int64(h.Sec)*1e9 + int64(h.Usec)*1e3
+		if len(frames) == 0 {
+			cols, rows := opt.size()
+			frames = append(frames, envFrame(t, ArgEnvTerminal{}),
+				resizeFrame(t, cols, rows))
+		}
+		frames = append(frames, outputFrame(t, d))
+	}
+	return frames, nil
+}
+
+func (opt *ImportOptions) size() (int, int) {
+	cols, rows := opt.Columns, opt.Rows
+	if cols <= 0 {
+		cols = 80
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+	return cols, rows
+}
+
+// the header line of a typescript, e.g.
+// Script started on 2020-01-02 10:00:00+01:00 [COMMAND="top" TERM="xterm" COLUMNS="80" LINES="24"]
+var scriptAttr = regexp.MustCompile(`([A-Z_]+)="([^"]*)"`)
+
+// layouts of START_TIME in the header of an advanced timing file
+var scriptTimeLayouts = []string{
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	time.RFC3339,
+}
+
+// importScript reads a typescript and its timing file, both the classic
+// "DELAY BYTES" and the advanced (script -T -m advanced) timing lines,
+// input in an advanced log is skipped
+func importScript(src string, opt *ImportOptions) ([]RecData, error) {
+	out, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	timing := opt.Timing
+	if timing == "" {
+		timing = src + ".timing"
+	}
+	tf, err := os.Open(timing)
+	if err != nil {
+		return nil, fmt.Errorf("%v, the timing file is set with -timing", err)
+	}
+	defer tf.Close()
+
+	cols, rows := opt.size()
+	env := ArgEnvTerminal{}
+	if bytes.HasPrefix(out, []byte("Script started")) {
+		i := bytes.IndexByte(out, '\n')
+		if i < 0 {
+			i = len(out) - 1
+		}
+		for _, m := range scriptAttr.FindAllStringSubmatch(string(out[:i]), -1) {
+			scriptHeader(m[1], m[2], &env, &cols, &rows)
+		}
+		out = out[i+1:]
+	}
+
+	var frames []RecData
+	var t, start int64
+	sc := bufio.NewScanner(tf)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		typ := "O"
+		if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+			typ, fields = fields[0], fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: malformed timing", timing, n)
+		}
+		delay, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", timing, n, err)
+		}
+		t += int64(delay * 1e9)
+
+		switch typ {
+		case "O":
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("%s:%d: malformed timing", timing, n)
+			}
+			if size > len(out) {
+				// the typescript ends before its timing
+				size = len(out)
+			}
+			if len(frames) == 0 {
+				frames = append(frames, envFrame(t, env),
+					resizeFrame(t, cols, rows))
+			}
+			frames = append(frames, outputFrame(t, out[:size]))
+			out = out[size:]
+		case "S":
+			// S DELAY SIGWINCH ROWS=24 COLS=80
+			if fields[1] != "SIGWINCH" {
+				continue
+			}
+			for _, arg := range fields[2:] {
+				if v := strings.TrimPrefix(arg, "COLS="); v != arg {
+					cols, _ = strconv.Atoi(v)
+				} else if v := strings.TrimPrefix(arg, "ROWS="); v != arg {
+					rows, _ = strconv.Atoi(v)
+				}
+			}
+			if len(frames) > 0 {
+				frames = append(frames, resizeFrame(t, cols, rows))
+			}
+		case "H":
+			// H DELAY NAME VALUE
+			value := strings.Join(fields[2:], " ")
+			if fields[1] == "START_TIME" {
+				for _, layout := range scriptTimeLayouts {
+					if st, err := time.Parse(layout, value); err == nil {
+						start = st.UnixNano()
+						break
+					}
+				}
+			}
+			scriptHeader(fields[1], value, &env, &cols, &rows)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if start != 0 {
+		for i := range frames {
+			frames[i].Time += start
+		}
+	} else {
+		atMtime(src, frames)
+	}
+	return frames, nil
+}
+
+func scriptHeader(name, value string, env *ArgEnvTerminal, cols, rows *int) {
+	switch name {
+	case "COMMAND":
+		env.Command = value
+	case "TERM":
+		env.Term = value
+	case "SHELL":
+		env.Shell = value
+	case "COLUMNS":
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			*cols = n
+		}
+	case "LINES":
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			*rows = n
+		}
+	}
+}
+
+// Import converts the recording src of another program into a gob
+// recording in dir, with a sidecar and a text index like the ones
+// gotty makes, and returns its metadata
+func Import(src, dir string, opt *ImportOptions) (*Meta, error) {
+	if opt == nil {
+		opt = &ImportOptions{}
+	}
+	frames, err := ImportFrames(src, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRecorder(FORMAT_GOB, "", dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range frames {
+		if _, err = r.write(d.Time, d.Data); err != nil {
+			break
+		}
+		if d.Data[0] == SysEnv {
+			json.Unmarshal(d.Data[1:], &r.env)
+			r.Meta.Command = r.env.Command
+			r.Meta.User = r.env.User
+		}
+	}
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		Remove(r.FileName)
+		return nil, err
+	}
+
+	m := r.Meta
+	first, last := frames[0].Time, frames[len(frames)-1].Time
+	m.Start, m.End = first/1e9, last/1e9
+	m.Duration = nano2sec(last - first)
+	if m.Name = opt.Name; m.Name == "" {
+		m.Name = path.Base(src)
+	}
+	if err := r.SaveMeta(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
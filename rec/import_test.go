@@ -0,0 +1,114 @@
+package rec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	recs := path.Join(dir, "recs")
+	os.Mkdir(recs, 0755)
+
+	write := func(name, data string) string {
+		name = path.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	var ttyrec bytes.Buffer
+	for i, s := range []string{"$ ls\r\n", "a  b\r\n", "$ "} {
+		binary.Write(&ttyrec, binary.LittleEndian,
+			ttyrecHeader{1500000000 + uint32(i), 250000, uint32(len(s))})
+		ttyrec.WriteString(s)
+	}
+
+	for _, c := range []struct {
+		file     string
+		format   string
+		size     string
+		command  string
+		duration float64
+	}{
+		{write("v1.json", `{
+  "version": 1, "width": 100, "height": 30, "duration": 2.5,
+  "command": "/bin/zsh", "title": "", "env": {"TERM": "xterm"},
+  "stdout": [[0.5, "$ ls\r\n"], [1.5, "a  b\r\n"], [0.5, "$ "]]
+}`), IMPORT_ASCIICAST, "100x30", "/bin/zsh", 2.5},
+		{write("v2.cast", `{"version": 2, "width": 90, "height": 20, "timestamp": 1500000000}
+[0.5, "o", "$ ls\r\n"]
+[1.0, "i", "ls\r"]
+[2.0, "o", "a  b\r\n"]
+[2.5, "r", "120x40"]
+[2.5, "o", "$ "]
+`), IMPORT_ASCIICAST, "120x40", "", 2.5},
+		{write("ttyrec", ttyrec.String()), IMPORT_TTYREC, "80x24", "", 2},
+		{write("typescript", "Script started on 2020-01-02 10:00:00+01:00 "+
+			`[COMMAND="htop" TERM="xterm" COLUMNS="132" LINES="43"]`+
+			"\n$ ls\r\na  b\r\n$ \nScript done on 2020-01-02 10:00:03+01:00\n"),
+			IMPORT_SCRIPT, "132x43", "htop", 2},
+	} {
+		if c.format == IMPORT_SCRIPT {
+			write("typescript.timing", "0.5 6\n1.5 6\n0.5 2\n")
+		}
+		format, err := DetectFormat(c.file)
+		if err != nil || format != c.format {
+			t.Errorf("%s: detected %q %v", c.file, format, err)
+		}
+
+		m, err := Import(c.file, recs, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		if m.Name != path.Base(c.file) || m.Command != c.command ||
+			m.Duration != c.duration || m.Start == 0 {
+			t.Errorf("%s: meta %+v", c.file, m)
+		}
+		if c.format == IMPORT_TTYREC && m.Start != 1500000000 {
+			t.Errorf("%s: start %d", c.file, m.Start)
+		}
+
+		// the imported recording plays, is indexed and has a sidecar
+		filename := path.Join(recs, m.Id)
+		if v, err := Verify(filename); err != nil || !v.Ok() {
+			t.Errorf("%s: verify %v %v", c.file, v, err)
+		}
+		p, err := NewPlayer(filename, 64, false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := readAll(p)
+		p.Close()
+		if !strings.Contains(out, "$ ls\r\na  b\r\n$ ") {
+			t.Errorf("%s: played %q", c.file, out)
+		}
+		// from the first output to the last
+		if st := p.State(); st.Duration != 2 {
+			t.Errorf("%s: played duration %v", c.file, st.Duration)
+		}
+		if size := fmt.Sprintf("%dx%d", m.Columns, m.Rows); size != c.size {
+			t.Errorf("%s: size %s, want %s", c.file, size, c.size)
+		}
+		hits, _ := Search(recs, "a b", &MetaFilter{Name: path.Base(c.file)}, 0)
+		if len(hits) != 1 || hits[0].Id != m.Id {
+			t.Errorf("%s: hits %+v", c.file, hits)
+		}
+	}
+
+	if _, err := DetectFormat(write("junk", "\x00\x01junk")); err == nil {
+		t.Error("junk detected")
+	}
+}
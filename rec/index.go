@@ -0,0 +1,190 @@
+package rec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"io"
+)
+
+// IndexEntry locates one output frame of a recording
+type IndexEntry struct {
+	// byte offset of the frame (asciicast: of its line) in the file
+	Offset int64
+	// nanoseconds since the first output frame
+	Time int64
+	// the frame clears the screen, playback can restart from here
+	Clear bool
+}
+
+// Index lists the output frames of a recording, so a player can seek
+// without decoding the whole file again
+type Index struct {
+	Entries  []IndexEntry
+	Duration int64
+}
+
+// sequences after which the earlier output no longer shows
+var clearSeqs = [][]byte{[]byte("\x1bc"), []byte("\x1b[2J")}
+
+func isClear(d []byte) bool {
+	for _, seq := range clearSeqs {
+		if bytes.Contains(d, seq) {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildIndex reads filename once and indexes its output frames, a
+// truncated recording is indexed up to the last whole frame
+func BuildIndex(filename string) (*Index, error) {
+	f, err := OpenRecording(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fr, err := newFrameReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{}
+	var first int64
+	var d RecData
+	for {
+		off, err := fr.next(&d)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.Data[0] != Output {
+			continue
+		}
+		if len(idx.Entries) == 0 {
+			first = d.Time
+		}
+		e := IndexEntry{Offset: off, Time: d.Time - first, Clear: isClear(d.Data[1:])}
+		idx.Entries = append(idx.Entries, e)
+		idx.Duration = e.Time
+	}
+}
+
+// countReader knows the file offset of the next byte it returns
+type countReader struct {
+	rs io.ReadSeeker
+	br *bufio.Reader
+	n  int64
+}
+
+func newCountReader(r io.Reader) *countReader {
+	c := &countReader{br: bufio.NewReader(r)}
+	c.rs, _ = r.(io.ReadSeeker)
+	return c
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.br.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.br.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (c *countReader) ReadBytes(delim byte) ([]byte, error) {
+	line, err := c.br.ReadBytes(delim)
+	c.n += int64(len(line))
+	return line, err
+}
+
+func (c *countReader) seek(off int64) error {
+	if _, err := c.rs.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	c.br.Reset(c.rs)
+	c.n = off
+	return nil
+}
+
+// frameReader decodes the frames of a gob or asciicast file and can
+// jump back to the offset of any frame it returned
+type frameReader struct {
+	cr   *countReader
+	gob  *gob.Decoder
+	cast *castDecoder
+	// the gob type has been received, later frames can be decoded alone
+	typed bool
+}
+
+func newFrameReader(f io.ReadSeeker) (*frameReader, error) {
+	fr := &frameReader{cr: newCountReader(f)}
+	return fr, fr.reset()
+}
+
+// reset starts over at the beginning of the file
+func (fr *frameReader) reset() error {
+	if err := fr.cr.seek(0); err != nil {
+		return err
+	}
+	fr.typed = false
+	b, err := fr.cr.br.Peek(len(castMagic))
+	if err != nil && len(b) == 0 {
+		return err
+	}
+	if string(b) == castMagic {
+		fr.gob = nil
+		fr.cast, err = newCastDecoder(fr.cr)
+		return err
+	}
+	fr.cast = nil
+	fr.gob = gob.NewDecoder(fr.cr)
+	return nil
+}
+
+// next decodes a frame and returns its offset
+func (fr *frameReader) next(d *RecData) (int64, error) {
+	if fr.cast != nil {
+		err := fr.cast.Decode(d)
+		return fr.cast.off, err
+	}
+	off := fr.cr.n
+	*d = RecData{}
+	err := fr.gob.Decode(d)
+	if err == nil {
+		fr.typed = true
+		if len(d.Data) == 0 {
+			return off, io.ErrUnexpectedEOF
+		}
+	}
+	return off, err
+}
+
+// seek makes the frame at off the next one
+func (fr *frameReader) seek(off int64) error {
+	if off == 0 {
+		return fr.reset()
+	}
+	if fr.cast != nil {
+		fr.cast.frames = nil
+		return fr.cr.seek(off)
+	}
+	if !fr.typed {
+		var d RecData
+		if err := fr.reset(); err != nil {
+			return err
+		}
+		if _, err := fr.next(&d); err != nil {
+			return err
+		}
+	}
+	return fr.cr.seek(off)
+}
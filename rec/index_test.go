@@ -0,0 +1,119 @@
+package rec
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeGob(t *testing.T, filename string, frames []RecData) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := gob.NewEncoder(f)
+	for _, d := range frames {
+		if err := enc.Encode(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readAll(p *Player) string {
+	var out []byte
+	b := make([]byte, 4)
+	for {
+		n, err := p.Read(b)
+		out = append(out, b[:n]...)
+		if err != nil {
+			return string(out)
+		}
+	}
+}
+
+func TestIndexSeek(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const s = 1000000000
+	file := dir + "/rec"
+	writeGob(t, file, []RecData{
+		{0, []byte(`3{"TERM":"xterm"}`)},
+		{1 * s, []byte("0one ")},
+		{2 * s, []byte("0two ")},
+		{3 * s, []byte("0\x1b[2Jthree ")},
+		{30 * s, []byte("0four ")},
+	})
+
+	idx, err := BuildIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Entries) != 4 || idx.Duration != 29*s || !idx.Entries[2].Clear ||
+		idx.Entries[1].Clear {
+		t.Fatalf("index %+v", idx)
+	}
+
+	// the 27s gap is cut to 2s
+	p, err := NewPlayer(file, 1000, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := p.State(); st.Duration != 4 || st.Position != 0 {
+		t.Fatalf("state %+v", st)
+	}
+	if out := readAll(p); out != "one two \x1b[2Jthree four " {
+		t.Fatalf("played %q", out)
+	}
+
+	if pt := p.PlayTime(29); pt != 4 {
+		t.Fatalf("play time of 29s is %v", pt)
+	}
+
+	// seek to 1.5s, between two and three: replay from the start
+	p, _ = NewPlayer(file, 1000, false, 2)
+	if _, err := p.Control(&PlayControl{Action: PLAY_SEEK, Time: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if out := readAll(p); out != "\x1bcone two \x1b[2Jthree four " {
+		t.Fatalf("seek 1.5: %q", out)
+	}
+
+	// seek past the clear: replay from it
+	p, _ = NewPlayer(file, 1000, false, 2)
+	p.Control(&PlayControl{Action: PLAY_SEEK, Time: 2.5})
+	if out := readAll(p); out != "\x1bc\x1b[2Jthree four " {
+		t.Fatalf("seek 2.5: %q", out)
+	}
+
+	// paused playback moves one frame per step
+	p, _ = NewPlayer(file, 1, false, 2)
+	st, _ := p.Control(&PlayControl{Action: PLAY_PAUSE})
+	if !st.Paused {
+		t.Fatalf("state %+v", st)
+	}
+	p.Control(&PlayControl{Action: PLAY_STEP})
+	b := make([]byte, 64)
+	if n, _ := p.Read(b); string(b[:n]) != "one " {
+		t.Fatalf("step: %q", b[:n])
+	}
+	if st := p.State(); st.Position != 0 || !st.Paused {
+		t.Fatalf("state after a step %+v", st)
+	}
+	if _, err := p.Control(&PlayControl{Action: PLAY_SPEED, Speed: 64}); err != nil {
+		t.Fatal(err)
+	}
+	p.Control(&PlayControl{Action: PLAY_RESUME})
+	if out := readAll(p); !strings.HasPrefix(out, "two ") {
+		t.Fatalf("resume: %q", out)
+	}
+	if _, err := p.Control(&PlayControl{Action: PLAY_SPEED}); err == nil {
+		t.Fatal("accepted speed 0")
+	}
+}
@@ -0,0 +1,99 @@
+package rec
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// INPUT_ALL shows the input of every participant, a filter is otherwise
+// a comma separated list of users or session names
+const INPUT_ALL = "*"
+
+// the player shows at most this much of a participant's line
+const maxTypedLine = 60
+
+// Participant names who typed, the user if known or the session
+func (in *ArgUserInput) Participant() string {
+	return participant(in.User, in.Name)
+}
+
+// Participant names who sent the message
+func (c *ArgChat) Participant() string {
+	return participant(c.User, c.Name)
+}
+
+func participant(user, name string) string {
+	if user != "" {
+		return user
+	}
+	if name != "" {
+		return name
+	}
+	return "?"
+}
+
+// MatchInput reports whether filter selects the participant of in
+func MatchInput(filter string, in *ArgUserInput) bool {
+	if filter == "" {
+		return false
+	}
+	for _, f := range strings.Split(filter, ",") {
+		f = strings.TrimSpace(f)
+		if f == INPUT_ALL || f != "" && (f == in.User || f == in.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// InputText makes typed keys printable, enter is "⏎" and other control
+// characters are shown as ^X
+func InputText(d string) string {
+	var b strings.Builder
+	for _, r := range d {
+		switch {
+		case r == '\r' || r == '\n':
+			b.WriteString("⏎")
+		case r == utf8.RuneError:
+			b.WriteString("�")
+		case r < 0x20:
+			b.WriteByte('^')
+			b.WriteByte(byte(r) + '@')
+		case r == 0x7f:
+			b.WriteString("^?")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// typed adds in to the line its participant is typing and returns the
+// window title showing it
+func (p *Player) typed(in *ArgUserInput) string {
+	if p.lines == nil {
+		p.lines = make(map[string]string)
+	}
+	who := in.Participant()
+	line := p.lines[who]
+	if strings.HasSuffix(line, "⏎") {
+		// the line before was entered
+		line = ""
+	}
+	line += InputText(in.Data)
+	p.lines[who] = line
+	if n := utf8.RuneCountInString(line); n > maxTypedLine {
+		line = "…" + string([]rune(line)[n-maxTypedLine:])
+	}
+	// the title ends at BEL or ESC
+	line = strings.NewReplacer("\x07", "", "\x1b", "").Replace(line)
+	return who + "> " + line
+}
+
+// ShowInput makes the player show the input of the participants filter
+// selects in the window title, as they type it
+func (p *Player) ShowInput(filter string) {
+	p.Lock()
+	defer p.Unlock()
+	p.input = filter
+}
@@ -0,0 +1,64 @@
+package rec
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUserInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("0$ "))
+	r.WriteInput(&ArgUserInput{User: "alice", Name: "a1", Data: "lx\x7fs"})
+	r.WriteInput(&ArgUserInput{Name: "guest", Data: "\x03"})
+	r.WriteInput(&ArgUserInput{User: "alice", Name: "a1", Data: "\r"})
+	r.Write([]byte("0ls\r\nfile\r\n$ "))
+	r.Write([]byte(`8{"user":"bob","name":"b1","text":"see file"}`))
+	r.WriteInput(&ArgUserInput{User: "bob", Name: "b1", Data: "exit"})
+	r.Close()
+
+	for _, c := range []struct{ filter, want string }{
+		{"", "$ ls\nfile\n$\nbob: see file\n"},
+		{"alice", "$ ls\nalice> ls\nfile\n$\nbob: see file\n"},
+		// typed but not entered, at the end
+		{"guest, b1", "$ ls\nfile\n$\nbob: see file\nguest> ^C\nbob> exit\n"},
+		{INPUT_ALL, "$ ls\nalice> ls\nfile\n$\nbob: see file\nguest> ^C\nbob> exit\n"},
+	} {
+		dst := dir + "/out.txt"
+		if err := Export(r.FileName, dst, EXPORT_TEXT, 0, c.filter); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(dst)
+		var got []string
+		for _, l := range strings.Split(string(b), "\n") {
+			// without the times
+			if i := strings.Index(l, "] "); i > 0 {
+				l = l[i+2:]
+			}
+			got = append(got, l)
+		}
+		if s := strings.Join(got, "\n"); s != c.want {
+			t.Errorf("input %q: %q", c.filter, s)
+		}
+	}
+
+	p, err := NewPlayer(r.FileName, 64, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ShowInput("alice")
+	if out := readAll(p); out != "$ \x1b]2;alice> lx^?s⏎\x07ls\r\nfile\r\n$ " {
+		t.Errorf("played %q", out)
+	}
+	p.Close()
+}
@@ -0,0 +1,220 @@
+package rec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// every recording has a json sidecar FILE.meta describing it
+const MetaSuffix = ".meta"
+
+// Meta describes a recording, times are unix seconds and End stays 0
+// while recording or if the recorder did not finish
+type Meta struct {
+	Id         string  `json:"id"`
+	Format     string  `json:"format,omitempty"`
+	Name       string  `json:"name,omitempty"`
+	Addr       string  `json:"addr,omitempty"`
+	Command    string  `json:"command,omitempty"`
+	User       string  `json:"user,omitempty"`
+	RemoteAddr string  `json:"remote_addr,omitempty"`
+	Start      int64   `json:"start"`
+	End        int64   `json:"end,omitempty"`
+	Duration   float64 `json:"duration"`
+	Size       int64   `json:"size"`
+	Columns    int     `json:"columns,omitempty"`
+	Rows       int     `json:"rows,omitempty"`
+	// id of the key an encrypted recording is sealed with
+	Key string `json:"key,omitempty"`
+}
+
+func MetaFile(filename string) string {
+	return filename + MetaSuffix
+}
+
+// the files kept next to a recording
+var sidecarSuffixes = []string{MetaSuffix, TextSuffix, IndexSuffix}
+
+// IsRecording tells recordings from sidecars and hidden files in a
+// recording directory
+func IsRecording(name string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return !strings.HasPrefix(name, ".")
+}
+
+// Save replaces the sidecar of filename
+func (m *Meta) Save(filename string) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dir, base := path.Split(filename)
+	f, err := ioutil.TempFile(dir, "."+base)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(buf, '\n')); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), MetaFile(filename))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// LoadMeta reads the sidecar of filename, a recording without one (made
+// by an older gotty) gets what its file tells: id, size and mod time
+func LoadMeta(filename string) (*Meta, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Meta{}
+	buf, err := ioutil.ReadFile(MetaFile(filename))
+	if err == nil {
+		err = json.Unmarshal(buf, m)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		m.Start = fi.ModTime().Unix()
+		m.End = m.Start
+	}
+	m.Id = path.Base(filename)
+	m.Size = fi.Size()
+	return m, nil
+}
+
+// ListMeta returns the metadata of every recording in dir, newest first
+func ListMeta(dir string) ([]*Meta, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*Meta{}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !IsRecording(fi.Name()) {
+			continue
+		}
+		m, err := LoadMeta(path.Join(dir, fi.Name()))
+		if err != nil {
+			// removed meanwhile, or a broken sidecar
+			continue
+		}
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Start != ret[j].Start {
+			return ret[i].Start > ret[j].Start
+		}
+		return ret[i].Id < ret[j].Id
+	})
+	return ret, nil
+}
+
+// MetaFilter selects recordings, empty fields match everything and
+// strings match substrings
+type MetaFilter struct {
+	Name    string
+	Addr    string
+	User    string
+	Command string
+	// started at or after Since, before Until (unix seconds)
+	Since       int64
+	Until       int64
+	MinDuration float64
+}
+
+func (f *MetaFilter) Match(m *Meta) bool {
+	return strings.Contains(m.Name, f.Name) &&
+		strings.Contains(m.Addr, f.Addr) &&
+		strings.Contains(m.User, f.User) &&
+		strings.Contains(m.Command, f.Command) &&
+		(f.Since == 0 || m.Start >= f.Since) &&
+		(f.Until == 0 || m.Start < f.Until) &&
+		m.Duration >= f.MinDuration
+}
+
+// Remove deletes a recording and its sidecars
+func Remove(filename string) error {
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	for _, suffix := range sidecarSuffixes {
+		if err := os.Remove(filename + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retention limits the recordings kept in a directory, zero disables a
+// limit
+type Retention struct {
+	// seconds since the recording ended
+	MaxAge int64
+	// bytes of all the recordings
+	MaxSize int64
+	// number of recordings
+	KeepLast int
+}
+
+func (r *Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxSize > 0 || r.KeepLast > 0
+}
+
+// Prune removes the recordings of dir beyond r, the newest are kept.
+// Recordings for which active returns true are kept, but still count
+// against the size and number limits.
+func Prune(dir string, r *Retention, active func(filename string) bool) ([]*Meta, error) {
+	metas, err := ListMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	removed := []*Meta{}
+	var size int64
+	for i, m := range metas {
+		size += m.Size
+		filename := path.Join(dir, m.Id)
+		end := m.End
+		if end == 0 {
+			end = m.Start
+		}
+		if active(filename) ||
+			!((r.MaxAge > 0 && now-end > r.MaxAge) ||
+				(r.MaxSize > 0 && size > r.MaxSize) ||
+				(r.KeepLast > 0 && i >= r.KeepLast)) {
+			continue
+		}
+		if err := Remove(filename); err != nil {
+			return removed, err
+		}
+		size -= m.Size
+		removed = append(removed, m)
+	}
+	return removed, nil
+}
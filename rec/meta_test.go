@@ -0,0 +1,93 @@
+package rec
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := LoadMeta(r.FileName); err != nil || m.End != 0 || m.Command != "bash" {
+		t.Fatalf("meta while recording %+v %v", m, err)
+	}
+	r.Meta.Name = "abc"
+	r.SetUser("alice")
+	r.Write([]byte(`2{"Columns":100,"Rows":30}`))
+	r.Write([]byte("0hello"))
+	r.Close()
+
+	// a recording of an older gotty has no sidecar
+	old := path.Join(dir, "old")
+	ioutil.WriteFile(old, []byte("rec"), 0644)
+	os.Chtimes(old, time.Now(), time.Now().Add(-48*time.Hour))
+
+	metas, err := ListMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("got %d recordings", len(metas))
+	}
+	m := metas[0]
+	if m.Id != path.Base(r.FileName) || m.Name != "abc" || m.User != "alice" ||
+		m.Format != FORMAT_GOB || m.End == 0 || m.Columns != 100 ||
+		m.Rows != 30 || m.Size == 0 {
+		t.Fatalf("meta %+v", m)
+	}
+	if metas[1].Id != "old" || metas[1].Size != 3 {
+		t.Fatalf("meta without sidecar %+v", metas[1])
+	}
+
+	for _, c := range []struct {
+		f    MetaFilter
+		want int
+	}{
+		{MetaFilter{}, 2},
+		{MetaFilter{User: "ali"}, 1},
+		{MetaFilter{Name: "abc", Command: "zsh"}, 0},
+		{MetaFilter{Since: time.Now().Add(-time.Hour).Unix()}, 1},
+		{MetaFilter{Until: time.Now().Add(-time.Hour).Unix()}, 1},
+	} {
+		n := 0
+		for _, m := range metas {
+			if c.f.Match(m) {
+				n++
+			}
+		}
+		if n != c.want {
+			t.Errorf("filter %+v matches %d, want %d", c.f, n, c.want)
+		}
+	}
+
+	removed, err := Prune(dir, &Retention{MaxAge: 3600},
+		func(string) bool { return false })
+	if err != nil || len(removed) != 1 || removed[0].Id != "old" {
+		t.Fatalf("prune by age removed %v, %v", removed, err)
+	}
+	// the recording of an open session is kept over the limits
+	removed, _ = Prune(dir, &Retention{MaxSize: 1},
+		func(f string) bool { return f == r.FileName })
+	if len(removed) != 0 {
+		t.Fatalf("prune removed an active recording")
+	}
+	removed, _ = Prune(dir, &Retention{MaxSize: 1},
+		func(string) bool { return false })
+	if len(removed) != 1 {
+		t.Fatalf("prune by size removed %d", len(removed))
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		t.Fatalf("%d files left", len(fis))
+	}
+}
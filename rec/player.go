@@ -1,31 +1,74 @@
 package rec
 
 import (
-	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 )
 
+const (
+	PLAY_PAUSE  = "pause"
+	PLAY_RESUME = "resume"
+	PLAY_TOGGLE = "toggle"
+	PLAY_SEEK   = "seek"
+	PLAY_STEP   = "step"
+	PLAY_SPEED  = "speed"
+)
+
+// PlayControl is sent by a viewer to steer the playback
+type PlayControl struct {
+	Action string  `json:"action"`
+	Time   float64 `json:"time"`
+	Speed  float64 `json:"speed"`
+}
+
+// PlayState tells viewers where the playback is, times in seconds
+type PlayState struct {
+	Paused   bool    `json:"paused"`
+	Speed    float64 `json:"speed"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
 type Player struct {
-	FileName  string
-	f         *os.File
-	dec       *gob.Decoder
-	d         RecData
-	speed     float64
-	repeat    bool
-	init      bool
-	fileStart int64
-	start     int64
-	offset    int64
-	maxWait   int64
-	window    struct {
+	sync.Mutex
+	FileName string
+	f        io.ReadSeekCloser
+	fr       *frameReader
+	index    *Index
+	// play time of every output frame, with maxWait applied
+	times   []int64
+	d       RecData
+	cur     []byte
+	frame   int
+	speed   float64
+	repeat  bool
+	maxWait int64
+	// the play position was basePos at wall time baseWall
+	basePos  int64
+	baseWall int64
+	started  bool
+	paused   bool
+	step     int
+	pending  []byte
+	wake     chan struct{}
+	done     chan struct{}
+	closed   bool
+	window   struct {
 		row uint16
 		col uint16
 	}
+	// the participants whose input is shown, the line each is typing
+	// and the title to set before the next output
+	input string
+	lines map[string]string
+	title string
 }
 
 func NewPlayer(filename string, speed float64, repeat bool, wait int64) (*Player, error) {
@@ -35,73 +78,335 @@ func NewPlayer(filename string, speed float64, repeat bool, wait int64) (*Player
 		speed:   speed,
 		repeat:  repeat,
 		maxWait: wait * 1000000000,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
 	}
-	if p.f, err = os.OpenFile(filename, os.O_RDONLY, 0); err != nil {
+	if p.speed <= 0 {
+		p.speed = 1
+	}
+	if p.index, err = BuildIndex(filename); err != nil {
+		return nil, err
+	}
+	if p.f, err = OpenRecording(filename); err != nil {
 		return nil, err
 	}
-	p.dec = gob.NewDecoder(p.f)
+	if p.fr, err = newFrameReader(p.f); err != nil {
+		p.f.Close()
+		return nil, err
+	}
+
+	// long pauses of the recording are cut to maxWait
+	var last, cut int64
+	p.times = make([]int64, len(p.index.Entries))
+	for i, e := range p.index.Entries {
+		if p.maxWait > 0 && e.Time-last > p.maxWait {
+			cut += e.Time - last - p.maxWait
+		}
+		last = e.Time
+		p.times[i] = e.Time - cut
+	}
 	return p, nil
 }
 
-func (p *Player) Read(d []byte) (n int, err error) {
+// position is the current play time, the caller holds the lock
+func (p *Player) position(now int64) int64 {
+	if p.paused || !p.started {
+		return p.basePos
+	}
+	return p.basePos + int64(float64(now-p.baseWall)*p.speed)
+}
+
+func (p *Player) duration() int64 {
+	if len(p.times) == 0 {
+		return 0
+	}
+	return p.times[len(p.times)-1]
+}
+
+func (p *Player) frameTime(i int) int64 {
+	if i < len(p.times) {
+		return p.times[i]
+	}
+	return p.duration()
+}
+
+// nextOutput decodes up to the next output frame, the caller holds the lock
+func (p *Player) nextOutput() error {
 	for {
-		if err = p.dec.Decode(&p.d); err != nil {
-			if p.init && p.repeat && err == io.EOF {
-				p.start = Nanotime()
-				p.offset = 0
-				p.f.Seek(0, 0)
-				p.dec = gob.NewDecoder(p.f)
+		if _, err := p.fr.next(&p.d); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// the recorder did not finish, play what is there
+				glog.V(2).Infof("%s is truncated", p.FileName)
+				err = io.EOF
+			}
+			if err == io.EOF && p.repeat && p.frame > 0 {
 				glog.V(2).Infof("read %s EOF, replay again", p.FileName)
+				if err = p.fr.reset(); err != nil {
+					return err
+				}
+				p.frame = 0
+				p.lines = nil
+				p.basePos = 0
+				p.baseWall = Nanotime()
 				continue
-			} else {
-				p.Close()
-				return 0, err
 			}
+			return err
 		}
 
 		switch p.d.Data[0] {
 		case ResizeTerminal:
 			var args ArgResizeTerminal
-			err = json.Unmarshal(p.d.Data[1:], &args)
-			if err != nil {
+			if err := json.Unmarshal(p.d.Data[1:], &args); err != nil {
 				glog.Errorln("Malformed remote command")
 				continue
 			}
 			p.window.row = uint16(args.Rows)
 			p.window.col = uint16(args.Columns)
-			continue
-		case Output:
-			if !p.init {
-				p.fileStart = p.d.Time
-				p.start = Nanotime()
-				p.init = true
+		case UserInput:
+			if p.input == "" {
+				continue
 			}
-			delta := (p.d.Time - p.fileStart) - p.offset -
-				int64(float64(Nanotime()-p.start)*p.speed)
-			if p.maxWait > 0 && delta > p.maxWait {
-				p.offset += delta - p.maxWait
-				time.Sleep(time.Duration(p.maxWait) * time.Nanosecond)
-			} else if delta > 0 {
-				time.Sleep(time.Duration(delta) * time.Nanosecond)
+			var in ArgUserInput
+			if err := json.Unmarshal(p.d.Data[1:], &in); err != nil {
+				glog.Errorln("Malformed user input")
+				continue
 			}
-			//glog.Infof("time:%d %d len:%d", Nanotime(), p.d.Time, n)
-
-			n = copy(d, p.d.Data[1:])
-			return
-		case SysEnv:
-			continue
+			if MatchInput(p.input, &in) {
+				p.title = p.typed(&in)
+			}
+		case Output:
+			p.cur = p.d.Data[1:]
+			if p.title != "" {
+				p.cur = append([]byte("\x1b]2;"+p.title+"\x07"), p.cur...)
+				p.title = ""
+			}
+			return nil
+		case SysEnv, Trailer, Chat:
 		default:
 			glog.Errorf("unknow type(%d) context(%s)",
 				p.d.Data[0], string(p.d.Data[1:]))
+		}
+	}
+}
+
+func (p *Player) Read(d []byte) (n int, err error) {
+	for {
+		p.Lock()
+		if p.closed {
+			p.Unlock()
+			return 0, io.EOF
+		}
+		if len(p.pending) > 0 {
+			n = copy(d, p.pending)
+			p.pending = p.pending[n:]
+			p.Unlock()
+			return n, nil
+		}
+		if p.cur == nil {
+			if err = p.nextOutput(); err != nil {
+				p.Unlock()
+				p.Close()
+				return 0, err
+			}
+		}
+
+		now := Nanotime()
+		if !p.started {
+			p.started = true
+			p.baseWall = now
+		}
+		if p.step > 0 {
+			// frame by frame while paused
+			p.step--
+			p.basePos = p.frameTime(p.frame)
+		}
+		// paused playback blocks until a control call wakes it
+		block, wait := p.paused, time.Duration(0)
+		if !p.paused {
+			wait = time.Duration(float64(p.frameTime(p.frame)-p.position(now)) / p.speed)
+		} else if p.basePos >= p.frameTime(p.frame) {
+			block = false
+		}
+		if !block && wait <= 0 {
+			p.pending = p.cur
+			p.cur = nil
+			p.frame++
+			p.Unlock()
 			continue
 		}
+		p.Unlock()
+
+		if block {
+			select {
+			case <-p.wake:
+			case <-p.done:
+			}
+		} else {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-p.wake:
+			case <-p.done:
+			}
+			t.Stop()
+		}
+	}
+}
+
+func (p *Player) wakeup() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Control applies a viewer's command and returns the new state
+func (p *Player) Control(c *PlayControl) (*PlayState, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := Nanotime()
+	switch c.Action {
+	case PLAY_PAUSE:
+		p.pause(now)
+	case PLAY_RESUME:
+		p.resume(now)
+	case PLAY_TOGGLE:
+		if p.paused {
+			p.resume(now)
+		} else {
+			p.pause(now)
+		}
+	case PLAY_STEP:
+		p.pause(now)
+		p.step++
+	case PLAY_SPEED:
+		if c.Speed <= 0 || c.Speed > 64 {
+			return nil, fmt.Errorf("invalid speed %v", c.Speed)
+		}
+		p.basePos = p.position(now)
+		p.baseWall = now
+		p.speed = c.Speed
+	case PLAY_SEEK:
+		if err := p.seek(int64(c.Time * 1e9)); err != nil {
+			return nil, err
+		}
+		p.baseWall = now
+	default:
+		return nil, fmt.Errorf("unknown play action %q", c.Action)
+	}
+	p.wakeup()
+	return p.state(now), nil
+}
+
+func (p *Player) pause(now int64) {
+	if !p.paused {
+		p.basePos = p.position(now)
+		p.paused = true
+	}
+}
+
+func (p *Player) resume(now int64) {
+	if p.paused {
+		p.baseWall = now
+		p.paused = false
+		p.step = 0
 	}
 }
 
+// seek redraws the screen as it was at t: the terminal is reset and the
+// output since the last frame which cleared the screen is replayed
+func (p *Player) seek(t int64) error {
+	if len(p.times) == 0 {
+		return errors.New("nothing to seek in")
+	}
+	if t < 0 {
+		t = 0
+	}
+	if t > p.duration() {
+		t = p.duration()
+	}
+
+	// the last frame at or before t and the clear before it
+	target := sort.Search(len(p.times), func(i int) bool {
+		return p.times[i] > t
+	}) - 1
+	p.pending = []byte("\x1bc")
+	p.cur = nil
+	p.lines = nil
+	p.title = ""
+	p.step = 0
+	p.basePos = t
+	if target < 0 {
+		p.frame = 0
+		return p.fr.reset()
+	}
+	from := target
+	for from > 0 && !p.index.Entries[from].Clear {
+		from--
+	}
+
+	if err := p.fr.seek(p.index.Entries[from].Offset); err != nil {
+		return err
+	}
+	for i := from; i <= target; i++ {
+		if err := p.nextOutput(); err != nil {
+			return err
+		}
+		p.pending = append(p.pending, p.cur...)
+		p.cur = nil
+	}
+	p.frame = target + 1
+	return nil
+}
+
+// PlayTime converts seconds since the first output of the recording, as
+// search hits have them, into play time with the long pauses cut
+func (p *Player) PlayTime(t float64) float64 {
+	raw := int64(t * 1e9)
+	i := sort.Search(len(p.times), func(i int) bool {
+		return p.index.Entries[i].Time > raw
+	}) - 1
+	if i < 0 {
+		return 0
+	}
+	d := raw - p.index.Entries[i].Time
+	if p.maxWait > 0 && d > p.maxWait {
+		d = p.maxWait
+	}
+	return nano2sec(p.times[i] + d)
+}
+
+func (p *Player) state(now int64) *PlayState {
+	pos := p.position(now)
+	if d := p.duration(); pos > d {
+		pos = d
+	}
+	return &PlayState{
+		Paused:   p.paused,
+		Speed:    p.speed,
+		Position: nano2sec(pos),
+		Duration: nano2sec(p.duration()),
+	}
+}
+
+func (p *Player) State() *PlayState {
+	p.Lock()
+	defer p.Unlock()
+	return p.state(Nanotime())
+}
+
 func (p *Player) Write(d []byte) (n int, err error) {
 	return len(d), nil
 }
 
 func (p *Player) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
 	return p.f.Close()
 }
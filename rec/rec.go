@@ -1,11 +1,21 @@
 package rec
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
 )
 
 type RecData struct {
@@ -14,9 +24,31 @@ type RecData struct {
 }
 
 type Recorder struct {
+	// the output and the input of a session are recorded by different
+	// goroutines
+	mu       sync.Mutex
 	FileName string
-	f        *os.File
+	Format   string
+	// saved to the sidecar by SaveMeta and Close
+	Meta  *Meta
+	start int64
+	f     *os.File
+	// w is f, or seal if the recording is encrypted
+	w        io.Writer
+	seal     *sealWriter
 	enc      *gob.Encoder
+	cast     *castWriter
+	text     *textWriter
+	env      ArgEnvTerminal
+	frames   int64
+	crc      hash.Hash32
+	lastSync int64
+	closed   bool
+}
+
+// Decoder reads the RecData frames of a recording
+type Decoder interface {
+	Decode(e interface{}) error
 }
 
 const (
@@ -24,20 +56,42 @@ const (
 	Ping           = '1'
 	ResizeTerminal = '2'
 	SysEnv         = '3'
+	Trailer        = '4'
+	ControlPlayer  = '5'
+	// what a participant typed, only in recordings
+	UserInput = '6'
+	// ask for or pass the keyboard of a shared session
+	ControlWrite = '7'
+	// a chat message of a participant, from a client and in recordings
+	Chat = '8'
 )
 
+// recordings are fsynced at most this often, so a crash loses at most
+// the last second
+const SyncInterval = int64(time.Second)
+
 const (
 	Output         = '0'
 	Pong           = '1'
 	SetWindowTitle = '2'
 	SetPreferences = '3'
 	SetReconnect   = '4'
+	SetPlayState   = '5'
+	// who holds the keyboard of a shared session
+	SetWriteControl = '6'
+	// who joined, left and is in a shared session
+	SetParticipants = '7'
+	ShowChat        = '8'
+	// the token to resume the session with and the offset of the output
+	// the client got so far
+	SetResume = '9'
 )
 
 type ArgEnvTerminal struct {
 	Term    string `json:"TERM"`
 	Shell   string `json:"SHELL"`
 	Command string `json:"COMMAND"`
+	User    string `json:"USER,omitempty"`
 }
 
 type ArgResizeTerminal struct {
@@ -45,39 +99,277 @@ type ArgResizeTerminal struct {
 	Rows    float64
 }
 
-func NewRecorder(term, shell, command, dir string) (*Recorder, error) {
-	var err error
-	var buf []byte
+// ArgUserInput is the input of a participant, Name and Addr are the key
+// of the participant's session, which differs from the recorded one's
+// when the participant joined it
+type ArgUserInput struct {
+	User string `json:"user,omitempty"`
+	Name string `json:"name,omitempty"`
+	Addr string `json:"addr,omitempty"`
+	Data string `json:"data"`
+}
+
+// ArgChat is a chat message of a participant, sent with the time it
+// was received in unix milliseconds
+type ArgChat struct {
+	User string `json:"user,omitempty"`
+	Name string `json:"name,omitempty"`
+	Addr string `json:"addr,omitempty"`
+	Text string `json:"text"`
+	Time int64  `json:"time,omitempty"`
+}
+
+// ArgTrailer closes a gob recording, it counts the frames before it and
+// their crc32 (IEEE) over the big-endian time and the data of each frame
+type ArgTrailer struct {
+	Frames int64  `json:"frames"`
+	Crc32  uint32 `json:"crc32"`
+}
 
-	r := &Recorder{}
-	if r.f, err = ioutil.TempFile(dir, ""); err != nil {
+// NewRecorder creates a recording in dir, format is FORMAT_GOB or
+// FORMAT_ASCIICAST (v2, saved with a .cast suffix)
+func NewRecorder(format, term, shell, command, dir string) (*Recorder, error) {
+	r, err := newRecorder(format, command, dir)
+	if err != nil {
 		return nil, err
 	}
-	r.enc = gob.NewEncoder(r.f)
-	r.FileName = r.f.Name()
 
-	buf, err = json.Marshal(ArgEnvTerminal{Term: term,
-		Shell: shell, Command: command})
+	r.env = ArgEnvTerminal{Term: term, Shell: shell, Command: command}
+	buf, err := json.Marshal(r.env)
 	if err != nil {
 		return nil, err
 	}
 
 	r.Write(append([]byte{SysEnv}, buf...))
 
+	return r, r.SaveMeta()
+}
+
+// newRecorder creates the file of a recording and its text, the caller
+// writes the frames
+func newRecorder(format, command, dir string) (*Recorder, error) {
+	var err error
+
+	r := &Recorder{Format: format, crc: crc32.NewIEEE(), lastSync: Nanotime()}
+	var f *os.File
+	switch format {
+	case FORMAT_GOB, "":
+		f, err = ioutil.TempFile(dir, "")
+		r.Format = FORMAT_GOB
+	case FORMAT_ASCIICAST:
+		f, err = ioutil.TempFile(dir, "*.cast")
+	default:
+		return nil, fmt.Errorf("unknown recording format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := getKeyring()
+	if err = r.open(f, k); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	r.FileName = r.f.Name()
+	r.Meta = &Meta{Id: path.Base(r.FileName), Format: r.Format,
+		Command: command, Start: time.Now().Unix()}
+	if k != nil {
+		// the plain text would give the recording away
+		r.Meta.Key = k.Current
+	} else if r.text, err = newTextWriter(r.FileName); err != nil {
+		// the recording can't be searched, but is still made
+		glog.Errorf("recording %s text: %v", r.FileName, err)
+	}
+	r.start = Nanotime()
 	return r, nil
 }
 
+// open makes the recording write to f, encrypted with the current key
+// of k if it is not nil
+func (r *Recorder) open(f *os.File, k *Keyring) (err error) {
+	r.f, r.w = f, f
+	if k != nil {
+		if r.seal, err = newSealWriter(f, k); err != nil {
+			return err
+		}
+		r.w = r.seal
+	}
+	if r.Format == FORMAT_ASCIICAST {
+		r.cast = &castWriter{w: r.w}
+	} else {
+		r.enc = gob.NewEncoder(r.w)
+	}
+	return nil
+}
+
+// sync writes out what the recording buffers and syncs its file
+func (r *Recorder) sync() error {
+	if r.seal != nil {
+		if err := r.seal.Flush(); err != nil {
+			return err
+		}
+	}
+	return r.f.Sync()
+}
+
+// SaveMeta writes the sidecar of the recording
+func (r *Recorder) SaveMeta() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.saveMeta()
+}
+
+func (r *Recorder) saveMeta() error {
+	return r.Meta.Save(r.FileName)
+}
+
+// SetUser records the authenticated user with a new env frame
+func (r *Recorder) SetUser(user string) error {
+	r.mu.Lock()
+	r.env.User = user
+	r.Meta.User = user
+	buf, err := json.Marshal(r.env)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = r.Write(append([]byte{SysEnv}, buf...))
+	return err
+}
+
 func (r *Recorder) Read(d []byte) (n int, err error) {
 	return 0, io.EOF
 }
 
 func (r *Recorder) Write(d []byte) (n int, err error) {
-	if err := r.enc.Encode(RecData{Time: Nanotime(), Data: d}); err != nil {
+	return r.write(Nanotime(), d)
+}
+
+// write records d as a frame of time t
+func (r *Recorder) write(t int64, d []byte) (n int, err error) {
+	if len(d) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err = r.writeFrame(t, d); err != nil {
 		return 0, err
 	}
+	if d[0] == Output && r.text != nil {
+		if err := r.text.write(t, d[1:]); err != nil {
+			glog.Errorf("recording %s text: %v", r.FileName, err)
+			r.text.f.Close()
+			r.text = nil
+		}
+	}
+	if d[0] == ResizeTerminal && r.Meta != nil {
+		var args ArgResizeTerminal
+		if json.Unmarshal(d[1:], &args) == nil {
+			r.Meta.Columns = int(args.Columns)
+			r.Meta.Rows = int(args.Rows)
+		}
+	}
 	return len(d), nil
 }
 
+// writeFrame encodes a frame, the caller holds the lock or is the only
+// user of r
+func (r *Recorder) writeFrame(t int64, d []byte) (err error) {
+	if r.closed {
+		return os.ErrClosed
+	}
+	if r.cast != nil {
+		err = r.cast.write(&r.env, t, d)
+	} else if err = r.enc.Encode(RecData{Time: t, Data: d}); err == nil {
+		r.frames++
+		frameSum(r.crc, t, d)
+	}
+	if err != nil {
+		return err
+	}
+	if now := Nanotime(); now-r.lastSync >= SyncInterval {
+		r.lastSync = now
+		return r.sync()
+	}
+	return nil
+}
+
+func frameSum(h hash.Hash32, t int64, d []byte) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t))
+	h.Write(b[:])
+	h.Write(d)
+}
+
+// WriteInput records what a participant typed, asciicast keeps only
+// the data as an "i" event
+func (r *Recorder) WriteInput(in *ArgUserInput) error {
+	buf, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = r.Write(append([]byte{UserInput}, buf...))
+	return err
+}
+
+// asciicast v2 files start with their version
+const castMagic = `{"version"`
+
+// NewDecoder returns a frame decoder for a gob or asciicast v2 recording
+func NewDecoder(rd io.Reader) (Decoder, error) {
+	br := bufio.NewReader(rd)
+	b, err := br.Peek(len(castMagic))
+	if err != nil && len(b) == 0 {
+		return nil, err
+	}
+	if string(b) == castMagic {
+		return newCastDecoder(br)
+	}
+	return gob.NewDecoder(br), nil
+}
+
+// Close ends a gob recording with its trailer and syncs the file
 func (r *Recorder) Close() error {
-	return r.f.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	if r.enc != nil {
+		buf, _ := json.Marshal(ArgTrailer{Frames: r.frames, Crc32: r.crc.Sum32()})
+		if err := r.enc.Encode(RecData{Time: Nanotime(),
+			Data: append([]byte{Trailer}, buf...)}); err != nil {
+			r.f.Close()
+			r.closed = true
+			return err
+		}
+	}
+	r.closed = true
+	if r.seal != nil {
+		if err := r.seal.Close(); err != nil {
+			r.f.Close()
+			return err
+		}
+	}
+	if err := r.sync(); err != nil {
+		r.f.Close()
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.text != nil {
+		if err := r.text.close(); err != nil {
+			glog.Errorf("recording %s text: %v", r.FileName, err)
+		}
+	}
+	if r.Meta == nil {
+		return nil
+	}
+	r.Meta.End = time.Now().Unix()
+	r.Meta.Duration = nano2sec(Nanotime() - r.start)
+	if fi, err := os.Stat(r.FileName); err == nil {
+		r.Meta.Size = fi.Size()
+	}
+	return r.saveMeta()
 }
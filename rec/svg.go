@@ -0,0 +1,135 @@
+package rec
+
+import (
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// svg frames are at least this far apart (nanoseconds), a cell is
+// svgCellWidth x svgCellHeight pixels
+const (
+	svgInterval   = 50000000
+	svgCellWidth  = 8.4
+	svgCellHeight = 17
+	svgFontSize   = 14
+	svgBaseline   = 13
+)
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svgRow draws a line of cells, the background of the default color is
+// left to the terminal's
+func svgRow(line []Cell) string {
+	var rects, spans strings.Builder
+	def := resolveColor(COLOR_DEFAULT, 0)
+
+	for x := 0; x < len(line); {
+		// a run of cells with the same attributes
+		end := x + 1
+		for end < len(line) && line[end].Attr == line[x].Attr {
+			end++
+		}
+		var text strings.Builder
+		for _, c := range line[x:end] {
+			text.WriteRune(c.Rune)
+		}
+
+		fg, bg := line[x].colors()
+		if bg != def {
+			fmt.Fprintf(&rects, `<rect x="%.1f" width="%.1f" height="%d" fill="%s"/>`,
+				float64(x)*svgCellWidth, float64(end-x)*svgCellWidth,
+				svgCellHeight, svgColor(bg))
+		}
+		// leading blanks move the span, trailing ones are left out
+		s := text.String()
+		trimmed := strings.TrimLeft(s, " ")
+		start := x + len([]rune(s)) - len([]rune(trimmed))
+		if trimmed = strings.TrimRight(trimmed, " "); trimmed != "" {
+			var class []string
+			if line[x].Bold {
+				class = append(class, "b")
+			}
+			if line[x].Italic {
+				class = append(class, "i")
+			}
+			if line[x].Underline {
+				class = append(class, "u")
+			}
+			fmt.Fprintf(&spans, `<tspan x="%.1f" fill="%s"`,
+				float64(start)*svgCellWidth, svgColor(fg))
+			if len(class) > 0 {
+				fmt.Fprintf(&spans, ` class="%s"`, strings.Join(class, " "))
+			}
+			fmt.Fprintf(&spans, ">%s</tspan>", html.EscapeString(trimmed))
+		}
+		x = end
+	}
+	if spans.Len() > 0 {
+		fmt.Fprintf(&rects, `<text y="%d" xml:space="preserve">%s</text>`,
+			svgBaseline, spans.String())
+	}
+	return rects.String()
+}
+
+// writeSvg writes an animated svg, the rows of the frames are defined
+// once and every frame is shown from its time to the next one's
+func (e *exportRec) writeSvg(w io.Writer) error {
+	frames, cols, rows := e.screenFrames(svgInterval)
+	width, height := float64(cols)*svgCellWidth, rows*svgCellHeight
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%.1f" height="%d" viewBox="0 0 %.1f %d">
+<title>%s</title>
+<style>
+text {font-family: monospace; font-size: %dpx;}
+.b {font-weight: bold;}
+.i {font-style: italic;}
+.u {text-decoration: underline;}
+</style>
+<rect width="100%%" height="100%%" fill="%s"/>
+<defs>
+`, width, height, width, height, html.EscapeString(e.command), svgFontSize,
+		svgColor(resolveColor(COLOR_DEFAULT, 0)))
+
+	ids := make(map[string]int)
+	uses := make([][]int, len(frames))
+	for i, f := range frames {
+		uses[i] = make([]int, len(f.lines))
+		for y, line := range f.lines {
+			row := svgRow(line)
+			if row == "" {
+				uses[i][y] = -1
+				continue
+			}
+			id, ok := ids[row]
+			if !ok {
+				id = len(ids)
+				ids[row] = id
+				fmt.Fprintf(w, "<g id=\"l%d\">%s</g>\n", id, row)
+			}
+			uses[i][y] = id
+		}
+	}
+	io.WriteString(w, "</defs>\n")
+
+	for i, f := range frames {
+		fmt.Fprintf(w, `<g visibility="hidden"><set attributeName="visibility" to="visible" begin="%.3fs"`,
+			nano2sec(f.time))
+		if i+1 < len(frames) {
+			fmt.Fprintf(w, ` end="%.3fs"`, nano2sec(frames[i+1].time))
+		}
+		io.WriteString(w, "/>\n")
+		for y, id := range uses[i] {
+			if id >= 0 {
+				fmt.Fprintf(w, "<use xlink:href=\"#l%d\" y=\"%d\"/>\n", id, y*svgCellHeight)
+			}
+		}
+		io.WriteString(w, "</g>\n")
+	}
+	_, err := io.WriteString(w, "</svg>\n")
+	return err
+}
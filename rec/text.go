@@ -0,0 +1,400 @@
+package rec
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the plain text of a recording's output is kept in FILE.text, one
+// "TIME\tLINE" per line, and its inverted index in FILE.idx
+const (
+	TextSuffix  = ".text"
+	IndexSuffix = ".idx"
+)
+
+// longer lines, e.g. of a progress bar without newlines, are split
+const maxTextLine = 1024
+
+const (
+	ansiGround = iota
+	ansiEsc
+	ansiCsi
+	ansiString
+	ansiStringEsc
+	ansiCharset
+)
+
+// ansiStripper turns terminal output into lines of plain text, escape
+// sequences split between two writes are handled
+type ansiStripper struct {
+	state int
+	line  []byte
+	// the time of the write which started the line
+	lineTime int64
+	emit     func(t int64, line []byte) error
+}
+
+func (a *ansiStripper) write(t int64, d []byte) error {
+	for _, c := range d {
+		switch a.state {
+		case ansiGround:
+			switch {
+			case c == 0x1b:
+				a.state = ansiEsc
+			case c == '\n' || c == '\r':
+				if err := a.flush(); err != nil {
+					return err
+				}
+			case c == '\b':
+				if len(a.line) > 0 {
+					_, n := utf8.DecodeLastRune(a.line)
+					a.line = a.line[:len(a.line)-n]
+				}
+			case c == '\t':
+				a.line = append(a.line, ' ')
+			case c < 0x20 || c == 0x7f:
+			default:
+				if len(a.line) == 0 {
+					a.lineTime = t
+				}
+				a.line = append(a.line, c)
+				if len(a.line) >= maxTextLine {
+					if err := a.flush(); err != nil {
+						return err
+					}
+				}
+			}
+		case ansiEsc:
+			switch c {
+			case '[':
+				a.state = ansiCsi
+			case ']', 'P', 'X', '^', '_':
+				a.state = ansiString
+			case '(', ')', '*', '+', '#', '%':
+				a.state = ansiCharset
+			default:
+				a.state = ansiGround
+			}
+		case ansiCsi:
+			if c >= 0x40 && c <= 0x7e {
+				a.state = ansiGround
+			}
+		case ansiString:
+			if c == 0x07 {
+				a.state = ansiGround
+			} else if c == 0x1b {
+				a.state = ansiStringEsc
+			}
+		case ansiStringEsc:
+			if c == '\\' {
+				a.state = ansiGround
+			} else {
+				a.state = ansiString
+			}
+		case ansiCharset:
+			a.state = ansiGround
+		}
+	}
+	return nil
+}
+
+func (a *ansiStripper) flush() error {
+	line := strings.TrimSpace(string(a.line))
+	a.line = a.line[:0]
+	if line == "" {
+		return nil
+	}
+	return a.emit(a.lineTime, []byte(line))
+}
+
+// StripAnsi returns the plain text lines of terminal output
+func StripAnsi(d []byte) []string {
+	var lines []string
+	a := &ansiStripper{emit: func(t int64, line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	}}
+	a.write(0, d)
+	a.flush()
+	return lines
+}
+
+// textWriter extracts the text of the output frames of a recording as
+// they are written, times are seconds since the first output frame
+type textWriter struct {
+	filename string
+	f        *os.File
+	w        *bufio.Writer
+	a        *ansiStripper
+	first    int64
+	started  bool
+}
+
+func newTextWriter(filename string) (*textWriter, error) {
+	f, err := os.OpenFile(filename+TextSuffix,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	t := &textWriter{filename: filename, f: f, w: bufio.NewWriter(f)}
+	t.a = &ansiStripper{emit: t.emit}
+	return t, nil
+}
+
+func (t *textWriter) emit(tm int64, line []byte) error {
+	_, err := fmt.Fprintf(t.w, "%.3f\t%s\n", nano2sec(tm-t.first), line)
+	return err
+}
+
+// write adds the output of a frame recorded at time tm
+func (t *textWriter) write(tm int64, d []byte) error {
+	if !t.started {
+		t.started = true
+		t.first = tm
+	}
+	return t.a.write(tm, d)
+}
+
+// close ends the text and writes its index
+func (t *textWriter) close() error {
+	err := t.a.flush()
+	if err == nil {
+		err = t.w.Flush()
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return writeTextIndex(t.filename)
+}
+
+// TextIndex is the inverted index of a recording's text
+type TextIndex struct {
+	// the line numbers where a term shows up
+	Terms map[string][]int32
+	// the offset of every line in FILE.text
+	Offsets []int64
+}
+
+// Terms splits text into lower case words, the index and the queries
+// use the same words
+func Terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+func writeTextIndex(filename string) error {
+	f, err := os.Open(filename + TextSuffix)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx := &TextIndex{Terms: make(map[string][]int32)}
+	br := bufio.NewReader(f)
+	var off int64
+	for n := int32(0); ; n++ {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		idx.Offsets = append(idx.Offsets, off)
+		off += int64(len(line))
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			line = line[i+1:]
+		}
+		for _, term := range Terms(line) {
+			if l := idx.Terms[term]; len(l) == 0 || l[len(l)-1] != n {
+				idx.Terms[term] = append(l, n)
+			}
+		}
+	}
+
+	dir, base := path.Split(filename)
+	out, err := ioutil.TempFile(dir, "."+base)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(out).Encode(idx); err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), filename+IndexSuffix)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// BuildTextIndex extracts the text of an existing recording and indexes
+// it, for recordings made before gotty kept their text. Encrypted
+// recordings are not indexed, their text would be stored in plain.
+func BuildTextIndex(filename string) error {
+	if id, err := KeyId(filename); err != nil {
+		return err
+	} else if id != "" {
+		return errors.New("encrypted recordings are not indexed")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec, err := NewDecoder(f)
+	if err != nil {
+		return err
+	}
+	t, err := newTextWriter(filename)
+	if err != nil {
+		return err
+	}
+	for {
+		var d RecData
+		err := dec.Decode(&d)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.f.Close()
+			return err
+		}
+		if len(d.Data) > 0 && d.Data[0] == Output {
+			if err := t.write(d.Time, d.Data[1:]); err != nil {
+				t.f.Close()
+				return err
+			}
+		}
+	}
+	return t.close()
+}
+
+// SearchHit is a line of a recording which matches a query, Time is
+// in seconds since the first output like the player's start option
+type SearchHit struct {
+	Id   string  `json:"id"`
+	Time float64 `json:"time"`
+	Line string  `json:"line"`
+}
+
+// Search finds the lines of the recordings in dir which contain every
+// word of query, newest recording first. Only recordings matching
+// filter (nil for all) are searched, and at most limit hits (0 for no
+// limit) are returned.
+func Search(dir, query string, filter *MetaFilter, limit int) ([]*SearchHit, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil, errors.New("empty query")
+	}
+	metas, err := ListMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []*SearchHit{}
+	for _, m := range metas {
+		if filter != nil && !filter.Match(m) {
+			continue
+		}
+		h, err := searchFile(path.Join(dir, m.Id), terms, limit-len(hits))
+		if err != nil {
+			// not indexed (yet)
+			continue
+		}
+		hits = append(hits, h...)
+		if limit > 0 && len(hits) >= limit {
+			break
+		}
+	}
+	return hits, nil
+}
+
+func searchFile(filename string, terms []string, limit int) ([]*SearchHit, error) {
+	f, err := os.Open(filename + IndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	idx := &TextIndex{}
+	err = gob.NewDecoder(bufio.NewReader(f)).Decode(idx)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// the lines which have every term
+	lines := idx.Terms[terms[0]]
+	for _, term := range terms[1:] {
+		lines = intersect(lines, idx.Terms[term])
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	if limit > 0 && len(lines) > limit {
+		lines = lines[:limit]
+	}
+
+	text, err := os.Open(filename + TextSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer text.Close()
+
+	hits := []*SearchHit{}
+	id := path.Base(filename)
+	for _, n := range lines {
+		if int(n) >= len(idx.Offsets) {
+			break
+		}
+		line, err := readLineAt(text, idx.Offsets[n])
+		if err != nil {
+			return hits, err
+		}
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			continue
+		}
+		tm, _ := strconv.ParseFloat(line[:i], 64)
+		hits = append(hits, &SearchHit{Id: id, Time: tm, Line: line[i+1:]})
+	}
+	return hits, nil
+}
+
+func readLineAt(f *os.File, off int64) (string, error) {
+	br := bufio.NewReader(io.NewSectionReader(f, off, maxTextLine*4+32))
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func intersect(a, b []int32) []int32 {
+	ret := []int32{}
+	for _, n := range a {
+		i := sort.Search(len(b), func(i int) bool { return b[i] >= n })
+		if i < len(b) && b[i] == n {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
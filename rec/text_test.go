@@ -0,0 +1,79 @@
+package rec
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStripAnsi(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []string
+	}{
+		{"plain\r\n", []string{"plain"}},
+		{"\x1b[1;32mgreen\x1b[0m text\n", []string{"green text"}},
+		{"\x1b]0;title\x07prompt$ ls\r\n", []string{"prompt$ ls"}},
+		{"\x1b]2;title\x1b\\x\n", []string{"x"}},
+		{"\x1b(Bab\bc\ty\n\n", []string{"ac y"}},
+		{"50%\r100%\r\n", []string{"50%", "100%"}},
+	} {
+		if got := StripAnsi([]byte(c.in)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("StripAnsi(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("0$ make migrate\r\n"))
+	// an escape sequence and a word split between two frames
+	r.Write([]byte("0\x1b[3"))
+	r.Write([]byte("02mApplying Add_Users_Ta"))
+	r.Write([]byte("0ble\x1b[0m ok\r\n"))
+	r.Write([]byte("0done\r\n"))
+
+	// not searchable before the recorder closes
+	if hits, _ := Search(dir, "done", nil, 0); len(hits) != 0 {
+		t.Fatalf("hits of an open recording: %v", hits)
+	}
+	r.Close()
+
+	hits, err := Search(dir, "add_users_table OK", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Line != "Applying Add_Users_Table ok" ||
+		hits[0].Id != r.Meta.Id {
+		t.Fatalf("hits %+v", hits)
+	}
+	if hits, _ = Search(dir, "migrate done", nil, 0); len(hits) != 0 {
+		t.Fatalf("words of two lines matched: %+v", hits)
+	}
+	if hits, _ = Search(dir, "done", &MetaFilter{User: "bob"}, 0); len(hits) != 0 {
+		t.Fatalf("filtered recording matched: %+v", hits)
+	}
+	if _, err = Search(dir, " -- ", nil, 0); err == nil {
+		t.Fatal("empty query accepted")
+	}
+
+	// indexing again from the recording gives the same hits
+	os.Remove(r.FileName + IndexSuffix)
+	if err = BuildTextIndex(r.FileName); err != nil {
+		t.Fatal(err)
+	}
+	hits, _ = Search(dir, "add_users_table", nil, 0)
+	if len(hits) != 1 || hits[0].Line != "Applying Add_Users_Table ok" {
+		t.Fatalf("hits after reindexing %+v", hits)
+	}
+}
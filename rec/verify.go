@@ -0,0 +1,225 @@
+package rec
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// VerifyReport is the result of checking a recording
+type VerifyReport struct {
+	Format string
+	// decodable frames (asciicast: event lines) before the trailer
+	Frames int64
+	// nil if the file has no trailer, asciicast files never have one
+	Trailer *ArgTrailer
+	// the file ends inside a frame
+	Truncated bool
+	// why reading stopped early, or the trailer doesn't match
+	Err error
+}
+
+func (v *VerifyReport) Ok() bool {
+	return v.Err == nil && !v.Truncated &&
+		(v.Format == FORMAT_ASCIICAST || v.Trailer != nil)
+}
+
+func (v *VerifyReport) String() string {
+	switch {
+	case v.Err != nil:
+		return fmt.Sprintf("corrupt after %d frames: %v", v.Frames, v.Err)
+	case v.Truncated:
+		return fmt.Sprintf("truncated after %d frames", v.Frames)
+	case v.Format == FORMAT_GOB && v.Trailer == nil:
+		return fmt.Sprintf("no trailer after %d frames, the recorder did not finish",
+			v.Frames)
+	}
+	return fmt.Sprintf("ok, %d frames", v.Frames)
+}
+
+// Verify reads a whole recording and reports where it is damaged
+func Verify(filename string) (*VerifyReport, error) {
+	f, err := OpenRecording(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v := &VerifyReport{}
+	err = scan(f, v, func(RecData) error { return nil },
+		func([]byte) error { return nil })
+	return v, err
+}
+
+// Repair copies every frame of src that can be decoded to the new file
+// dst, a gob copy gets a fresh trailer. The copy of an encrypted
+// recording is encrypted with the current key.
+func Repair(src, dst string) (*VerifyReport, error) {
+	var k *Keyring
+	if id, err := KeyId(src); err != nil {
+		return nil, err
+	} else if id != "" {
+		k = getKeyring()
+	}
+	in, err := OpenRecording(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{FileName: dst, crc: crc32.NewIEEE(), lastSync: Nanotime()}
+	open := func(format string) error {
+		if r.f != nil {
+			return nil
+		}
+		r.Format = format
+		return r.open(out, k)
+	}
+
+	v := &VerifyReport{}
+	err = scan(in, v, func(d RecData) error {
+		if err := open(FORMAT_GOB); err != nil {
+			return err
+		}
+		return r.writeFrame(d.Time, d.Data)
+	}, func(line []byte) error {
+		if err := open(FORMAT_ASCIICAST); err != nil {
+			return err
+		}
+		_, err := r.w.Write(line)
+		return err
+	})
+	if err == nil {
+		err = open(v.Format)
+	}
+	if err != nil {
+		if r.f == nil {
+			out.Close()
+		} else {
+			r.Close()
+		}
+		os.Remove(dst)
+		return v, err
+	}
+	return v, r.Close()
+}
+
+// scan walks the frames of a gob recording or the lines of an asciicast
+// one and fills in v, frame and line are called with every good one
+func scan(rd io.Reader, v *VerifyReport, frame func(RecData) error,
+	line func([]byte) error) error {
+	br := bufio.NewReader(rd)
+	b, err := br.Peek(len(castMagic))
+	if err != nil && len(b) == 0 {
+		if err == io.EOF {
+			v.Format = FORMAT_GOB
+			v.Truncated = true
+			return nil
+		}
+		return err
+	}
+
+	if string(b) == castMagic {
+		v.Format = FORMAT_ASCIICAST
+		return scanCast(br, v, line)
+	}
+
+	v.Format = FORMAT_GOB
+	dec := gob.NewDecoder(br)
+	crc := crc32.NewIEEE()
+	for {
+		var d RecData
+		if err := dec.Decode(&d); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				v.Truncated = true
+			} else if err != io.EOF {
+				v.Err = err
+			}
+			return nil
+		}
+		if len(d.Data) == 0 {
+			v.Err = fmt.Errorf("empty frame %d", v.Frames)
+			return nil
+		}
+		if v.Trailer != nil {
+			v.Err = fmt.Errorf("frames after the trailer")
+			return nil
+		}
+
+		if d.Data[0] == Trailer {
+			var t ArgTrailer
+			if err := json.Unmarshal(d.Data[1:], &t); err != nil {
+				v.Err = fmt.Errorf("malformed trailer: %v", err)
+				return nil
+			}
+			v.Trailer = &t
+			if t.Frames != v.Frames {
+				v.Err = fmt.Errorf("trailer counts %d frames, found %d",
+					t.Frames, v.Frames)
+			} else if t.Crc32 != crc.Sum32() {
+				v.Err = fmt.Errorf("crc32 mismatch, trailer %08x, frames %08x",
+					t.Crc32, crc.Sum32())
+			}
+			if v.Err != nil {
+				return nil
+			}
+			continue
+		}
+
+		if err := frame(d); err != nil {
+			return err
+		}
+		frameSum(crc, d.Time, d.Data)
+		v.Frames++
+	}
+}
+
+func scanCast(br *bufio.Reader, v *VerifyReport, line func([]byte) error) error {
+	for n := 0; ; n++ {
+		l, err := br.ReadBytes('\n')
+		if len(l) == 0 && err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			// an encrypted recording without its last chunk
+			v.Truncated = true
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF {
+			// every line the recorder writes ends with a newline
+			v.Truncated = true
+			return nil
+		}
+
+		var x interface{}
+		if json.Unmarshal(l, &x) != nil {
+			v.Err = fmt.Errorf("malformed line %d", n+1)
+			return nil
+		}
+		if n == 0 {
+			if _, ok := x.(map[string]interface{}); !ok {
+				v.Err = fmt.Errorf("malformed header")
+				return nil
+			}
+		} else if ev, ok := x.([]interface{}); !ok || len(ev) != 3 {
+			v.Err = fmt.Errorf("malformed event on line %d", n+1)
+			return nil
+		} else {
+			v.Frames++
+		}
+		if err := line(l); err != nil {
+			return err
+		}
+	}
+}
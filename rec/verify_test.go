@@ -0,0 +1,121 @@
+package rec
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		r.Write([]byte("0hello world"))
+	}
+	r.Close()
+
+	v, err := Verify(r.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Ok() || v.Frames != 11 || v.Trailer == nil || v.Trailer.Frames != 11 {
+		t.Fatalf("complete recording: %s %+v", v, v)
+	}
+
+	buf, _ := ioutil.ReadFile(r.FileName)
+
+	// cut inside the last output frame, as a crash would
+	cut := dir + "/cut"
+	ioutil.WriteFile(cut, buf[:len(buf)-60], 0644)
+	if v, _ = Verify(cut); v.Ok() || !v.Truncated {
+		t.Fatalf("truncated recording: %s", v)
+	}
+
+	fixed := dir + "/fixed"
+	if v, err = Repair(cut, fixed); err != nil {
+		t.Fatal(err)
+	}
+	if v.Frames == 0 || v.Frames >= 11 {
+		t.Fatalf("salvaged %d frames", v.Frames)
+	}
+	if v2, _ := Verify(fixed); !v2.Ok() || v2.Frames != v.Frames {
+		t.Fatalf("repaired recording: %s, want %d frames", v2, v.Frames)
+	}
+	if _, err = Repair(cut, fixed); err == nil {
+		t.Fatal("repair overwrote its output")
+	}
+
+	// flip a byte of the data, the trailer no longer matches
+	bad := dir + "/bad"
+	flipped := append([]byte(nil), buf...)
+	for i := len(flipped) - 80; i > 0; i-- {
+		if flipped[i] == 'w' {
+			flipped[i] = 'W'
+			break
+		}
+	}
+	ioutil.WriteFile(bad, flipped, 0644)
+	if v, _ = Verify(bad); v.Ok() || v.Err == nil {
+		t.Fatalf("corrupt recording: %s", v)
+	}
+
+	// players and converters read the truncated file up to the cut
+	p, err := NewPlayer(cut, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	frames := 0
+	for {
+		if _, err := p.Read(b); err != nil {
+			break
+		}
+		frames++
+	}
+	if frames == 0 {
+		t.Fatal("player read nothing from a truncated recording")
+	}
+}
+
+func TestVerifyConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRecorder(FORMAT_GOB, "xterm", "/bin/bash", "bash", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// output and input come from different goroutines
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			r.Write([]byte("0hello world"))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			r.WriteInput(&ArgUserInput{User: "alice", Data: "ls\r"})
+		}
+	}()
+	wg.Wait()
+	r.Close()
+
+	if v, err := Verify(r.FileName); err != nil || !v.Ok() || v.Frames != 201 {
+		t.Fatalf("concurrent recording: %v %s", err, v)
+	}
+}
@@ -0,0 +1,504 @@
+package rec
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// colors of a cell are COLOR_DEFAULT, a palette index (0-255) or
+// COLOR_RGB|0xrrggbb
+const (
+	COLOR_DEFAULT = -1
+	COLOR_RGB     = 1 << 24
+)
+
+type Attr struct {
+	Fg        int32
+	Bg        int32
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Reverse   bool
+}
+
+var defaultAttr = Attr{Fg: COLOR_DEFAULT, Bg: COLOR_DEFAULT}
+
+type Cell struct {
+	Rune rune
+	Attr
+}
+
+const (
+	vtGround = iota
+	vtEsc
+	vtCsi
+	vtString
+	vtStringEsc
+	vtCharset
+)
+
+// VT is a small vt100/xterm emulator, enough to render what shells and
+// full screen programs print
+type VT struct {
+	Cols   int
+	Rows   int
+	screen [][]Cell
+	// the main screen while the alternate one is shown
+	main     [][]Cell
+	x, y     int
+	attr     Attr
+	wrapNext bool
+	// scroll region, inclusive
+	top, bottom int
+	saved       struct {
+		x, y int
+		attr Attr
+	}
+	state  int
+	params []byte
+	utf8   []byte
+}
+
+func NewVT(cols, rows int) *VT {
+	t := &VT{}
+	t.Resize(cols, rows)
+	t.attr = defaultAttr
+	return t
+}
+
+func blankLine(cols int, attr Attr) []Cell {
+	line := make([]Cell, cols)
+	for i := range line {
+		line[i] = Cell{Rune: ' ', Attr: attr}
+	}
+	return line
+}
+
+// Resize keeps the top left of the screen
+func (t *VT) Resize(cols, rows int) {
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	screen := make([][]Cell, rows)
+	for y := range screen {
+		screen[y] = blankLine(cols, defaultAttr)
+		if y < len(t.screen) {
+			copy(screen[y], t.screen[y])
+		}
+	}
+	t.screen = screen
+	t.main = nil
+	t.Cols, t.Rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.x, t.y = t.clampX(t.x), t.clampY(t.y)
+	t.wrapNext = false
+}
+
+// Line returns row y of the screen, callers must not keep it
+func (t *VT) Line(y int) []Cell {
+	return t.screen[y]
+}
+
+// String returns the text of the screen, without trailing blanks
+func (t *VT) String() string {
+	lines := make([]string, t.Rows)
+	for y, line := range t.screen {
+		var b strings.Builder
+		for _, c := range line {
+			b.WriteRune(c.Rune)
+		}
+		lines[y] = strings.TrimRight(b.String(), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func (t *VT) clampX(x int) int {
+	if x < 0 {
+		return 0
+	}
+	if x >= t.Cols {
+		return t.Cols - 1
+	}
+	return x
+}
+
+func (t *VT) clampY(y int) int {
+	if y < 0 {
+		return 0
+	}
+	if y >= t.Rows {
+		return t.Rows - 1
+	}
+	return y
+}
+
+func (t *VT) Write(d []byte) (int, error) {
+	for _, c := range d {
+		t.put(c)
+	}
+	return len(d), nil
+}
+
+func (t *VT) put(c byte) {
+	switch t.state {
+	case vtGround:
+		if len(t.utf8) > 0 || c >= 0x80 {
+			t.utf8 = append(t.utf8, c)
+			if utf8.FullRune(t.utf8) {
+				r, _ := utf8.DecodeRune(t.utf8)
+				t.utf8 = t.utf8[:0]
+				t.print(r)
+			}
+			return
+		}
+		switch {
+		case c == 0x1b:
+			t.state = vtEsc
+		case c < 0x20 || c == 0x7f:
+			t.control(c)
+		default:
+			t.print(rune(c))
+		}
+	case vtEsc:
+		t.esc(c)
+	case vtCsi:
+		if c >= 0x40 && c <= 0x7e {
+			t.state = vtGround
+			t.csi(c)
+		} else if c < 0x20 {
+			t.control(c)
+		} else {
+			t.params = append(t.params, c)
+		}
+	case vtString:
+		if c == 0x07 {
+			t.state = vtGround
+		} else if c == 0x1b {
+			t.state = vtStringEsc
+		}
+	case vtStringEsc:
+		if c == '\\' {
+			t.state = vtGround
+		} else {
+			t.state = vtString
+		}
+	case vtCharset:
+		t.state = vtGround
+	}
+}
+
+func (t *VT) print(r rune) {
+	if t.wrapNext {
+		t.x = 0
+		t.lineFeed()
+		t.wrapNext = false
+	}
+	t.screen[t.y][t.x] = Cell{Rune: r, Attr: t.attr}
+	if t.x == t.Cols-1 {
+		t.wrapNext = true
+	} else {
+		t.x++
+	}
+}
+
+func (t *VT) control(c byte) {
+	switch c {
+	case '\r':
+		t.x = 0
+		t.wrapNext = false
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case '\t':
+		t.x = t.clampX((t.x/8 + 1) * 8)
+	}
+}
+
+func (t *VT) lineFeed() {
+	if t.y == t.bottom {
+		t.scrollUp(1)
+	} else if t.y < t.Rows-1 {
+		t.y++
+	}
+}
+
+func (t *VT) blank() Attr {
+	return Attr{Fg: COLOR_DEFAULT, Bg: t.attr.Bg}
+}
+
+// scrollUp moves the lines of the scroll region up by n
+func (t *VT) scrollUp(n int) {
+	region := t.screen[t.top : t.bottom+1]
+	if n > len(region) {
+		n = len(region)
+	}
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = blankLine(t.Cols, t.blank())
+	}
+}
+
+func (t *VT) scrollDown(n int) {
+	region := t.screen[t.top : t.bottom+1]
+	if n > len(region) {
+		n = len(region)
+	}
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = blankLine(t.Cols, t.blank())
+	}
+}
+
+func (t *VT) esc(c byte) {
+	t.state = vtGround
+	switch c {
+	case '[':
+		t.state = vtCsi
+		t.params = t.params[:0]
+	case ']', 'P', 'X', '^', '_':
+		t.state = vtString
+	case '(', ')', '*', '+', '#', '%':
+		t.state = vtCharset
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.x = 0
+		t.lineFeed()
+	case 'M':
+		if t.y == t.top {
+			t.scrollDown(1)
+		} else if t.y > 0 {
+			t.y--
+		}
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'c':
+		t.attr = defaultAttr
+		t.main = nil
+		t.x, t.y = 0, 0
+		t.Resize(t.Cols, t.Rows)
+		t.clear(0, 0, t.Cols, t.Rows)
+	}
+}
+
+func (t *VT) saveCursor() {
+	t.saved.x, t.saved.y, t.saved.attr = t.x, t.y, t.attr
+}
+
+func (t *VT) restoreCursor() {
+	t.x, t.y, t.attr = t.clampX(t.saved.x), t.clampY(t.saved.y), t.saved.attr
+	t.wrapNext = false
+}
+
+// clear blanks the cells from x0 to x1 (exclusive) of the rows y0 to y1
+// (exclusive)
+func (t *VT) clear(x0, y0, x1, y1 int) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			t.screen[y][x] = Cell{Rune: ' ', Attr: t.blank()}
+		}
+	}
+}
+
+func (t *VT) csi(final byte) {
+	private := byte(0)
+	params := string(t.params)
+	if len(params) > 0 && (params[0] == '?' || params[0] == '>' ||
+		params[0] == '=' || params[0] == '<') {
+		private, params = params[0], params[1:]
+	}
+	var args []int
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(strings.TrimRight(p, " !\"#$%&'()*+,-./"))
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	if private != 0 {
+		if private == '?' && (final == 'h' || final == 'l') {
+			for _, mode := range args {
+				if mode == 47 || mode == 1047 || mode == 1049 {
+					t.altScreen(final == 'h', mode == 1049)
+				}
+			}
+		}
+		return
+	}
+
+	t.wrapNext = false
+	switch final {
+	case 'A':
+		t.y = t.clampY(t.y - arg(0, 1))
+	case 'B', 'e':
+		t.y = t.clampY(t.y + arg(0, 1))
+	case 'C', 'a':
+		t.x = t.clampX(t.x + arg(0, 1))
+	case 'D':
+		t.x = t.clampX(t.x - arg(0, 1))
+	case 'E':
+		t.x, t.y = 0, t.clampY(t.y+arg(0, 1))
+	case 'F':
+		t.x, t.y = 0, t.clampY(t.y-arg(0, 1))
+	case 'G', '`':
+		t.x = t.clampX(arg(0, 1) - 1)
+	case 'd':
+		t.y = t.clampY(arg(0, 1) - 1)
+	case 'H', 'f':
+		t.x, t.y = t.clampX(arg(1, 1)-1), t.clampY(arg(0, 1)-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.x, t.y, t.Cols, t.y+1)
+			t.clear(0, t.y+1, t.Cols, t.Rows)
+		case 1:
+			t.clear(0, 0, t.Cols, t.y)
+			t.clear(0, t.y, t.x+1, t.y+1)
+		default:
+			t.clear(0, 0, t.Cols, t.Rows)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.x, t.y, t.Cols, t.y+1)
+		case 1:
+			t.clear(0, t.y, t.x+1, t.y+1)
+		default:
+			t.clear(0, t.y, t.Cols, t.y+1)
+		}
+	case 'L', 'M':
+		if t.y < t.top || t.y > t.bottom {
+			break
+		}
+		top := t.top
+		t.top = t.y
+		if final == 'L' {
+			t.scrollDown(arg(0, 1))
+		} else {
+			t.scrollUp(arg(0, 1))
+		}
+		t.top = top
+	case '@', 'P':
+		n := arg(0, 1)
+		if n > t.Cols-t.x {
+			n = t.Cols - t.x
+		}
+		line := t.screen[t.y]
+		if final == '@' {
+			copy(line[t.x+n:], line[t.x:])
+			t.clear(t.x, t.y, t.x+n, t.y+1)
+		} else {
+			copy(line[t.x:], line[t.x+n:])
+			t.clear(t.Cols-n, t.y, t.Cols, t.y+1)
+		}
+	case 'X':
+		x1 := t.x + arg(0, 1)
+		if x1 > t.Cols {
+			x1 = t.Cols
+		}
+		t.clear(t.x, t.y, x1, t.y+1)
+	case 'S':
+		t.scrollUp(arg(0, 1))
+	case 'T':
+		t.scrollDown(arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, t.Rows)-1
+		if top < bottom && bottom < t.Rows {
+			t.top, t.bottom = top, bottom
+			t.x, t.y = 0, 0
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	case 'm':
+		t.sgr(args)
+	}
+}
+
+func (t *VT) altScreen(on, saveCursor bool) {
+	if on == (t.main != nil) {
+		return
+	}
+	if on {
+		if saveCursor {
+			t.saveCursor()
+		}
+		t.main = t.screen
+		t.screen = make([][]Cell, t.Rows)
+		for y := range t.screen {
+			t.screen[y] = blankLine(t.Cols, defaultAttr)
+		}
+	} else {
+		t.screen, t.main = t.main, nil
+		if saveCursor {
+			t.restoreCursor()
+		}
+	}
+}
+
+func (t *VT) sgr(args []int) {
+	for i := 0; i < len(args); i++ {
+		switch n := args[i]; {
+		case n == 0:
+			t.attr = defaultAttr
+		case n == 1:
+			t.attr.Bold = true
+		case n == 3:
+			t.attr.Italic = true
+		case n == 4:
+			t.attr.Underline = true
+		case n == 7:
+			t.attr.Reverse = true
+		case n == 22:
+			t.attr.Bold = false
+		case n == 23:
+			t.attr.Italic = false
+		case n == 24:
+			t.attr.Underline = false
+		case n == 27:
+			t.attr.Reverse = false
+		case n >= 30 && n <= 37:
+			t.attr.Fg = int32(n - 30)
+		case n == 39:
+			t.attr.Fg = COLOR_DEFAULT
+		case n >= 40 && n <= 47:
+			t.attr.Bg = int32(n - 40)
+		case n == 49:
+			t.attr.Bg = COLOR_DEFAULT
+		case n >= 90 && n <= 97:
+			t.attr.Fg = int32(n - 90 + 8)
+		case n >= 100 && n <= 107:
+			t.attr.Bg = int32(n - 100 + 8)
+		case n == 38 || n == 48:
+			var color int32 = COLOR_DEFAULT
+			if i+2 < len(args) && args[i+1] == 5 {
+				color = int32(args[i+2] & 0xff)
+				i += 2
+			} else if i+4 < len(args) && args[i+1] == 2 {
+				color = COLOR_RGB | int32(args[i+2]&0xff)<<16 |
+					int32(args[i+3]&0xff)<<8 | int32(args[i+4]&0xff)
+				i += 4
+			}
+			if n == 38 {
+				t.attr.Fg = color
+			} else {
+				t.attr.Bg = color
+			}
+		}
+	}
+}
@@ -2,19 +2,66 @@
     var httpsEnabled = window.location.protocol == "https:";
     var args = window.location.search;
     var url = (httpsEnabled ? 'wss://' : 'ws://') + window.location.host + window.location.pathname + 'ws';
-    var protocols = ["gotty"];
+    // gotty.v2 sends the output in binary frames, servers without it
+    // pick gotty
+    var protocols = ["gotty.v2", "gotty"];
     var autoReconnect = -1;
 
-    var openWs = function() {
-        var ws = new WebSocket(url, protocols);
+    // the terminal and the bars are kept when the session is resumed
+    var term;
+
+    var player;
+
+    var keyboard;
+
+    var chat;
+
+    // the token to resume the session with, and the offset of the
+    // output written to the terminal
+    var resumeToken = null;
+    var offset = 0;
 
-        var term;
+    // conn sends on the current websocket
+    var ws;
+    var conn = {
+        send: function(data) {
+            if (ws && ws.readyState == WebSocket.OPEN) {
+                ws.send(data);
+            }
+        },
+    };
+
+    var output = function(data) {
+        offset += data.length;
+        term.io.writeUTF8(data);
+    };
+
+    var openWs = function() {
+        var socket = new WebSocket(url, protocols);
+        socket.binaryType = "arraybuffer";
+        ws = socket;
 
         var pingTimer;
 
-        ws.onopen = function(event) {
-            ws.send(JSON.stringify({ Arguments: args, AuthToken: gotty_auth_token,}));
-            pingTimer = setInterval(sendPing, 30 * 1000, ws);
+        // a session which can't be resumed closes the websocket before
+        // it sends anything
+        var resuming = resumeToken != null && term != null;
+        var received = false;
+
+        socket.onopen = function(event) {
+            var init = { Arguments: args, AuthToken: gotty_auth_token,};
+            if (resuming) {
+                init.ResumeToken = resumeToken;
+                init.ResumeOffset = offset;
+            }
+            socket.send(JSON.stringify(init));
+            pingTimer = setInterval(sendPing, 30 * 1000, conn);
+
+            if (resuming) {
+                term.installKeyboard();
+                return;
+            }
+            offset = 0;
 
             hterm.defaultStorage = new lib.Storage.Local();
             hterm.defaultStorage.clear();
@@ -27,13 +74,13 @@
                 var io = term.io.push();
 
                 io.onVTKeystroke = function(str) {
-                    ws.send("0" + str);
+                    conn.send("0" + str);
                 };
 
                 io.sendString = io.onVTKeystroke;
 
                 io.onTerminalResize = function(columns, rows) {
-                    ws.send(
+                    conn.send(
                         "2" + JSON.stringify(
                             {
                                 columns: columns,
@@ -49,11 +96,22 @@
             term.decorate(document.getElementById("terminal"));
         };
 
-        ws.onmessage = function(event) {
+        socket.onmessage = function(event) {
+            if (resuming && !received) {
+                term.showOverlay("Reconnected", 1000);
+            }
+            received = true;
+            if (event.data instanceof ArrayBuffer) {
+                var bytes = new Uint8Array(event.data);
+                if (bytes[0] == 0x30) {
+                    output(binaryString(bytes.subarray(1)));
+                }
+                return;
+            }
             data = event.data.slice(1);
             switch(event.data[0]) {
             case '0':
-                term.io.writeUTF8(window.atob(data));
+                output(window.atob(data));
                 break;
             case '1':
                 // pong
@@ -72,25 +130,303 @@
                 autoReconnect = JSON.parse(data);
                 console.log("Enabling reconnect: " + autoReconnect + " seconds")
                 break;
+            case '5':
+                if (!player) {
+                    player = createPlayer(conn);
+                }
+                player.update(JSON.parse(data));
+                break;
+            case '6':
+                if (!keyboard) {
+                    keyboard = createKeyboard(conn);
+                }
+                keyboard.update(JSON.parse(data));
+                break;
+            case '7':
+                if (!chat) {
+                    chat = createChat(conn);
+                }
+                chat.presence(JSON.parse(data));
+                break;
+            case '8':
+                if (!chat) {
+                    chat = createChat(conn);
+                }
+                chat.message(JSON.parse(data));
+                break;
+            case '9':
+                var resume = JSON.parse(data);
+                if (resume.token) {
+                    resumeToken = resume.token;
+                }
+                // after a redraw of the screen the count starts again
+                offset = resume.offset;
+                break;
             }
         };
 
-        ws.onclose = function(event) {
+        socket.onclose = function(event) {
+            if (resuming && !received) {
+                // the session is gone
+                resumeToken = null;
+            }
+            var reconnect = autoReconnect > 0 && (resumeToken != null || !resuming);
             if (term) {
                 term.uninstallKeyboard();
-                term.io.showOverlay("Connection Closed", null);
+                term.io.showOverlay(reconnect ? "Reconnecting" : "Connection Closed", null);
             }
             clearInterval(pingTimer);
-            if (autoReconnect > 0) {
+            if (player && !(reconnect && resumeToken != null)) {
+                player.stop();
+                player = null;
+            }
+            if (reconnect) {
                 setTimeout(openWs, autoReconnect * 1000);
             }
         };
     }
 
+    // binaryString makes the byte string writeUTF8 takes, as atob does
+    var binaryString = function(bytes) {
+        var s = "";
+        for (var i = 0; i < bytes.length; i += 8192) {
+            s += String.fromCharCode.apply(null, bytes.subarray(i, i + 8192));
+        }
+        return s;
+    };
 
     var sendPing = function(ws) {
         ws.send("1");
     }
 
+    // createPlayer adds playback controls for a recording, the server
+    // sends the play state after every change and the bar extrapolates it
+    var createPlayer = function(ws) {
+        var state = {paused: false, speed: 1, position: 0, duration: 0};
+        var since = Date.now();
+        var seeking = false;
+
+        var control = function(args) {
+            ws.send("5" + JSON.stringify(args));
+        };
+
+        var bar = document.createElement("div");
+        bar.style.cssText = "position: absolute; bottom: 0px; left: 0px; right: 0px; " +
+            "z-index: 10; padding: 4px; background: rgba(0, 0, 0, 0.7); " +
+            "color: #fff; font: 12px sans-serif; display: flex; align-items: center;";
+
+        var button = function(label, title, onclick) {
+            var b = document.createElement("button");
+            b.textContent = label;
+            b.title = title;
+            b.onclick = onclick;
+            bar.appendChild(b);
+            return b;
+        };
+
+        var toggle = button("||", "pause/resume", function() {
+            control({action: "toggle"});
+        });
+        button(">|", "next frame", function() {
+            control({action: "step"});
+        });
+
+        var speed = document.createElement("select");
+        [0.25, 0.5, 1, 2, 4, 8, 16].forEach(function(s) {
+            var o = document.createElement("option");
+            o.value = s;
+            o.textContent = s + "x";
+            speed.appendChild(o);
+        });
+        speed.onchange = function() {
+            control({action: "speed", speed: parseFloat(speed.value)});
+        };
+        bar.appendChild(speed);
+
+        var slider = document.createElement("input");
+        slider.type = "range";
+        slider.min = 0;
+        slider.step = 0.1;
+        slider.style.flex = "1";
+        slider.oninput = function() {
+            seeking = true;
+        };
+        slider.onchange = function() {
+            seeking = false;
+            control({action: "seek", time: parseFloat(slider.value)});
+        };
+        bar.appendChild(slider);
+
+        var label = document.createElement("span");
+        bar.appendChild(label);
+        document.body.appendChild(bar);
+
+        var clock = function(t) {
+            t = Math.floor(t);
+            var s = t % 60;
+            return Math.floor(t / 60) + ":" + (s < 10 ? "0" : "") + s;
+        };
+
+        var render = function() {
+            var pos = state.position;
+            if (!state.paused) {
+                pos += (Date.now() - since) / 1000 * state.speed;
+            }
+            pos = Math.min(pos, state.duration);
+            if (!seeking) {
+                slider.value = pos;
+            }
+            label.textContent = " " + clock(pos) + " / " + clock(state.duration);
+        };
+        var timer = setInterval(render, 250);
+
+        return {
+            update: function(s) {
+                state = s;
+                since = Date.now();
+                toggle.textContent = s.paused ? ">" : "||";
+                slider.max = s.duration;
+                speed.value = s.speed;
+                render();
+            },
+            stop: function() {
+                clearInterval(timer);
+            },
+        };
+    }
+
+    // createKeyboard shows who holds the keyboard of a session started
+    // with -handoff, lets the others ask for it and the holder or the
+    // creator pass it on
+    var createKeyboard = function(ws) {
+        var control = function(args) {
+            ws.send("7" + JSON.stringify(args));
+        };
+
+        var bar = document.createElement("div");
+        bar.style.cssText = "position: absolute; top: 0px; right: 0px; " +
+            "z-index: 10; padding: 4px; background: rgba(0, 0, 0, 0.7); " +
+            "color: #fff; font: 12px sans-serif;";
+        document.body.appendChild(bar);
+
+        // a resumed session sends the last messages again
+        var seen = {};
+
+        var name = function(p) {
+            return p.user ? p.user + " (" + p.name + ")" : p.name;
+        };
+        var same = function(a, b) {
+            return a.name == b.name && a.addr == b.addr;
+        };
+        var button = function(label, onclick) {
+            var b = document.createElement("button");
+            b.textContent = label;
+            b.onclick = onclick;
+            bar.appendChild(b);
+        };
+
+        return {
+            update: function(s) {
+                bar.innerHTML = "";
+                var holds = same(s.holder, s.self);
+                var label = document.createElement("span");
+                label.textContent = "keyboard: " + (holds ? "you " : name(s.holder) + " ");
+                bar.appendChild(label);
+
+                if (holds && !same(s.creator, s.self)) {
+                    button("release", function() {
+                        control({action: "release"});
+                    });
+                } else if (!holds && s.manage) {
+                    button("take back", function() {
+                        control({action: "revoke"});
+                    });
+                } else if (s.writable) {
+                    var asked = s.requests.some(function(p) {
+                        return same(p, s.self);
+                    });
+                    button(asked ? "requested" : "request", function() {
+                        control({action: "request"});
+                    });
+                }
+                if (s.manage) {
+                    s.requests.forEach(function(p) {
+                        button("give to " + name(p), function() {
+                            control({action: "grant", to: p});
+                        });
+                    });
+                }
+            },
+        };
+    }
+
+    // createChat lists who is in a shared session and has a chat
+    // next to the terminal, it is shown once someone else joins or writes
+    var createChat = function(ws) {
+        var panel = document.createElement("div");
+        panel.style.cssText = "position: absolute; top: 30px; right: 0px; " +
+            "width: 260px; z-index: 10; padding: 4px; display: none; " +
+            "background: rgba(0, 0, 0, 0.7); color: #fff; font: 12px sans-serif;";
+
+        var people = document.createElement("div");
+        panel.appendChild(people);
+
+        var log = document.createElement("div");
+        log.style.cssText = "max-height: 200px; overflow-y: auto; margin: 4px 0;";
+        panel.appendChild(log);
+
+        var input = document.createElement("input");
+        input.type = "text";
+        input.placeholder = "chat";
+        input.style.cssText = "width: 100%; box-sizing: border-box;";
+        input.onkeydown = function(e) {
+            // keep the keys away from the terminal
+            e.stopPropagation();
+            if (e.keyCode == 13 && input.value.trim() != "") {
+                ws.send("8" + JSON.stringify({text: input.value}));
+                input.value = "";
+            }
+        };
+        panel.appendChild(input);
+        document.body.appendChild(panel);
+
+        var name = function(p) {
+            return p.user ? p.user + " (" + p.name + ")" : p.name;
+        };
+        var line = function(text, color) {
+            var d = document.createElement("div");
+            d.textContent = text;
+            if (color) {
+                d.style.color = color;
+            }
+            log.appendChild(d);
+            log.scrollTop = log.scrollHeight;
+        };
+
+        return {
+            presence: function(m) {
+                if (m.participants.length > 1) {
+                    panel.style.display = "block";
+                }
+                people.textContent = m.participants.map(function(p) {
+                    return name(p) + (p.write ? " \u270e" : "");
+                }).join(", ");
+                if (m.event == "leave" || m.participants.length > 1) {
+                    line(name(m.participant) + (m.event == "join" ? " joined" : " left"), "#aaa");
+                }
+            },
+            message: function(m) {
+                var id = m.time + "/" + m.name + "/" + m.addr + "/" + m.text;
+                if (seen[id]) {
+                    return;
+                }
+                seen[id] = true;
+                panel.style.display = "block";
+                var t = new Date(m.time);
+                line(t.toLocaleTimeString() + " " + (m.user || m.name) + ": " + m.text);
+            },
+        };
+    }
+
     openWs();
 })()
@@ -0,0 +1,449 @@
+package tty
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/yubo/gotty/rec"
+)
+
+const apiPrefix = "/api/v1/"
+
+// apiSessionRequest is the body of POST /api/v1/sessions
+type apiSessionRequest struct {
+	CmdOptions
+	Method  string   `json:"method"`
+	Command []string `json:"command"`
+}
+
+type apiSession struct {
+	Name       string   `json:"name"`
+	Addr       string   `json:"addr"`
+	ParentName string   `json:"parent_name,omitempty"`
+	ParentAddr string   `json:"parent_addr,omitempty"`
+	Method     string   `json:"method"`
+	Status     string   `json:"status"`
+	Command    []string `json:"command"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	ConnTime   int64    `json:"conn_time,omitempty"`
+	LinkNb     int32    `json:"link_nb"`
+	RecId      string   `json:"rec_id,omitempty"`
+	Share      bool     `json:"share"`
+	Token      string   `json:"token,omitempty"`
+}
+
+type apiRecording struct {
+	rec.Meta
+	ModTime int64 `json:"mod_time"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newApiSession(info *Session_info) *apiSession {
+	return &apiSession{
+		Name:       info.Key.Name,
+		Addr:       info.Key.Addr,
+		ParentName: info.PKey.Name,
+		ParentAddr: info.PKey.Addr,
+		Method:     info.Method,
+		Status:     info.Status,
+		Command:    info.Command,
+		RemoteAddr: info.RemoteAddr,
+		ConnTime:   info.ConnTime,
+		LinkNb:     info.LinkNb,
+		RecId:      info.RecId,
+		Share:      info.Share,
+		Token:      info.Token,
+	}
+}
+
+func renderApiError(w http.ResponseWriter, code int, format string,
+	args ...interface{}) {
+	RenderJsonStatus(w, code, apiError{Error: fmt.Sprintf(format, args...)})
+}
+
+// apiHandler serves the /api/v1/ tree
+//
+//	GET    /api/v1/sessions
+//	POST   /api/v1/sessions
+//	GET    /api/v1/sessions/<name>?addr=<addr>
+//	DELETE /api/v1/sessions/<name>?addr=<addr>&all=true
+//	GET    /api/v1/recordings?user=<user>&name=<name>&since=<unix time>...
+//	GET    /api/v1/recordings/<id>
+//	DELETE /api/v1/recordings/<id>
+//	GET    /api/v1/search?q=<words>&limit=<n>&user=<user>...
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowed(r, ROLE_VIEWER) {
+		apiForbidden(w)
+		return
+	}
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	resource, id := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		resource, id = p[:i], p[i+1:]
+	}
+
+	switch {
+	case resource == "sessions" && id == "":
+		switch r.Method {
+		case "GET":
+			apiListSessions(w, r)
+		case "POST":
+			if !allowed(r, ROLE_OPERATOR) {
+				apiForbidden(w)
+			} else {
+				apiCreateSession(w, r)
+			}
+		default:
+			apiMethodNotAllowed(w, "GET, POST")
+		}
+	case resource == "sessions":
+		switch r.Method {
+		case "GET":
+			apiGetSession(w, r, id)
+		case "DELETE":
+			if !allowed(r, ROLE_OPERATOR) {
+				apiForbidden(w)
+			} else {
+				apiCloseSession(w, r, id)
+			}
+		default:
+			apiMethodNotAllowed(w, "GET, DELETE")
+		}
+	case resource == "recordings" && id == "":
+		if r.Method != "GET" {
+			apiMethodNotAllowed(w, "GET")
+			return
+		}
+		apiListRecordings(w, r)
+	case resource == "recordings":
+		switch r.Method {
+		case "GET":
+			apiGetRecording(w, r, id)
+		case "DELETE":
+			if !allowed(r, ROLE_ADMIN) {
+				apiForbidden(w)
+			} else {
+				apiDeleteRecording(w, r, id)
+			}
+		default:
+			apiMethodNotAllowed(w, "GET, DELETE")
+		}
+	case resource == "search" && id == "":
+		if r.Method != "GET" {
+			apiMethodNotAllowed(w, "GET")
+			return
+		}
+		apiSearch(w, r)
+	default:
+		renderApiError(w, http.StatusNotFound, "%s not found", r.URL.Path)
+	}
+}
+
+func apiForbidden(w http.ResponseWriter) {
+	renderApiError(w, http.StatusForbidden, "permission denied")
+}
+
+func apiMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	renderApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func apiListSessions(w http.ResponseWriter, r *http.Request) {
+	infos := Session_infos{}
+	if err := new(Cmd).Ps(&CallOptions{}, (*[]Session_info)(&infos)); err != nil {
+		renderApiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	sort.Sort(infos)
+
+	all := r.URL.Query().Get("all") == "true"
+	ret := []*apiSession{}
+	for i := range infos {
+		if !all && infos[i].Status == CONN_S_CLOSED {
+			continue
+		}
+		ret = append(ret, newApiSession(&infos[i]))
+	}
+	RenderJson(w, ret)
+}
+
+// apiLookupSession finds a session by name, the addr parameter is only
+// needed when the name is used with more than one addr
+func apiLookupSession(r *http.Request, name string) (*Session_info, int, error) {
+	infos := []Session_info{}
+	if err := new(Cmd).Ps(&CallOptions{}, &infos); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	addr, byAddr := r.URL.Query()["addr"]
+	var found []Session_info
+	for _, info := range infos {
+		if info.Key.Name != name {
+			continue
+		}
+		if byAddr && info.Key.Addr != addr[0] {
+			continue
+		}
+		found = append(found, info)
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, http.StatusNotFound,
+			fmt.Errorf("session %s is not exist", name)
+	case 1:
+		return &found[0], http.StatusOK, nil
+	default:
+		return nil, http.StatusBadRequest,
+			fmt.Errorf("session name %s is ambiguous, set addr", name)
+	}
+}
+
+func apiGetSession(w http.ResponseWriter, r *http.Request, name string) {
+	info, code, err := apiLookupSession(r, name)
+	if err != nil {
+		renderApiError(w, code, "%v", err)
+		return
+	}
+	RenderJson(w, newApiSession(info))
+}
+
+func apiCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req apiSessionRequest
+	var info Session_info
+	var err error
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderApiError(w, http.StatusBadRequest, "malformed request: %v", err)
+		return
+	}
+
+	opt := &CallOptions{Opt: req.CmdOptions, Args: req.Command}
+	if u := requestUser(r); u != nil {
+		opt.Opt.Creator = u.Name
+	}
+	if len(opt.Args) == 0 {
+		opt.Args = strings.Fields(opt.Opt.Cmd)
+	}
+	if opt.Opt.Addr == "" {
+		opt.Opt.Addr = DefaultCmdOptions.Addr
+	}
+	if opt.Opt.Speed == 0 {
+		opt.Opt.Speed = DefaultCmdOptions.Speed
+	}
+	if opt.Opt.Name != "" {
+		if _, ok := daemon.session[ConnKey{Name: opt.Opt.Name,
+			Addr: opt.Opt.Addr}]; ok {
+			renderApiError(w, http.StatusConflict,
+				"session %s/%s is exist", opt.Opt.Name, opt.Opt.Addr)
+			return
+		}
+	}
+
+	switch req.Method {
+	case CONN_M_EXEC, "":
+		if len(opt.Args) == 0 {
+			renderApiError(w, http.StatusBadRequest, "command is required")
+			return
+		}
+		err = new(Cmd).Exec(opt, &info)
+	case CONN_M_PLAY:
+		if !validRecId(opt.Opt.RecId) {
+			renderApiError(w, http.StatusBadRequest, "invalid recid")
+			return
+		}
+		if _, err := os.Stat(recFilePath(opt.Opt.RecId)); err != nil {
+			renderApiError(w, http.StatusNotFound,
+				"recording %s is not exist", opt.Opt.RecId)
+			return
+		}
+		err = new(Cmd).Play(opt, &info)
+	case CONN_M_ATTACH:
+		skey := ConnKey{Name: opt.Opt.SName, Addr: opt.Opt.SAddr}
+		if _, ok := daemon.session[skey]; !ok {
+			renderApiError(w, http.StatusNotFound,
+				"session %s is not exist", skey)
+			return
+		}
+		err = new(Cmd).Attach(*opt, &info)
+	default:
+		renderApiError(w, http.StatusBadRequest,
+			"unknown method %q", req.Method)
+		return
+	}
+	if err != nil {
+		glog.Errorf("api %s %v\n", req.Method, err)
+		renderApiError(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+
+	if s, ok := daemon.session[info.Key]; ok {
+		info.Method = s.method
+		info.Status = s.status
+		info.Command = s.command
+		info.LinkNb = s.linkNb
+		info.Share = s.options.PermitShare
+	}
+	w.Header().Set("Location", apiPrefix+"sessions/"+info.Key.Name+
+		"?addr="+url.QueryEscape(info.Key.Addr))
+	RenderJsonStatus(w, http.StatusCreated, newApiSession(&info))
+}
+
+func apiCloseSession(w http.ResponseWriter, r *http.Request, name string) {
+	info, code, err := apiLookupSession(r, name)
+	if err != nil {
+		renderApiError(w, code, "%v", err)
+		return
+	}
+
+	opt := &CallOptions{Opt: CmdOptions{
+		Name: info.Key.Name,
+		Addr: info.Key.Addr,
+		All:  r.URL.Query().Get("all") == "true",
+	}}
+	keys := []ConnKey{}
+	if err := new(Cmd).Close(opt, &keys); err != nil {
+		renderApiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validRecId(id string) bool {
+	return id != "" && id != "." && id != ".." && path.Base(id) == id &&
+		rec.IsRecording(id)
+}
+
+func recFilePath(id string) string {
+	return expandHomeDir(GlobalOpt.RecFileDir) + "/" + id
+}
+
+// recFilter reads a recording filter from the query parameters name,
+// addr, user, command, since, until (unix seconds) and min_duration
+func recFilter(q url.Values) (*rec.MetaFilter, error) {
+	var err error
+	f := &rec.MetaFilter{
+		Name:    q.Get("name"),
+		Addr:    q.Get("addr"),
+		User:    q.Get("user"),
+		Command: q.Get("command"),
+	}
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid since %q", v)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid until %q", v)
+		}
+	}
+	if v := q.Get("min_duration"); v != "" {
+		if f.MinDuration, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid min_duration %q", v)
+		}
+	}
+	return f, nil
+}
+
+func newApiRecording(m *rec.Meta) *apiRecording {
+	modTime := m.End
+	if modTime == 0 {
+		if fi, err := os.Stat(recFilePath(m.Id)); err == nil {
+			modTime = fi.ModTime().Unix()
+		}
+	}
+	return &apiRecording{Meta: *m, ModTime: modTime}
+}
+
+func apiListRecordings(w http.ResponseWriter, r *http.Request) {
+	filter, err := recFilter(r.URL.Query())
+	if err != nil {
+		renderApiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	metas, err := rec.ListMeta(expandHomeDir(GlobalOpt.RecFileDir))
+	if err != nil {
+		renderApiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	ret := []*apiRecording{}
+	for _, m := range metas {
+		if filter.Match(m) {
+			ret = append(ret, newApiRecording(m))
+		}
+	}
+	RenderJson(w, ret)
+}
+
+func apiGetRecording(w http.ResponseWriter, r *http.Request, id string) {
+	if !validRecId(id) {
+		renderApiError(w, http.StatusBadRequest, "invalid recid")
+		return
+	}
+	m, err := rec.LoadMeta(recFilePath(id))
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "recording %s is not exist", id)
+		return
+	}
+	RenderJson(w, newApiRecording(m))
+}
+
+// apiSearch returns the output lines of recordings which have every
+// word of q, the recording filters of the list apply too
+func apiSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := recFilter(q)
+	if err != nil {
+		renderApiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			renderApiError(w, http.StatusBadRequest, "invalid limit %q", v)
+			return
+		}
+	}
+	if len(rec.Terms(q.Get("q"))) == 0 {
+		renderApiError(w, http.StatusBadRequest, "empty query")
+		return
+	}
+
+	hits, err := rec.Search(expandHomeDir(GlobalOpt.RecFileDir), q.Get("q"),
+		filter, limit)
+	if err != nil {
+		renderApiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	RenderJson(w, hits)
+}
+
+func apiDeleteRecording(w http.ResponseWriter, r *http.Request, id string) {
+	if !validRecId(id) {
+		renderApiError(w, http.StatusBadRequest, "invalid recid")
+		return
+	}
+	if err := rec.Remove(recFilePath(id)); err != nil {
+		if os.IsNotExist(err) {
+			renderApiError(w, http.StatusNotFound,
+				"recording %s is not exist", id)
+		} else {
+			renderApiError(w, http.StatusInternalServerError, "%v", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
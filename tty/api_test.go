@@ -0,0 +1,100 @@
+package tty
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func apiDo(method, url, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	apiHandler(w, r)
+	return w
+}
+
+func TestApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/123", []byte("rec"), 0644)
+	ioutil.WriteFile(dir+"/123.meta", []byte(`{"user":"alice","start":1}`), 0644)
+
+	GlobalOpt.RecFileDir = dir
+	key := ConnKey{Name: "abc", Addr: "127.0.0.1/32"}
+	daemon = &Daemon{session: map[ConnKey]*session{
+		key: &session{key: key, method: CONN_M_EXEC,
+			status: CONN_S_WAITING, options: &CmdOptions{}},
+	}}
+
+	var ss []apiSession
+	w := apiDo("GET", "/api/v1/sessions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list sessions: %d", w.Code)
+	}
+	json.Unmarshal(w.Body.Bytes(), &ss)
+	if len(ss) != 1 || ss[0].Name != "abc" || ss[0].Status != CONN_S_WAITING {
+		t.Fatalf("list sessions: %s", w.Body.String())
+	}
+
+	if w = apiDo("GET", "/api/v1/sessions/abc", ""); w.Code != http.StatusOK {
+		t.Fatalf("get session: %d", w.Code)
+	}
+	if w = apiDo("GET", "/api/v1/sessions/abc?addr=10.0.0.0/8", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get session with other addr: %d", w.Code)
+	}
+	if w = apiDo("POST", "/api/v1/sessions", "{"); w.Code != http.StatusBadRequest {
+		t.Fatalf("post malformed body: %d", w.Code)
+	}
+	if w = apiDo("POST", "/api/v1/sessions",
+		`{"method":"exec","name":"abc","addr":"127.0.0.1/32","command":["bash"]}`); w.Code != http.StatusConflict {
+		t.Fatalf("post existing session: %d", w.Code)
+	}
+	if w = apiDo("PUT", "/api/v1/sessions", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("put sessions: %d", w.Code)
+	}
+
+	var rs []apiRecording
+	w = apiDo("GET", "/api/v1/recordings", "")
+	json.Unmarshal(w.Body.Bytes(), &rs)
+	if len(rs) != 1 || rs[0].Id != "123" || rs[0].Size != 3 || rs[0].User != "alice" {
+		t.Fatalf("list recordings: %s", w.Body.String())
+	}
+	w = apiDo("GET", "/api/v1/recordings?user=bob", "")
+	if w.Body.String() != "[]" {
+		t.Fatalf("list recordings of bob: %s", w.Body.String())
+	}
+	if w = apiDo("GET", "/api/v1/recordings?since=x", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("list recordings since x: %d", w.Code)
+	}
+	if w = apiDo("GET", "/api/v1/recordings/..", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("get invalid recording: %d", w.Code)
+	}
+	if w = apiDo("DELETE", "/api/v1/recordings/123", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete recording: %d", w.Code)
+	}
+	if w = apiDo("GET", "/api/v1/recordings/123", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get deleted recording: %d", w.Code)
+	}
+	if _, err := os.Stat(dir + "/123.meta"); !os.IsNotExist(err) {
+		t.Fatalf("sidecar of a deleted recording: %v", err)
+	}
+
+	// with authentication every request needs a user
+	daemon.users = &userDB{users: map[string]*authUser{}}
+	if w = apiDo("GET", "/api/v1/sessions", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("list sessions without a user: %d", w.Code)
+	}
+	r := withUser(httptest.NewRequest("GET", "/api/v1/sessions", nil),
+		&authUser{Name: "alice", Role: ROLE_VIEWER})
+	w = httptest.NewRecorder()
+	if apiHandler(w, r); w.Code != http.StatusOK {
+		t.Fatalf("list sessions as a viewer: %d", w.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package tty
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	AUDIT_CREATE   = "create"
+	AUDIT_CONNECT  = "connect"
+	AUDIT_SHARE    = "share"
+	AUDIT_ATTACH   = "attach"
+	AUDIT_REATTACH = "reattach"
+	AUDIT_DETACH   = "detach"
+	AUDIT_INPUT    = "input"
+	AUDIT_CONTROL  = "control"
+	AUDIT_CHAT     = "chat"
+	AUDIT_CLOSE    = "close"
+	AUDIT_EXIT     = "exit"
+	AUDIT_EXPIRE   = "expire"
+	AUDIT_TIMEOUT  = "timeout"
+	AUDIT_RESUME   = "resume"
+)
+
+// auditEvent is one line of the audit log
+type auditEvent struct {
+	Time       string   `json:"time"`
+	Event      string   `json:"event"`
+	Name       string   `json:"name"`
+	Addr       string   `json:"addr"`
+	ParentName string   `json:"parent_name,omitempty"`
+	ParentAddr string   `json:"parent_addr,omitempty"`
+	Method     string   `json:"method"`
+	Command    []string `json:"command,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Creator    string   `json:"creator,omitempty"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	Write      bool     `json:"write"`
+	Share      bool     `json:"share"`
+	ShareWrite bool     `json:"share_write"`
+	Rec        bool     `json:"rec"`
+	Persist    bool     `json:"persist"`
+	RecId      string   `json:"rec_id,omitempty"`
+	Input      string   `json:"input,omitempty"`
+	Control    string   `json:"control,omitempty"`
+	ControlTo  string   `json:"control_to,omitempty"`
+	Chat       string   `json:"chat,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// auditLogger appends json lines to the audit_log file, a nil logger
+// discards events
+type auditLogger struct {
+	sync.Mutex
+	f     *os.File
+	input bool
+}
+
+func newAuditLogger(filePath string, input bool) (*auditLogger, error) {
+	f, err := os.OpenFile(expandHomeDir(filePath),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLogger{f: f, input: input}, nil
+}
+
+func newAuditEvent(event string, s *session) *auditEvent {
+	e := &auditEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Event:   event,
+		Name:    s.key.Name,
+		Addr:    s.key.Addr,
+		Method:  s.method,
+		Command: s.command,
+	}
+	if s.linkTo != nil {
+		e.ParentName = s.linkTo.key.Name
+		e.ParentAddr = s.linkTo.key.Addr
+		e.Command = s.linkTo.command
+	}
+	if s.user != nil {
+		e.User = s.user.Name
+	}
+	if s.context != nil && s.context.request != nil {
+		e.RemoteAddr = s.context.request.RemoteAddr
+	}
+	if s.options != nil {
+		e.Write = s.options.PermitWrite && !s.readOnly
+		e.Share = s.options.PermitShare
+		e.ShareWrite = s.options.PermitShareWrite
+		e.Rec = s.options.Rec
+		e.Persist = s.options.Persist
+		e.RecId = s.options.RecId
+		e.Creator = s.options.Creator
+	}
+	if s.recorder != nil {
+		e.RecId = path.Base(s.recorder.FileName)
+	}
+	return e
+}
+
+func (a *auditLogger) write(e *auditEvent) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		glog.Errorf("audit %v", err)
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if _, err := a.f.Write(append(buf, '\n')); err != nil {
+		glog.Errorf("audit %v", err)
+	}
+}
+
+// log records a lifecycle event of s
+func (a *auditLogger) log(event string, s *session) {
+	if a == nil {
+		return
+	}
+	a.write(newAuditEvent(event, s))
+}
+
+// logsInput reports whether input events are logged
+func (a *auditLogger) logsInput() bool {
+	return a != nil && a.input
+}
+
+// logInput records what the client of s typed, if audit_input is set
+func (a *auditLogger) logInput(s *session, data []byte) {
+	if !a.logsInput() {
+		return
+	}
+	e := newAuditEvent(AUDIT_INPUT, s)
+	e.Input = string(data)
+	a.write(e)
+}
+
+// logControl records who asked for or passed the keyboard of a shared
+// session
+func (a *auditLogger) logControl(s *session, m *controlMessage) {
+	if a == nil {
+		return
+	}
+	e := newAuditEvent(AUDIT_CONTROL, s)
+	e.Control = m.Action
+	if m.Action == CONTROL_GRANT {
+		e.ControlTo = ConnKey{Name: m.To.Name, Addr: m.To.Addr}.String()
+	}
+	a.write(e)
+}
+
+// logTimeout records why the daemon closed the session of s
+func (a *auditLogger) logTimeout(s *session, reason string) {
+	if a == nil {
+		return
+	}
+	e := newAuditEvent(AUDIT_TIMEOUT, s)
+	e.Reason = reason
+	a.write(e)
+}
+
+// logChat records a chat message of the client of s
+func (a *auditLogger) logChat(s *session, text string) {
+	if a == nil {
+		return
+	}
+	e := newAuditEvent(AUDIT_CHAT, s)
+	e.Chat = text
+	a.write(e)
+}
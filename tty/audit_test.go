@@ -0,0 +1,67 @@
+package tty
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	f, err := ioutil.TempFile("", "gotty-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var nilLogger *auditLogger
+	nilLogger.log(AUDIT_CREATE, &session{})
+
+	a, err := newAuditLogger(f.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"},
+		method: CONN_M_EXEC, command: []string{"bash"},
+		options: &CmdOptions{PermitWrite: true, Creator: "root"}}
+	child := &session{key: ConnKey{Name: "def", Addr: "127.0.0.1"},
+		linkTo: parent, method: CONN_M_SHARE, options: parent.options,
+		user:    &authUser{Name: "alice", Role: ROLE_OPERATOR},
+		context: &clientContext{request: httptest.NewRequest("GET", "/ws", nil)}}
+
+	a.log(AUDIT_CREATE, parent)
+	a.log(AUDIT_SHARE, child)
+	a.logInput(child, []byte("ls\r"))
+	a.input = false
+	a.logInput(child, []byte("secret\r"))
+
+	r, _ := os.Open(f.Name())
+	defer r.Close()
+	var evs []auditEvent
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("malformed line %q", scanner.Text())
+		}
+		evs = append(evs, e)
+	}
+
+	if len(evs) != 3 {
+		t.Fatalf("got %d events, want 3", len(evs))
+	}
+	if e := evs[0]; e.Event != AUDIT_CREATE || e.Name != "abc" ||
+		e.Creator != "root" || !e.Write || e.Time == "" {
+		t.Errorf("create event %+v", e)
+	}
+	if e := evs[1]; e.Event != AUDIT_SHARE || e.ParentName != "abc" ||
+		e.User != "alice" || e.RemoteAddr == "" || e.Command[0] != "bash" {
+		t.Errorf("share event %+v", e)
+	}
+	if e := evs[2]; e.Event != AUDIT_INPUT || e.Input != "ls\r" {
+		t.Errorf("input event %+v", e)
+	}
+}
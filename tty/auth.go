@@ -0,0 +1,193 @@
+package tty
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ROLE_VIEWER   = "viewer"
+	ROLE_OPERATOR = "operator"
+	ROLE_ADMIN    = "admin"
+)
+
+// a successful login is remembered this long, so that the assets and
+// api calls of a browser do not run bcrypt every time
+const AUTH_CACHE_TTL = 5 * time.Minute
+
+var roleLevel = map[string]int{
+	ROLE_VIEWER:   1,
+	ROLE_OPERATOR: 2,
+	ROLE_ADMIN:    3,
+}
+
+type authUser struct {
+	Name string
+	Role string
+	hash []byte
+}
+
+// userDB is loaded from the htpasswd style user_file, one user per line:
+//
+//	name:bcrypt-hash[:role]
+//
+// role is one of viewer, operator or admin, viewer if omitted
+type userDB struct {
+	users map[string]*authUser
+
+	sync.Mutex
+	// expiry of the recent logins, by a salted hash of the credentials
+	cache map[[sha256.Size]byte]time.Time
+	salt  []byte
+}
+
+type userCtxKey struct{}
+
+func loadUserFile(filePath string) (*userDB, error) {
+	f, err := os.Open(expandHomeDir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &userDB{users: make(map[string]*authUser)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d malformed user entry", filePath, n)
+		}
+		u := &authUser{Name: fields[0], Role: ROLE_VIEWER, hash: []byte(fields[1])}
+		if len(fields) == 3 {
+			u.Role = fields[2]
+		}
+		if _, ok := roleLevel[u.Role]; !ok {
+			return nil, fmt.Errorf("%s:%d unknown role %q", filePath, n, u.Role)
+		}
+		if _, err := bcrypt.Cost(u.hash); err != nil {
+			return nil, fmt.Errorf("%s:%d %v", filePath, n, err)
+		}
+		db.users[u.Name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *userDB) authenticate(name, password string) *authUser {
+	key, ok := db.cached(name, password)
+	if ok {
+		return db.users[name]
+	}
+
+	u, ok := db.users[name]
+	if !ok {
+		// spend the same time as a wrong password
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return nil
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	now := time.Now()
+	for k, expire := range db.cache {
+		if now.After(expire) {
+			delete(db.cache, k)
+		}
+	}
+	db.cache[key] = now.Add(AUTH_CACHE_TTL)
+	return u
+}
+
+// cached returns the cache key of the credentials and whether they
+// passed bcrypt recently
+func (db *userDB) cached(name, password string) ([sha256.Size]byte, bool) {
+	db.Lock()
+	defer db.Unlock()
+	if db.cache == nil {
+		db.cache = make(map[[sha256.Size]byte]time.Time)
+		db.salt = make([]byte, 32)
+		rand.Read(db.salt)
+	}
+	h := sha256.New()
+	h.Write(db.salt)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	expire, ok := db.cache[key]
+	return key, ok && time.Now().Before(expire)
+}
+
+// bcrypt hash of an unused password
+var dummyHash = []byte("$2a$10$EUGAf.m2W1VW.2mp5p4vHeRhgaumHNzea7q5VzXsPXe1RQN4PimIu")
+
+// can reports whether u has at least the permissions of role
+func (u *authUser) can(role string) bool {
+	return roleLevel[u.Role] >= roleLevel[role]
+}
+
+func withUser(r *http.Request, u *authUser) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userCtxKey{}, u))
+}
+
+// requestUser returns the authenticated user, nil if the daemon runs
+// without a user database
+func requestUser(r *http.Request) *authUser {
+	u, _ := r.Context().Value(userCtxKey{}).(*authUser)
+	return u
+}
+
+// authEnabled reports whether web users are identified, by the user
+// database or an OpenID Connect provider
+func authEnabled() bool {
+	return daemon.users != nil || daemon.oidc != nil
+}
+
+// webUser authenticates the browser behind r by its login cookie or
+// basic auth credentials
+func webUser(r *http.Request) *authUser {
+	if daemon.oidc != nil {
+		return daemon.oidc.sessionUser(r)
+	}
+	if daemon.users != nil {
+		name, password, _ := r.BasicAuth()
+		return daemon.users.authenticate(name, password)
+	}
+	return nil
+}
+
+// allowed reports whether the request's user may act as role, always
+// true when the daemon runs without user authentication
+func allowed(r *http.Request, role string) bool {
+	if !authEnabled() {
+		return true
+	}
+	u := requestUser(r)
+	return u != nil && u.can(role)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
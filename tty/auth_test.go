@@ -0,0 +1,61 @@
+package tty
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUserFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "gotty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	// password of both users is "secret"
+	f.WriteString("# comment\n" +
+		"alice:$2a$04$Cd.ZXkr.H4oQTFUz68mNau94LnESStKk/94S/UtltnbGKWmaJ4/FW:admin\n" +
+		"bob:$2a$04$Cd.ZXkr.H4oQTFUz68mNau94LnESStKk/94S/UtltnbGKWmaJ4/FW\n")
+	f.Close()
+
+	db, err := loadUserFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := db.authenticate("alice", "secret"); u == nil || !u.can(ROLE_ADMIN) {
+		t.Fatalf("alice should be an admin")
+	}
+	if u := db.authenticate("bob", "secret"); u == nil ||
+		!u.can(ROLE_VIEWER) || u.can(ROLE_OPERATOR) {
+		t.Fatalf("bob should be a viewer")
+	}
+	if db.authenticate("alice", "wrong") != nil {
+		t.Fatalf("wrong password accepted")
+	}
+	if db.authenticate("carol", "secret") != nil {
+		t.Fatalf("unknown user accepted")
+	}
+
+	// a recent login is not checked with bcrypt again
+	db.users["alice"].hash = []byte("x")
+	if db.authenticate("alice", "secret") == nil {
+		t.Fatalf("cached login rejected")
+	}
+	if db.authenticate("alice", "wrong") != nil {
+		t.Fatalf("wrong password accepted after a login")
+	}
+	for k := range db.cache {
+		db.cache[k] = time.Now().Add(-time.Second)
+	}
+	if db.authenticate("alice", "secret") != nil {
+		t.Fatalf("expired login accepted")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("eve:x:root\n"), 0600)
+	if _, err := loadUserFile(f.Name()); err == nil {
+		t.Fatalf("unknown role accepted")
+	}
+}
@@ -0,0 +1,141 @@
+package tty
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"github.com/yubo/gotty/rec"
+)
+
+const (
+	// longer chat messages are cut
+	CHAT_MAX_LEN = 1024
+	// clients who join get the last messages
+	CHAT_HISTORY = 50
+
+	PRESENCE_JOIN  = "join"
+	PRESENCE_LEAVE = "leave"
+)
+
+// presenceMessage tells the participants of a session who joined or
+// left and who is there now
+type presenceMessage struct {
+	Event        string        `json:"event"`
+	Participant  participant   `json:"participant"`
+	Participants []participant `json:"participants"`
+}
+
+// chatLog keeps the last messages of a session for the clients who join
+type chatLog struct {
+	sync.Mutex
+	msgs []*rec.ArgChat
+}
+
+func (l *chatLog) add(m *rec.ArgChat) {
+	l.Lock()
+	defer l.Unlock()
+	l.msgs = append(l.msgs, m)
+	if len(l.msgs) > CHAT_HISTORY {
+		l.msgs = append([]*rec.ArgChat(nil), l.msgs[len(l.msgs)-CHAT_HISTORY:]...)
+	}
+}
+
+func (l *chatLog) last() []*rec.ArgChat {
+	l.Lock()
+	defer l.Unlock()
+	return append([]*rec.ArgChat(nil), l.msgs...)
+}
+
+func chatMessage(m *rec.ArgChat) []byte {
+	buf, _ := json.Marshal(m)
+	return append([]byte{rec.ShowChat}, buf...)
+}
+
+// participants lists the clients connected to the session of context
+func (context *clientContext) participants() []participant {
+	ps := []participant{}
+	for key := range *context.connections {
+		ps = append(ps, newParticipant(key))
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].Name != ps[j].Name {
+			return ps[i].Name < ps[j].Name
+		}
+		return ps[i].Addr < ps[j].Addr
+	})
+	return ps
+}
+
+// sendPresence tells every client that key joined or left, connections
+// which fail are closed by the next write of the output
+func (context *clientContext) sendPresence(event string, key ConnKey) {
+	buf, _ := json.Marshal(&presenceMessage{
+		Event:        event,
+		Participant:  newParticipant(key),
+		Participants: context.participants(),
+	})
+	for _, e := range context.write(append([]byte{rec.SetParticipants}, buf...)) {
+		glog.V(2).Infof("presence to %s: %v", e.key, e.err)
+	}
+}
+
+// sendChatLog sends the last chat messages to a client which joins
+func (context *clientContext) sendChatLog() error {
+	if context.chat == nil {
+		return nil
+	}
+	for _, m := range context.chat.last() {
+		if err := context.connection.write(chatMessage(m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processChat records a chat message of participant s and sends it to
+// every client, false once no client is left
+func (context *clientContext) processChat(s *session, p []byte) bool {
+	var args struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(p, &args); err != nil {
+		glog.Errorln("Malformed remote command")
+		return true
+	}
+	text := strings.TrimSpace(args.Text)
+	if len(text) > CHAT_MAX_LEN {
+		text = text[:CHAT_MAX_LEN]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	if text == "" {
+		return true
+	}
+
+	m := &rec.ArgChat{Name: s.key.Name, Addr: s.key.Addr, Text: text,
+		Time: time.Now().UnixNano() / int64(time.Millisecond)}
+	if s.user != nil {
+		m.User = s.user.Name
+	}
+	buf, _ := json.Marshal(m)
+	context.record(append([]byte{rec.Chat}, buf...))
+	daemon.audit.logChat(s, text)
+	if context.chat != nil {
+		context.chat.add(m)
+	}
+
+	if errs := context.write(chatMessage(m)); len(errs) > 0 {
+		for _, e := range errs {
+			glog.Errorln(e.err.Error())
+			context.close(e.key)
+		}
+		return !context.orphaned()
+	}
+	return true
+}
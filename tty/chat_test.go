@@ -0,0 +1,44 @@
+package tty
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yubo/gotty/rec"
+)
+
+func TestChat(t *testing.T) {
+	l := &chatLog{}
+	for i := 0; i < CHAT_HISTORY+5; i++ {
+		l.add(&rec.ArgChat{Text: fmt.Sprint(i)})
+	}
+	if m := l.last(); len(m) != CHAT_HISTORY || m[0].Text != "5" {
+		t.Fatalf("kept %d from %s", len(m), m[0].Text)
+	}
+
+	owner := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"},
+		options: &CmdOptions{PermitWrite: true}, user: &authUser{Name: "alice",
+			Role: ROLE_OPERATOR}}
+	bob := &session{key: ConnKey{Name: "bob", Addr: "10.0.0.2"}, linkTo: owner,
+		options: &CmdOptions{}}
+	daemon = &Daemon{session: map[ConnKey]*session{
+		owner.key: owner, bob.key: bob,
+	}}
+	conns := map[ConnKey]*webConn{bob.key: nil, owner.key: nil}
+	context := &clientContext{session: owner, connections: &conns,
+		chat: &chatLog{}}
+	ps := context.participants()
+	if len(ps) != 2 || ps[0].Name != "abc" || ps[0].User != "alice" ||
+		!ps[0].Write || ps[1].Name != "bob" || ps[1].Write {
+		t.Fatalf("participants %+v", ps)
+	}
+
+	// nobody to send to, the message is kept for the clients who join
+	conns = map[ConnKey]*webConn{}
+	context.processChat(bob, []byte(`{"text":"  look at line 3  "}`))
+	context.processChat(bob, []byte(`{"text":"   "}`))
+	if m := context.chat.last(); len(m) != 1 || m[0].Text != "look at line 3" ||
+		m[0].Name != "bob" || m[0].Time == 0 {
+		t.Fatalf("chat %+v", m)
+	}
+}
@@ -8,40 +8,54 @@ import (
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/fatih/structs"
 	"github.com/golang/glog"
-	"github.com/gorilla/websocket"
 	"github.com/yubo/gotty/rec"
 )
 
-func (context *clientContext) goHandleClientJoin() error {
+// goHandleClientJoin adds a client to a running pty, from is the offset
+// of the output it got before it lost its websocket, or REPLAY_ALL
+func (context *clientContext) goHandleClientJoin(from int64) error {
 	if err := context.sendInitialize(); err != nil {
 		glog.Errorln(err.Error())
 		return err
 	}
-	(*context.connections)[context.session.key] = context.connection
-	go func() {
-		rx := &connRx{key: context.session.key}
+	if err := context.joinConnection(from); err != nil {
+		glog.Errorln(err.Error())
+		return err
+	}
+	context.sendPresence(PRESENCE_JOIN, context.session.key)
+	go context.readLoop()
+	return nil
+}
 
-		for {
-			rx.messageType, rx.p, rx.err = context.connection.conn.ReadMessage()
-			context.connRx <- rx
-			if rx.err != nil {
-				context.close(rx.key)
-				return
-			}
+// readLoop passes the messages of the client to processReceive
+func (context *clientContext) readLoop() {
+	key, wc := context.session.key, context.connection
+
+	for {
+		// processReceive may still use the last one
+		rx := &connRx{key: key, conn: wc}
+		rx.messageType, rx.p, rx.err = wc.conn.ReadMessage()
+		wc.alive()
+		context.connRx <- rx
+		if rx.err != nil {
+			context.closeConn(rx.key, wc)
+			return
 		}
-	}()
-	return nil
+	}
 }
 
 func (context *clientContext) goHandleClient() {
 	exit := make(chan bool, 2)
+	done := make(chan struct{})
 
 	daemon.server.StartRoutine()
 	(*context.connections)[context.session.key] = context.connection
+	context.sendPresence(PRESENCE_JOIN, context.session.key)
 	go func() {
 		defer func() { exit <- true }()
 
@@ -54,9 +68,13 @@ func (context *clientContext) goHandleClient() {
 		context.processReceive()
 	}()
 
+	go context.watchTimeouts(done)
+
 	go func() {
 
 		<-exit
+		close(done)
+		detached := context.session.status == CONN_S_DETACHED
 		context.session.status = CONN_S_CLOSED
 		context.pty.Close()
 		if context.session.recorder != nil {
@@ -71,9 +89,15 @@ func (context *clientContext) goHandleClient() {
 		daemon.server.FinishRoutine()
 
 		context.command.Wait()
+		daemon.audit.log(AUDIT_EXIT, context.session)
 		for key, _ := range *context.connections {
 			context.close(key)
 		}
+		if detached {
+			// nobody was connected to release the session itself
+			atomic.AddInt32(&context.session.linkNb, -1)
+			delete(daemon.session, context.session.key)
+		}
 
 		if context.session.linkNb != 0 {
 			glog.Errorf("connection closed: %s:%s, but linkNb(%d) is not zero",
@@ -84,52 +108,157 @@ func (context *clientContext) goHandleClient() {
 		glog.Infof("Connection closed: %s", context.request.RemoteAddr)
 	}()
 
-	go func() {
-		rx := &connRx{key: context.session.key}
+	go context.readLoop()
+}
 
-		for {
-			rx.messageType, rx.p, rx.err = context.connection.conn.ReadMessage()
-			context.connRx <- rx
-			if rx.err != nil {
-				context.close(rx.key)
+func (context *clientContext) close(key ConnKey) {
+	if conn, ok := (*context.connections)[key]; ok {
+		conn.close()
+		delete(*context.connections, key)
+		if root := daemon.session[key].root(); root.control != nil {
+			root.control.leave(key)
+			sendControl(root)
+		}
+		context.sendPresence(PRESENCE_LEAVE, key)
+
+		if s := daemon.session[key]; s.status != CONN_S_CLOSED &&
+			s.root().status != CONN_S_CLOSED {
+			grace := daemon.options.ReconnectGrace
+			resume := daemon.options.EnableReconnect && grace > 0 &&
+				s.resumeNonce != ""
+			if resume {
+				// the client may resume with its token until then
+				s.resumeUntil = time.Now().Unix() + int64(grace)
+			}
+			if s.persistent() {
+				// keep the pty running until someone reconnects
+				s.status = CONN_S_DETACHED
+				glog.V(2).Infof("connection detached:%s", key)
+				daemon.audit.log(AUDIT_DETACH, s)
+				return
+			}
+			if resume {
+				// cleanDetached releases the session if it does not
+				s.status = CONN_S_DETACHED
+				glog.V(2).Infof("connection detached:%s, resumable for %ds", key, grace)
+				daemon.audit.log(AUDIT_DETACH, s)
 				return
 			}
 		}
-	}()
-
+		context.release(key)
+	}
 }
 
-func (context *clientContext) close(key ConnKey) {
-	if conn, ok := (*context.connections)[key]; ok {
-		conn.conn.Close()
-		daemon.session[key].status = CONN_S_CLOSED
-		delete(*context.connections, key)
+// closeConn closes the connection of key if it is still wc, and not the
+// one of a client which resumed since
+func (context *clientContext) closeConn(key ConnKey, wc *webConn) {
+	if (*context.connections)[key] == wc {
+		context.close(key)
+	}
+}
 
-		if daemon.session[key].linkTo != nil {
-			n := atomic.AddInt32(&daemon.session[key].linkTo.linkNb, -1)
-			glog.V(2).Infof("linkNb:%d should be:%d", n,
-				len(*daemon.session[key].linkTo.context.connections))
-			if n == 0 {
-				delete(daemon.session, daemon.session[key].linkTo.key)
-			}
+// release closes the session of key, whose connection is closed
+func (context *clientContext) release(key ConnKey) {
+	daemon.session[key].status = CONN_S_CLOSED
+	daemon.audit.log(AUDIT_CLOSE, daemon.session[key])
+
+	if daemon.session[key].linkTo != nil {
+		n := atomic.AddInt32(&daemon.session[key].linkTo.linkNb, -1)
+		glog.V(2).Infof("linkNb:%d should be:%d", n,
+			len(*daemon.session[key].linkTo.context.connections))
+		if n == 0 {
+			delete(daemon.session, daemon.session[key].linkTo.key)
 		}
+	}
+
+	n := atomic.AddInt32(&daemon.session[key].linkNb, -1)
+	if daemon.session[key].linkNb == 0 {
+		delete(daemon.session, key)
+	}
+	glog.V(2).Infof("connection closed:%s, linkNb:%d", key, n)
+}
+
+// terminate signals the command, the pty is released once it exits
+func (context *clientContext) terminate() error {
+	if context.command == nil || context.command.Process == nil {
+		return nil
+	}
+	return context.command.Process.Signal(
+		syscall.Signal(daemon.options.CloseSignal))
+}
 
-		n := atomic.AddInt32(&daemon.session[key].linkNb, -1)
-		if daemon.session[key].linkNb == 0 {
-			delete(daemon.session, key)
+// orphaned reports whether the pty should be released because no client
+// is left, persistent sessions keep running without viewers and others
+// while a client may resume
+func (context *clientContext) orphaned() bool {
+	if len(*context.connections) > 0 || context.session.persistent() {
+		return false
+	}
+	root := context.session.root()
+	for _, s := range daemon.session {
+		if s.root() == root && s.status == CONN_S_DETACHED {
+			return false
 		}
-		glog.V(2).Infof("connection closed:%s, linkNb:%d", key, n)
 	}
+	return true
 }
 
 func (context *clientContext) record(data []byte) {
 	if r := context.session.recorder; r != nil {
 		if _, err := r.Write(data); err != nil {
-			glog.Errorf(err.Error())
+			// the frames written so far stay playable
+			glog.Errorf("recording %s stopped: %v", r.FileName, err)
 			r.Close()
-			r = nil
+			context.session.recorder = nil
+		} else {
+			atomic.AddUint64(&metrics.recBytes, uint64(len(data)))
+		}
+	}
+}
+
+// redactInput masks the input of the participant s with the redact
+// rules of the session. A secret may be typed a key at a time, so the
+// input is held back until a line is entered.
+func (context *clientContext) redactInput(s *session, data []byte) []byte {
+	rules := context.session.redact
+	if rules == nil || s == nil {
+		return data
+	}
+	if s.inputRedact == nil {
+		s.inputRedact = &redactor{rules: rules}
+	}
+	out := s.inputRedact.write(data)
+	if bytes.ContainsAny(data, "\r\n") {
+		out = append(out, s.inputRedact.flush()...)
+	}
+	return out
+}
+
+// logInput keeps client input in the audit log, and in the recording if
+// the session asked for it, with the user and session of the
+// participant s who typed it. Both get it masked by the redact rules.
+func (context *clientContext) logInput(s *session, data []byte) {
+	r := context.session.recorder
+	if !daemon.audit.logsInput() && (r == nil || !context.session.options.RecInput) {
+		return
+	}
+	if data = context.redactInput(s, data); len(data) == 0 {
+		return
+	}
+	daemon.audit.logInput(s, data)
+	if r == nil || !context.session.options.RecInput {
+		return
+	}
+	in := &rec.ArgUserInput{Data: string(data)}
+	if s != nil {
+		in.Name, in.Addr = s.key.Name, s.key.Addr
+		if s.user != nil {
+			in.User = s.user.Name
 		}
 	}
+	if err := r.WriteInput(in); err != nil {
+		glog.Errorf("recording %s input: %v", r.FileName, err)
+	}
 }
 
 func (context *clientContext) processSend() {
@@ -137,6 +266,16 @@ func (context *clientContext) processSend() {
 		glog.Errorln(err.Error())
 		return
 	}
+	// nothing was read from the pty yet
+	if err := context.sendResume(0); err != nil {
+		glog.Errorln(err.Error())
+		return
+	}
+
+	if r := context.session.redact; r != nil {
+		context.processSendRedacted(r)
+		return
+	}
 
 	buf := make([]byte, 1024)
 
@@ -147,36 +286,171 @@ func (context *clientContext) processSend() {
 			return
 		}
 		context.record(append([]byte{rec.Output}, buf[:size]...))
-		safeMessage := base64.StdEncoding.EncodeToString([]byte(buf[:size]))
-		if errs := context.write(append([]byte{rec.Output},
-			[]byte(safeMessage)...)); len(errs) > 0 {
-			for _, e := range errs {
-				glog.Errorln(e.err.Error())
-				context.close(e.key)
+		if !context.sendOutput(buf[:size]) {
+			return
+		}
+	}
+}
+
+// processSendRedacted masks the output before it is recorded, and
+// before it is sent if the rules are live. A match may be split between
+// two reads, so output is held back until more is read or the pty is
+// quiet for REDACT_FLUSH.
+func (context *clientContext) processSendRedacted(rules *redactRules) {
+	reads := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(reads)
+		for {
+			buf := make([]byte, 1024)
+			size, err := context.pty.Read(buf)
+			if err != nil {
+				return
 			}
-			if len(*context.connections) == 0 {
+			select {
+			case reads <- buf[:size]:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	r := &redactor{rules: rules}
+	emit := func(data []byte) bool {
+		if len(data) == 0 {
+			return true
+		}
+		context.record(append([]byte{rec.Output}, data...))
+		return !rules.live || context.sendOutput(data)
+	}
+	var flush <-chan time.Time
+	for {
+		select {
+		case data, ok := <-reads:
+			if !ok {
+				emit(r.flush())
+				glog.Errorf("Command exited for: %s", context.request.RemoteAddr)
+				return
+			}
+			if !rules.live && !context.sendOutput(data) {
+				return
+			}
+			if !emit(r.write(data)) {
+				return
+			}
+			flush = nil
+			if len(r.pending) > 0 {
+				flush = time.After(REDACT_FLUSH)
+			}
+		case <-flush:
+			flush = nil
+			if !emit(r.flush()) {
 				return
 			}
 		}
 	}
 }
 
-func (wc *webConn) write(data []byte) error {
-	wc.Lock()
-	defer wc.Unlock()
-	return wc.conn.WriteMessage(websocket.TextMessage, data)
+// sendOutput sends pty output to the clients and drops the ones which
+// fail, false once none is left
+func (context *clientContext) sendOutput(data []byte) bool {
+	if errs := context.writeOutput(data); len(errs) > 0 {
+		for _, e := range errs {
+			glog.Errorln(e.err.Error())
+			context.close(e.key)
+		}
+		if context.orphaned() {
+			return false
+		}
+	}
+	return true
 }
 
 func (context *clientContext) write(data []byte) []connErr {
+	return context.writeEach(func(wc *webConn) error {
+		return wc.write(data)
+	})
+}
+
+// writeEach calls send for every connection, and counts what was sent
+// in the client's session
+func (context *clientContext) writeEach(send func(wc *webConn) error) []connErr {
 	var errs []connErr
 	for key, wc := range *context.connections {
-		if err := wc.write(data); err != nil {
+		if err := send(wc); err != nil {
 			errs = append(errs, connErr{key: key, err: err})
+		} else if s, ok := daemon.session[key]; ok {
+			atomic.AddUint64(&s.bytesOut, atomic.SwapUint64(&wc.sent, 0))
 		}
 	}
 	return errs
 }
 
+func outputMessage(data []byte) []byte {
+	safeMessage := base64.StdEncoding.EncodeToString(data)
+	return append([]byte{rec.Output}, []byte(safeMessage)...)
+}
+
+func binaryOutputMessage(data []byte) []byte {
+	return append([]byte{rec.Output}, data...)
+}
+
+func playStateMessage(state *rec.PlayState) []byte {
+	buf, _ := json.Marshal(state)
+	return append([]byte{rec.SetPlayState}, buf...)
+}
+
+// writeOutput broadcasts pty output and keeps it in the session history,
+// which redraws the screen of the clients too slow to get all of it
+func (context *clientContext) writeOutput(data []byte) []connErr {
+	// the connections queue it, the caller reuses its buffer
+	data = append([]byte(nil), data...)
+	var snapshot func() ([]byte, uint64)
+	if h := context.history; h != nil {
+		h.Lock()
+		defer h.Unlock()
+		h.write(data)
+		snapshot = func() ([]byte, uint64) {
+			return h.bytes(), h.total
+		}
+	}
+	return context.writeEach(func(wc *webConn) error {
+		return wc.writeOutput(data, snapshot)
+	})
+}
+
+// joinConnection replays the session history to a joining client and
+// adds it to the broadcast list, with no output lost or sent twice. A
+// client which resumes gets the output after from, or the screen if
+// that is no longer in the history.
+func (context *clientContext) joinConnection(from int64) error {
+	var offset uint64
+	if h := context.history; h != nil {
+		h.Lock()
+		defer h.Unlock()
+		data := h.bytes()
+		if from != REPLAY_ALL {
+			if missed, ok := h.since(uint64(from)); ok {
+				data = missed
+			} else {
+				data = append([]byte(SCREEN_RESET), data...)
+			}
+		}
+		if len(data) > 0 {
+			if err := context.connection.writeOutput(data, nil); err != nil {
+				return err
+			}
+		}
+		offset = h.total
+	}
+	if err := context.sendResume(offset); err != nil {
+		return err
+	}
+	(*context.connections)[context.session.key] = context.connection
+	return nil
+}
+
 func (context *clientContext) sendInitialize() error {
 	hostname, _ := os.Hostname()
 	titleVars := ContextVars{
@@ -185,6 +459,9 @@ func (context *clientContext) sendInitialize() error {
 		Hostname:   hostname,
 		RemoteAddr: context.request.RemoteAddr,
 	}
+	if u := context.session.user; u != nil {
+		titleVars.User = u.Name
+	}
 
 	titleBuffer := new(bytes.Buffer)
 	if err := daemon.titleTemplate.Execute(titleBuffer, titleVars); err != nil {
@@ -220,7 +497,21 @@ func (context *clientContext) sendInitialize() error {
 			return err
 		}
 	}
-	return nil
+	if player, ok := context.pty.(*rec.Player); ok {
+		if err := context.connection.write(
+			playStateMessage(player.State())); err != nil {
+			return err
+		}
+	}
+	if c := context.session.root().control; c != nil {
+		c.Lock()
+		msg := controlStateMessage(c.state(context.session))
+		c.Unlock()
+		if err := context.connection.write(msg); err != nil {
+			return err
+		}
+	}
+	return context.sendChatLog()
 }
 
 func (context *clientContext) processReceive() {
@@ -231,10 +522,14 @@ func (context *clientContext) processReceive() {
 		if rx, ok = <-context.connRx; !ok {
 			return
 		}
+		if rx.notice != "" {
+			context.notice(rx.notice)
+			continue
+		}
 		if rx.err != nil {
 			glog.Errorln(rx.err.Error())
-			context.close(rx.key)
-			if len(*context.connections) == 0 {
+			context.closeConn(rx.key, rx.conn)
+			if context.orphaned() {
 				return
 			} else {
 				continue
@@ -246,28 +541,46 @@ func (context *clientContext) processReceive() {
 			return
 		}
 
+		if s, ok := daemon.session[rx.key]; ok {
+			atomic.AddUint64(&s.bytesIn, uint64(len(rx.p)))
+		}
+
 		switch rx.p[0] {
 		case rec.Input:
-			if !daemon.session[rx.key].options.PermitWrite {
+			if !daemon.session[rx.key].writable() {
 				if len(rx.p) == 2 && (rx.p[1] == 3 || rx.p[1] == 4) {
 					//close conn by ctrl-c/ctrl-d
 					daemon.session[rx.key].context.close(rx.key)
 				}
 				break
 			}
+			if !daemon.session[rx.key].hasKeyboard() {
+				// someone else holds the keyboard
+				break
+			}
 
+			atomic.StoreInt64(&context.session.lastInput, time.Now().UnixNano())
+			context.logInput(daemon.session[rx.key], rx.p[1:])
 			_, err = context.pty.Write(rx.p[1:])
 			if err != nil {
 				return
 			}
 
+		case rec.ControlWrite:
+			processControl(daemon.session[rx.key], rx.p[1:])
+
+		case rec.Chat:
+			if !context.processChat(daemon.session[rx.key], rx.p[1:]) {
+				return
+			}
+
 		case rec.Ping:
 			if errs := context.write([]byte{rec.Pong}); len(errs) > 0 {
 				for _, e := range errs {
 					glog.Errorln(e.err.Error())
 					context.close(e.key)
 				}
-				if len(*context.connections) == 0 {
+				if context.orphaned() {
 					return
 				}
 			}
@@ -298,6 +611,32 @@ func (context *clientContext) processReceive() {
 			)
 			context.record(rx.p)
 
+		case rec.ControlPlayer:
+			// viewers who joined share the owner's pty, the player
+			player, ok := context.pty.(*rec.Player)
+			if !ok {
+				break
+			}
+			var args rec.PlayControl
+			if err = json.Unmarshal(rx.p[1:], &args); err != nil {
+				glog.Errorln("Malformed remote command")
+				return
+			}
+			state, err := player.Control(&args)
+			if err != nil {
+				glog.Errorf("play %s: %v", player.FileName, err)
+				break
+			}
+			if errs := context.write(playStateMessage(state)); len(errs) > 0 {
+				for _, e := range errs {
+					glog.Errorln(e.err.Error())
+					context.close(e.key)
+				}
+				if context.orphaned() {
+					return
+				}
+			}
+
 		default:
 			glog.Errorln("Unknown message type")
 			return
@@ -0,0 +1,129 @@
+package tty
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yubo/gotty/rec"
+)
+
+// testServer upgrades to webConns made with options, dial connects a
+// client offering protocols
+func testServer(t *testing.T, options *Options) (dial func(
+	protocols ...string) (*websocket.Conn, *http.Response, *webConn),
+	closeServer func()) {
+	daemon = &Daemon{options: options, session: map[ConnKey]*session{}}
+	upgrader := &websocket.Upgrader{
+		Subprotocols:      []string{WS_PROTOCOL_BINARY, WS_PROTOCOL},
+		EnableCompression: options.WsCompression,
+	}
+	conns := make(chan *webConn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			close(conns)
+			return
+		}
+		conns <- newWebConn(conn)
+	}))
+
+	dial = func(protocols ...string) (*websocket.Conn, *http.Response, *webConn) {
+		d := &websocket.Dialer{Subprotocols: protocols,
+			EnableCompression: true}
+		c, resp, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc, ok := <-conns
+		if !ok {
+			t.FailNow()
+		}
+		return c, resp, wc
+	}
+	return dial, srv.Close
+}
+
+func TestBinaryProtocol(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{})
+	defer closeServer()
+
+	bin, _, binConn := dial(WS_PROTOCOL_BINARY, WS_PROTOCOL)
+	defer bin.Close()
+	legacy, _, legacyConn := dial(WS_PROTOCOL)
+	defer legacy.Close()
+	if !binConn.binary || legacyConn.binary {
+		t.Fatal("protocol not negotiated")
+	}
+
+	m := map[ConnKey]*webConn{
+		{Name: "bin"}: binConn, {Name: "legacy"}: legacyConn,
+	}
+	context := &clientContext{connections: &m}
+	if errs := context.writeOutput([]byte("\xffls\r\n")); len(errs) > 0 {
+		t.Fatal(errs[0].err)
+	}
+
+	typ, data, err := bin.ReadMessage()
+	if err != nil || typ != websocket.BinaryMessage || string(data) != "0\xffls\r\n" {
+		t.Fatalf("binary client got %d %q %v", typ, data, err)
+	}
+	typ, data, err = legacy.ReadMessage()
+	if err != nil || typ != websocket.TextMessage || string(data) != "0/2xzDQo=" {
+		t.Fatalf("legacy client got %d %q %v", typ, data, err)
+	}
+}
+
+func TestCoalesceOutput(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{WsCompression: true,
+		WsCompressionLevel: 1, OutputCoalesce: 20, OutputCoalesceMax: 8})
+	defer closeServer()
+	c, _, wc := dial(WS_PROTOCOL_BINARY)
+	defer c.Close()
+
+	read := func(want string) {
+		t.Helper()
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := c.ReadMessage()
+		if err != nil || string(data) != want {
+			t.Fatalf("got %q %v, want %q", data, err, want)
+		}
+	}
+
+	// reads within the window make one frame
+	wc.writeOutput([]byte("ab"), nil)
+	wc.writeOutput([]byte("cd"), nil)
+	read("0abcd")
+
+	// a full frame is sent without waiting
+	start := time.Now()
+	wc.writeOutput([]byte("0123456789"), nil)
+	read("00123456789")
+	if time.Since(start) > 15*time.Millisecond {
+		t.Fatal("full frame held back")
+	}
+
+	// other messages do not overtake pending output
+	wc.writeOutput([]byte("ef"), nil)
+	wc.write([]byte{rec.Pong})
+	read("0ef")
+	read(string(rec.Pong))
+}
+
+func TestCompression(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		dial, closeServer := testServer(t, &Options{WsCompression: enable,
+			WsCompressionLevel: 1})
+		c, resp, _ := dial(WS_PROTOCOL_BINARY)
+		ext := resp.Header.Get("Sec-Websocket-Extensions")
+		if strings.Contains(ext, "permessage-deflate") != enable {
+			t.Errorf("compression %v, negotiated %q", enable, ext)
+		}
+		c.Close()
+		closeServer()
+	}
+}
@@ -1,8 +1,13 @@
 package tty
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -11,6 +16,7 @@ import (
 	"github.com/yubo/gotool/flags"
 	"github.com/yubo/gotty/gottyclient"
 	"github.com/yubo/gotty/rec"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 func init() {
@@ -48,13 +54,34 @@ func init() {
 		"allow access nets, e.g. 127.0.0.1,192.168.0.0/24")
 	cmd.BoolVar(&CmdOpt.Rec, "rec",
 		DefaultCmdOptions.Rec, "record tty and save")
+	cmd.StringVar(&CmdOpt.RecFormat, "rec-format", "",
+		"recording format, "+rec.FORMAT_GOB+"/"+rec.FORMAT_ASCIICAST+
+			"(default rec_format)")
+	cmd.BoolVar(&CmdOpt.RecInput, "rec-input",
+		DefaultCmdOptions.RecInput,
+		"also record what clients type and who typed it")
+	cmd.BoolVar(&CmdOpt.Persist, "persist",
+		DefaultCmdOptions.Persist,
+		"Keep the TTY running when all clients disconnect, reconnect to it later")
+	cmd.BoolVar(&CmdOpt.Handoff, "handoff", false,
+		"Let one client type at a time, the keyboard is passed on with 'control'")
+	cmd.Var(&CmdOpt.Redact, "redact",
+		"mask what REGEXP matches in the recording, may be repeated")
+	cmd.BoolVar(&CmdOpt.NoRedact, "no-redact", false,
+		"don't apply the redact rules of the config")
+	cmd.BoolVar(&CmdOpt.RedactLive, "redact-live", false,
+		"also mask the output the clients see(default redact_live)")
+	cmd.IntVar(&CmdOpt.IdleTimeout, "idle-timeout", 0,
+		"seconds without input before the TTY is closed, -1 never(default idle_timeout)")
+	cmd.IntVar(&CmdOpt.MaxLifetime, "max-lifetime", 0,
+		"seconds before the TTY is closed, -1 never(default max_lifetime)")
 
 	// ps
 	cmd = flags.NewCommand("ps", "List session",
 		ps_handle, flag.ExitOnError)
 	cmd.BoolVar(&CmdOpt.All, "a", DefaultCmdOptions.All,
 		"Show all session(default show just "+
-			CONN_S_CONNECTED+"/"+CONN_S_WAITING+")")
+			CONN_S_CONNECTED+"/"+CONN_S_WAITING+"/"+CONN_S_DETACHED+")")
 
 	// attach
 	cmd = flags.NewCommand("attach", "Attach to a seesion",
@@ -69,6 +96,18 @@ func init() {
 	cmd.StringVar(&CmdOpt.SAddr, "saddr",
 		DefaultCmdOptions.Addr, "attach to the session addr")
 
+	// token
+	cmd = flags.NewCommand("token", "Create a one-time url for a session",
+		token_handle, flag.ExitOnError)
+	cmd.BoolVar(&CmdOpt.PermitWrite, "w",
+		DefaultCmdOptions.PermitWrite,
+		"Permit the url's client to write to the TTY if it is writable")
+	cmd.StringVar(&CmdOpt.Name, "name", "", "session name")
+	cmd.StringVar(&CmdOpt.Addr, "addr", DefaultCmdOptions.Addr,
+		"session addr")
+	cmd.IntVar(&CmdOpt.TTL, "ttl", 0,
+		"seconds until the url expires(default token_ttl)")
+
 	// close
 	cmd = flags.NewCommand("close", "Close a pty/session",
 		close_handle, flag.ExitOnError)
@@ -78,6 +117,18 @@ func init() {
 	cmd.BoolVar(&CmdOpt.All, "a", false,
 		"Close all session use the same pty(default close just a seesion)")
 
+	// control
+	cmd = flags.NewCommand("control",
+		"Show, grant or revoke the keyboard of a session started with -handoff",
+		control_handle, flag.ExitOnError)
+	cmd.StringVar(&CmdOpt.Name, "name", "", "session name")
+	cmd.StringVar(&CmdOpt.Addr, "addr", DefaultCmdOptions.Addr,
+		"session addr")
+	cmd.StringVar(&CmdOpt.Action, "action", "",
+		CONTROL_GRANT+"/"+CONTROL_REVOKE+"(default show who holds the keyboard)")
+	cmd.StringVar(&CmdOpt.ControlTo, "to", "",
+		"the participant to grant the keyboard to, NAME or NAME/ADDR")
+
 	// play
 	cmd = flags.NewCommand("play",
 		"replay recorded file in a webtty",
@@ -98,15 +149,37 @@ func init() {
 	cmd.Int64Var(&CmdOpt.MaxWait, "max-wait",
 		DefaultCmdOptions.MaxWait,
 		"Reduce recorded terminal inactivity to max <sec> second")
+	cmd.Float64Var(&CmdOpt.Start, "start", 0,
+		"start at <sec> second of the recording, e.g. the time of a 'rec search' hit")
+	cmd.StringVar(&CmdOpt.ShowInput, "input", "",
+		"show what these users or sessions typed in the title, comma separated, * for all")
 
 	cmd = flags.NewCommand("convert",
-		"convert seesion id to asciicast format(json)", convert_handle, flag.ExitOnError)
+		"convert seesion id to asciicast format(json), gif, svg, text or html",
+		convert_handle, flag.ExitOnError)
 	cmd.StringVar(&CmdOpt.SName, "i", "", "convert tty, input filename or seesion id")
-	cmd.StringVar(&CmdOpt.Name, "o", "out.json", "convert tty, output filename")
+	cmd.StringVar(&CmdOpt.Name, "o", "out.json",
+		"convert tty, output filename (default out.FORMAT)")
+	cmd.StringVar(&CmdOpt.Export, "f", rec.EXPORT_JSON,
+		"output format, json/gif/svg/text/html")
+	cmd.StringVar(&CmdOpt.ShowInput, "input", "",
+		"add what these users or sessions typed to a text/html transcript, comma separated, * for all")
 	cmd.Int64Var(&CmdOpt.MaxWait, "max-wait",
 		DefaultCmdOptions.MaxWait,
 		"Reduce recorded terminal inactivity to max <sec> second")
 
+	// rec, its subcommands parse their own flags
+	cmd = flags.NewCommand("rec",
+		"List and check recordings, run 'rec help' for the subcommands",
+		rec_handle, flag.ExitOnError)
+
+	// passwd
+	cmd = flags.NewCommand("passwd",
+		"Print a user_file entry for USER, the password is read from stdin",
+		passwd_handle, flag.ExitOnError)
+	cmd.StringVar(&CmdOpt.Role, "role", ROLE_VIEWER,
+		"user role, "+ROLE_VIEWER+"/"+ROLE_OPERATOR+"/"+ROLE_ADMIN)
+
 	// version
 	cmd = flags.NewCommand("version",
 		"Show the gotty version information", version_handle, flag.ExitOnError)
@@ -156,6 +229,16 @@ func exec_handle(arg interface{}) {
 		fmt.Fprintf(os.Stdout, "exec successful, name:\"%s\" addr:\"%s\"\n",
 			info.Key.Name, info.Key.Addr)
 	}
+	fmt.Fprintf(os.Stdout, "url: %s\n", tokenURL(&info))
+}
+
+// tokenURL is the path of the web page which connects with info's token
+func tokenURL(info *Session_info) string {
+	v := url.Values{}
+	v.Set("name", info.Key.Name)
+	v.Set("addr", info.Key.Addr)
+	v.Set("token", info.Token)
+	return "/?" + v.Encode()
 }
 
 func ps_handle(arg interface{}) {
@@ -185,14 +268,25 @@ func ps_handle(arg interface{}) {
 }
 
 func attach_handle(arg interface{}) {
-	var key ConnKey
+	var info Session_info
 	opt := arg.(*CallOptions)
-	if err := Call("Cmd.Attach", opt, &key); err != nil {
+	if err := Call("Cmd.Attach", opt, &info); err != nil {
 		fmt.Fprintf(os.Stderr, "attach %v \n", err)
 		os.Exit(1)
 	}
 	fmt.Fprintf(os.Stdout, "attach successful, name:\"%s\" addr:\"%s\"\n",
-		key.Name, key.Addr)
+		info.Key.Name, info.Key.Addr)
+	fmt.Fprintf(os.Stdout, "url: %s\n", tokenURL(&info))
+}
+
+func token_handle(arg interface{}) {
+	var info Session_info
+	opt := arg.(*CallOptions)
+	if err := Call("Cmd.Token", opt, &info); err != nil {
+		fmt.Fprintf(os.Stderr, "token %v \n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "url: %s\n", tokenURL(&info))
 }
 
 func close_handle(arg interface{}) {
@@ -205,26 +299,402 @@ func close_handle(arg interface{}) {
 	}
 }
 
-func convert_handle(arg interface{}) {
+func control_handle(arg interface{}) {
+	var state ControlState
 	opt := arg.(*CallOptions)
-	filename := expandHomeDir(opt.Opt.SName)
+	if err := Call("Cmd.Control", opt, &state); err != nil {
+		fmt.Fprintf(os.Stderr, "control %v\n", err)
+		os.Exit(1)
+	}
+	name := func(p participant) string {
+		s := p.Name + "/" + p.Addr
+		if p.User != "" {
+			s += " (" + p.User + ")"
+		}
+		return s
+	}
+	fmt.Fprintf(os.Stdout, "keyboard: %s\n", name(state.Holder))
+	for _, p := range state.Requests {
+		fmt.Fprintf(os.Stdout, "requested by: %s\n", name(p))
+	}
+}
+
+// recFileName finds a recording by file name or by its id in rec_file_dir
+func recFileName(name string) string {
+	filename := expandHomeDir(name)
 
 	_, err := os.Stat(filename)
 	if os.IsNotExist(err) {
-		filename = expandHomeDir(GlobalOpt.RecFileDir) +
-			"/" + opt.Opt.SName
+		filename = expandHomeDir(GlobalOpt.RecFileDir) + "/" + name
 		_, err := os.Stat(filename)
 		if os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "file/RecID(%s) is not exsit\n",
-				opt.Opt.SName)
+			fmt.Fprintf(os.Stderr, "file/RecID(%s) is not exsit\n", name)
 			os.Exit(1)
 		}
 	}
-	rec.Convert(filename, opt.Opt.Name, opt.Opt.MaxWait)
+	return filename
+}
+
+func convert_handle(arg interface{}) {
+	opt := arg.(*CallOptions)
+	if err := loadRecKeys(); err != nil {
+		osExit(err, 1)
+	}
+	filename := recFileName(opt.Opt.SName)
+	out := opt.Opt.Name
+	if out == "out.json" && opt.Opt.Export != rec.EXPORT_JSON {
+		ext := opt.Opt.Export
+		if ext == rec.EXPORT_TEXT {
+			ext = "txt"
+		}
+		out = "out." + ext
+	}
+	if err := rec.Export(filename, out, opt.Opt.Export,
+		opt.Opt.MaxWait, opt.Opt.ShowInput); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+		os.Exit(1)
+	}
 
 	fmt.Fprintf(os.Stdout, "%s\n", Version)
 }
 
+const recUsage = `Usage: gotty rec COMMAND [ARG...]
+
+Commands:
+    search [-limit N] [-user U] [-name N] [-json] QUERY...
+                                find the output lines which have every word
+                                of QUERY, play -start TIME jumps to a hit
+    index ID|FILE...            extract and index the text of recordings made
+                                before gotty indexed them
+    ls [-name N] [-addr A] [-user U] [-command C] [-since DUR] [-until DUR]
+       [-min-duration SEC] [-json]
+                                list the recordings of rec_file_dir, newest
+                                first, started within DUR (e.g. 24h) ago
+    import [-f FORMAT] [-timing FILE] [-cols N] [-rows N] [-name NAME] FILE...
+                                copy asciicast v1/v2, ttyrec or script (with
+                                its timing file, default FILE.timing)
+                                recordings to rec_file_dir, FORMAT is
+                                detected unless set
+    rekey [-force] [ID|FILE...]  encrypt recordings (default all of
+                                rec_file_dir) with the first key of
+                                rec_key_file, -force includes unfinished ones
+    verify ID|FILE...           check recordings for truncation or corruption
+    repair [-o OUT] ID|FILE     copy the readable frames of a recording to OUT
+                                (default FILE.repaired)
+`
+
+func rec_handle(arg interface{}) {
+	opt := arg.(*CallOptions)
+	if len(opt.Args) == 0 {
+		fmt.Fprint(os.Stderr, recUsage)
+		os.Exit(1)
+	}
+
+	if err := loadRecKeys(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	switch opt.Args[0] {
+	case "ls":
+		os.Exit(recLs(opt.Args[1:]))
+	case "search":
+		os.Exit(recSearch(opt.Args[1:]))
+	case "index":
+		os.Exit(recIndex(opt.Args[1:]))
+	case "import":
+		os.Exit(recImport(opt.Args[1:]))
+	case "rekey":
+		os.Exit(recRekey(opt.Args[1:]))
+	case "verify":
+		os.Exit(recVerify(opt.Args[1:]))
+	case "repair":
+		os.Exit(recRepair(opt.Args[1:]))
+	case "help":
+		fmt.Fprint(os.Stdout, recUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown rec command %q\n\n%s",
+			opt.Args[0], recUsage)
+		os.Exit(1)
+	}
+}
+
+func recLs(args []string) int {
+	var since, until time.Duration
+	var asJson bool
+	f := &rec.MetaFilter{}
+
+	fs := flag.NewFlagSet("rec ls", flag.ExitOnError)
+	fs.StringVar(&f.Name, "name", "", "session name contains")
+	fs.StringVar(&f.Addr, "addr", "", "session addr contains")
+	fs.StringVar(&f.User, "user", "", "user contains")
+	fs.StringVar(&f.Command, "command", "", "command contains")
+	fs.DurationVar(&since, "since", 0, "started less than this ago")
+	fs.DurationVar(&until, "until", 0, "started more than this ago")
+	fs.Float64Var(&f.MinDuration, "min-duration", 0, "at least this long(second)")
+	fs.BoolVar(&asJson, "json", false, "print json lines")
+	fs.Parse(args)
+
+	now := time.Now()
+	if since > 0 {
+		f.Since = now.Add(-since).Unix()
+	}
+	if until > 0 {
+		f.Until = now.Add(-until).Unix()
+	}
+
+	metas, err := rec.ListMeta(expandHomeDir(GlobalOpt.RecFileDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if !asJson {
+		fmt.Fprintf(os.Stdout, "%-20s %-9s %-16s %9s %10s %9s %-12s %-15s %s\n",
+			"ID", "Format", "Start", "Duration", "Size", "Term",
+			"User", "Name", "Command")
+	}
+	for _, m := range metas {
+		if !f.Match(m) {
+			continue
+		}
+		if asJson {
+			buf, _ := json.Marshal(m)
+			fmt.Fprintf(os.Stdout, "%s\n", buf)
+			continue
+		}
+		duration := "-"
+		if m.End != 0 {
+			duration = fmt.Sprintf("%.1fs", m.Duration)
+		}
+		fmt.Fprintf(os.Stdout, "%-20s %-9s %-16s %9s %10d %9s %-12s %-15s %s\n",
+			m.Id, m.Format, time.Unix(m.Start, 0).Format("2006-01-02 15:04"),
+			duration, m.Size, fmt.Sprintf("%dx%d", m.Columns, m.Rows),
+			m.User, m.Name, m.Command)
+	}
+	return 0
+}
+
+func recSearch(args []string) int {
+	var limit int
+	var asJson bool
+	f := &rec.MetaFilter{}
+
+	fs := flag.NewFlagSet("rec search", flag.ExitOnError)
+	fs.IntVar(&limit, "limit", 100, "print at most N hits, 0 for all")
+	fs.StringVar(&f.User, "user", "", "user contains")
+	fs.StringVar(&f.Name, "name", "", "session name contains")
+	fs.BoolVar(&asJson, "json", false, "print json lines")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprint(os.Stderr, recUsage)
+		return 1
+	}
+
+	hits, err := rec.Search(expandHomeDir(GlobalOpt.RecFileDir),
+		strings.Join(fs.Args(), " "), f, limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, h := range hits {
+		if asJson {
+			buf, _ := json.Marshal(h)
+			fmt.Fprintf(os.Stdout, "%s\n", buf)
+		} else {
+			fmt.Fprintf(os.Stdout, "%-20s %9.3f %s\n", h.Id, h.Time, h.Line)
+		}
+	}
+	if len(hits) == 0 {
+		return 1
+	}
+	return 0
+}
+
+func recIndex(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, recUsage)
+		return 1
+	}
+
+	ret := 0
+	for _, name := range args {
+		if err := rec.BuildTextIndex(recFileName(name)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			ret = 1
+		}
+	}
+	return ret
+}
+
+func recImport(args []string) int {
+	opt := &rec.ImportOptions{}
+
+	fs := flag.NewFlagSet("rec import", flag.ExitOnError)
+	fs.StringVar(&opt.Format, "f", "", "format, "+rec.IMPORT_ASCIICAST+"/"+
+		rec.IMPORT_TTYREC+"/"+rec.IMPORT_SCRIPT+", detected if empty")
+	fs.StringVar(&opt.Timing, "timing", "", "timing file of a script typescript")
+	fs.IntVar(&opt.Columns, "cols", 80, "terminal columns if the recording has none")
+	fs.IntVar(&opt.Rows, "rows", 24, "terminal rows if the recording has none")
+	fs.StringVar(&opt.Name, "name", "", "session name, default the file name")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprint(os.Stderr, recUsage)
+		return 1
+	}
+
+	ret := 0
+	dir := expandHomeDir(GlobalOpt.RecFileDir)
+	for _, name := range fs.Args() {
+		m, err := rec.Import(name, dir, opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			ret = 1
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s %s\n", m.Id, name)
+	}
+	return ret
+}
+
+func recRekey(args []string) int {
+	var force bool
+
+	fs := flag.NewFlagSet("rec rekey", flag.ExitOnError)
+	fs.BoolVar(&force, "force", false,
+		"also rekey recordings which have not ended, e.g. of a crashed daemon")
+	fs.Parse(args)
+
+	if rec.CurrentKey() == "" {
+		fmt.Fprintf(os.Stderr, "rec_key_file is not set\n")
+		return 1
+	}
+
+	var files []string
+	for _, name := range fs.Args() {
+		files = append(files, recFileName(name))
+	}
+	if fs.NArg() == 0 {
+		dir := expandHomeDir(GlobalOpt.RecFileDir)
+		metas, err := rec.ListMeta(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, m := range metas {
+			files = append(files, dir+"/"+m.Id)
+		}
+	}
+
+	ret := 0
+	for _, filename := range files {
+		need, err := rec.NeedsRekey(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			ret = 1
+			continue
+		}
+		if !need {
+			continue
+		}
+		if m, err := rec.LoadMeta(filename); err == nil && m.End == 0 && !force {
+			fmt.Fprintf(os.Stderr, "%s: still recording, skipped\n", filename)
+			ret = 1
+			continue
+		}
+		if err := rec.Rekey(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			ret = 1
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", filename)
+	}
+	return ret
+}
+
+func recVerify(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, recUsage)
+		return 1
+	}
+
+	ret := 0
+	for _, name := range args {
+		v, err := rec.Verify(recFileName(name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			ret = 1
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s: %s %s\n", name, v.Format, v)
+		if !v.Ok() {
+			ret = 1
+		}
+	}
+	return ret
+}
+
+func recRepair(args []string) int {
+	var out string
+
+	fs := flag.NewFlagSet("rec repair", flag.ExitOnError)
+	fs.StringVar(&out, "o", "", "output file(default FILE.repaired)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, recUsage)
+		return 1
+	}
+
+	src := recFileName(fs.Arg(0))
+	if out == "" {
+		out = src + ".repaired"
+	}
+	v, err := rec.Repair(src, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repair %s: %v\n", src, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stdout, "%s: %s %s\nsalvaged %d frames to %s\n",
+		src, v.Format, v, v.Frames, out)
+	return 0
+}
+
+func passwd_handle(arg interface{}) {
+	var password []byte
+	var err error
+
+	opt := arg.(*CallOptions)
+	if len(opt.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: passwd [-role ROLE] USER\n")
+		os.Exit(1)
+	}
+	if _, ok := roleLevel[opt.Opt.Role]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown role %q\n", opt.Opt.Role)
+		os.Exit(1)
+	}
+
+	if terminal.IsTerminal(0) {
+		fmt.Fprintf(os.Stderr, "Password: ")
+		password, err = terminal.ReadPassword(0)
+		fmt.Fprintf(os.Stderr, "\n")
+	} else {
+		password, err = bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err == io.EOF && len(password) > 0 {
+			err = nil
+		}
+		password = bytes.TrimRight(password, "\r\n")
+	}
+	if err != nil {
+		osExit(err, 1)
+	}
+
+	hash, err := hashPassword(string(password))
+	if err != nil {
+		osExit(err, 1)
+	}
+	fmt.Fprintf(os.Stdout, "%s:%s:%s\n", opt.Args[0], hash, opt.Opt.Role)
+}
+
 func version_handle(arg interface{}) {
 	fmt.Fprintf(os.Stdout, "%s\n", Version)
 }
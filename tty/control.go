@@ -0,0 +1,232 @@
+package tty
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/yubo/gotty/rec"
+)
+
+// actions of a ControlWrite message and of `gotty control`
+const (
+	CONTROL_REQUEST = "request"
+	CONTROL_RELEASE = "release"
+	CONTROL_GRANT   = "grant"
+	CONTROL_REVOKE  = "revoke"
+)
+
+// controlMessage asks for or passes the keyboard, To is the participant
+// a grant goes to
+type controlMessage struct {
+	Action string      `json:"action"`
+	To     participant `json:"to"`
+}
+
+// participant is a client of a shared session, Write is whether it may
+// type
+type participant struct {
+	Name  string `json:"name"`
+	Addr  string `json:"addr"`
+	User  string `json:"user,omitempty"`
+	Write bool   `json:"write"`
+}
+
+// ControlState tells a participant who holds the keyboard
+type ControlState struct {
+	Creator  participant   `json:"creator"`
+	Holder   participant   `json:"holder"`
+	Requests []participant `json:"requests"`
+	// the participant the state is sent to, whether it may type when it
+	// holds the keyboard and whether it may grant and revoke
+	Self     participant `json:"self"`
+	Writable bool        `json:"writable"`
+	Manage   bool        `json:"manage"`
+}
+
+// writeControl lets one participant of a shared session type at a
+// time. The creator holds the keyboard until it is granted to someone
+// else, and gets it back when it is released or revoked or when the
+// holder leaves.
+type writeControl struct {
+	sync.Mutex
+	creator  ConnKey
+	holder   ConnKey
+	requests []ConnKey
+}
+
+func newWriteControl(creator ConnKey) *writeControl {
+	return &writeControl{creator: creator, holder: creator}
+}
+
+// root is the session which owns the pty
+func (s *session) root() *session {
+	if s.linkTo != nil {
+		return s.linkTo
+	}
+	return s
+}
+
+// hasKeyboard reports whether s may type now, every writable
+// participant may without handoff
+func (s *session) hasKeyboard() bool {
+	c := s.root().control
+	if c == nil {
+		return true
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.holder == s.key
+}
+
+func newParticipant(key ConnKey) participant {
+	p := participant{Name: key.Name, Addr: key.Addr}
+	if s, ok := daemon.session[key]; ok {
+		if s.user != nil {
+			p.User = s.user.Name
+		}
+		p.Write = s.writable()
+	}
+	return p
+}
+
+func (c *writeControl) request(key ConnKey) {
+	if c.holder == key {
+		return
+	}
+	for _, k := range c.requests {
+		if k == key {
+			return
+		}
+	}
+	c.requests = append(c.requests, key)
+}
+
+func (c *writeControl) dropRequest(key ConnKey) {
+	for i, k := range c.requests {
+		if k == key {
+			c.requests = append(c.requests[:i], c.requests[i+1:]...)
+			return
+		}
+	}
+}
+
+// manages reports whether actor may grant and revoke, nil is the
+// daemon's own client
+func (c *writeControl) manages(actor *session) bool {
+	return actor == nil || actor.key == c.holder || actor.key == c.creator ||
+		actor.user != nil && actor.user.can(ROLE_ADMIN)
+}
+
+// apply carries out m for actor, nil is the daemon's own client
+func (c *writeControl) apply(actor *session, m *controlMessage) error {
+	c.Lock()
+	defer c.Unlock()
+
+	switch m.Action {
+	case CONTROL_REQUEST:
+		if actor == nil || !actor.writable() {
+			return errors.New("the participant may not type")
+		}
+		c.request(actor.key)
+	case CONTROL_RELEASE:
+		if actor != nil && actor.key != c.holder {
+			return errors.New("the participant does not hold the keyboard")
+		}
+		c.holder = c.creator
+	case CONTROL_GRANT:
+		if !c.manages(actor) {
+			return errors.New("only the holder or the creator may grant")
+		}
+		key := ConnKey{Name: m.To.Name, Addr: m.To.Addr}
+		s, ok := daemon.session[key]
+		if !ok || s.root().control != c || s.status != CONN_S_CONNECTED {
+			return fmt.Errorf("%s is not a participant", key)
+		}
+		if !s.writable() {
+			return fmt.Errorf("%s may not type", key)
+		}
+		c.dropRequest(key)
+		c.holder = key
+	case CONTROL_REVOKE:
+		if !c.manages(actor) {
+			return errors.New("only the holder or the creator may revoke")
+		}
+		c.holder = c.creator
+	default:
+		return fmt.Errorf("unknown control action %q", m.Action)
+	}
+	return nil
+}
+
+// leave hands the keyboard back to the creator if key held it
+func (c *writeControl) leave(key ConnKey) {
+	c.Lock()
+	defer c.Unlock()
+	c.dropRequest(key)
+	if c.holder == key {
+		c.holder = c.creator
+	}
+}
+
+// state is what participant s is told, the caller holds the lock
+func (c *writeControl) state(s *session) *ControlState {
+	st := &ControlState{
+		Creator:  newParticipant(c.creator),
+		Holder:   newParticipant(c.holder),
+		Requests: []participant{},
+		Self:     newParticipant(s.key),
+		Writable: s.writable(),
+		Manage:   c.manages(s),
+	}
+	for _, k := range c.requests {
+		st.Requests = append(st.Requests, newParticipant(k))
+	}
+	return st
+}
+
+func controlStateMessage(st *ControlState) []byte {
+	buf, _ := json.Marshal(st)
+	return append([]byte{rec.SetWriteControl}, buf...)
+}
+
+// sendControl tells every participant of root who holds the keyboard,
+// connections which fail are closed by the next write of the output
+func sendControl(root *session) {
+	c := root.control
+	if c == nil || root.context.connections == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for key, wc := range *root.context.connections {
+		s, ok := daemon.session[key]
+		if !ok {
+			continue
+		}
+		if err := wc.write(controlStateMessage(c.state(s))); err != nil {
+			glog.V(2).Infof("control state to %s: %v", key, err)
+		}
+	}
+}
+
+// processControl applies a ControlWrite message from participant s
+func processControl(s *session, p []byte) {
+	var m controlMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		glog.Errorln("Malformed remote command")
+		return
+	}
+	root := s.root()
+	if root.control == nil {
+		return
+	}
+	if err := root.control.apply(s, &m); err != nil {
+		glog.V(2).Infof("control %s from %s: %v", m.Action, s.key, err)
+		return
+	}
+	daemon.audit.logControl(s, &m)
+	sendControl(root)
+}
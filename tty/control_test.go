@@ -0,0 +1,76 @@
+package tty
+
+import (
+	"testing"
+)
+
+func TestWriteControl(t *testing.T) {
+	owner := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"},
+		status: CONN_S_CONNECTED, options: &CmdOptions{PermitWrite: true}}
+	owner.control = newWriteControl(owner.key)
+	bob := &session{key: ConnKey{Name: "bob", Addr: "10.0.0.2"}, linkTo: owner,
+		status: CONN_S_CONNECTED, options: &CmdOptions{PermitWrite: true}}
+	eve := &session{key: ConnKey{Name: "eve", Addr: "10.0.0.3"}, linkTo: owner,
+		status: CONN_S_CONNECTED, options: &CmdOptions{}}
+	daemon = &Daemon{session: map[ConnKey]*session{
+		owner.key: owner, bob.key: bob, eve.key: eve,
+	}}
+	c := owner.control
+
+	if !owner.hasKeyboard() || bob.hasKeyboard() {
+		t.Fatal("the creator starts with the keyboard")
+	}
+	if err := c.apply(eve, &controlMessage{Action: CONTROL_REQUEST}); err == nil {
+		t.Fatal("read only participant asked for the keyboard")
+	}
+	if err := c.apply(bob, &controlMessage{Action: CONTROL_REQUEST}); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.state(owner); len(st.Requests) != 1 || st.Requests[0].Name != "bob" ||
+		!st.Manage {
+		t.Fatalf("state %+v", st)
+	}
+	grant := &controlMessage{Action: CONTROL_GRANT,
+		To: participant{Name: "bob", Addr: "10.0.0.2"}}
+	if err := c.apply(bob, grant); err == nil {
+		t.Fatal("granted by a participant without the keyboard")
+	}
+	if err := c.apply(owner, &controlMessage{Action: CONTROL_GRANT,
+		To: participant{Name: "eve", Addr: "10.0.0.3"}}); err == nil {
+		t.Fatal("granted to a read only participant")
+	}
+	if err := c.apply(owner, grant); err != nil {
+		t.Fatal(err)
+	}
+	if owner.hasKeyboard() || !bob.hasKeyboard() || len(c.requests) != 0 {
+		t.Fatal("keyboard not passed")
+	}
+
+	// the holder hands it back, or the creator takes it
+	if err := c.apply(bob, &controlMessage{Action: CONTROL_RELEASE}); err != nil ||
+		!owner.hasKeyboard() {
+		t.Fatalf("release %v", err)
+	}
+	c.apply(nil, grant)
+	if err := c.apply(owner, &controlMessage{Action: CONTROL_REVOKE}); err != nil ||
+		!owner.hasKeyboard() {
+		t.Fatalf("revoke %v", err)
+	}
+
+	// a holder who leaves gives it back
+	c.apply(nil, grant)
+	c.leave(bob.key)
+	if !owner.hasKeyboard() {
+		t.Fatal("keyboard kept by a participant who left")
+	}
+
+	if k, err := findParticipant(owner, "bob"); err != nil || k != bob.key {
+		t.Fatalf("find %v %v", k, err)
+	}
+	if k, _ := findParticipant(owner, "x/127.0.0.0/8"); k.Addr != "127.0.0.0/8" {
+		t.Fatalf("find %v", k)
+	}
+	if _, err := findParticipant(owner, "nobody"); err == nil {
+		t.Fatal("found nobody")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/yubo/gotty/rec"
 )
 
 var demoTpl *template.Template
@@ -18,7 +19,8 @@ var demoTpl *template.Template
 type demoIndex struct {
 	RemoteAddr string
 	Sessions   *Session_infos
-	Recs       []string
+	Recs       []*rec.Meta
+	RecFilter  *rec.MetaFilter
 }
 
 func Key2Str(args ...interface{}) string {
@@ -72,15 +74,19 @@ func demoHandler(w http.ResponseWriter, r *http.Request) {
 	sort.Sort(ss)
 	data.Sessions = &ss
 
-	//recs
-	if f, err := os.Open(GlobalOpt.RecFileDir); err == nil {
-		names, _ := f.Readdirnames(-1)
-		f.Close()
-		sort.Strings(names)
-		if len(names) > 10 {
-			data.Recs = names[:10]
-		} else {
-			data.Recs = names[:]
+	//recs, the ten newest which match the search form
+	data.RecFilter, _ = recFilter(r.URL.Query())
+	if data.RecFilter == nil {
+		data.RecFilter = &rec.MetaFilter{}
+	}
+	if metas, err := rec.ListMeta(expandHomeDir(GlobalOpt.RecFileDir)); err == nil {
+		for _, m := range metas {
+			if len(data.Recs) == 10 {
+				break
+			}
+			if data.RecFilter.Match(m) {
+				data.Recs = append(data.Recs, m)
+			}
 		}
 	}
 
@@ -110,6 +116,18 @@ func demoCmdHandler(w http.ResponseWriter, r *http.Request) {
 	opt.Opt.Addr = GlobalOpt.DemoAddr
 	opt.Args = strings.Fields(opt.Opt.Cmd)
 
+	role := ROLE_OPERATOR
+	if opt.Opt.Action == "delete" {
+		role = ROLE_ADMIN
+	}
+	if !allowed(r, role) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	if u := requestUser(r); u != nil {
+		opt.Opt.Creator = u.Name
+	}
+
 	if opt.Opt.Action == "exec" {
 		if err := Call("Cmd.Exec", opt, &info); err != nil {
 			glog.Errorf("exec %v \n", err)
@@ -130,7 +148,11 @@ func demoCmdHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else if opt.Opt.Action == "delete" {
-		if err := os.Remove(fmt.Sprintf("%s/%s", GlobalOpt.RecFileDir, opt.Opt.RecId)); err != nil {
+		if !validRecId(opt.Opt.RecId) {
+			http.Error(w, "invalid recid", http.StatusBadRequest)
+			return
+		}
+		if err := rec.Remove(recFilePath(opt.Opt.RecId)); err != nil {
 			glog.Errorf("delete %v \n", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -144,7 +166,10 @@ func demoCmdHandler(w http.ResponseWriter, r *http.Request) {
 
 func init() {
 	var err error
-	demoTpl, err = template.New("gotty demo").Funcs(template.FuncMap{"join": strings.Join}).Parse(`
+	demoTpl, err = template.New("gotty demo").Funcs(template.FuncMap{"join": strings.Join,
+		"recTime": func(t int64) string {
+			return time.Unix(t, 0).Format("2006-01-02 15:04")
+		}}).Parse(`
 <!doctype html>
 <html>
 <head>
@@ -241,10 +266,16 @@ func init() {
 		
 		
 		<h2> recorder </h2>
+		<form class="form-inline" method="get">
+			<input class="form-control" name="user" placeholder="user" value="{{.RecFilter.User}}">
+			<input class="form-control" name="name" placeholder="name" value="{{.RecFilter.Name}}">
+			<input class="form-control" name="command" placeholder="command" value="{{.RecFilter.Command}}">
+			<button class="btn btn-default" type="submit">search</button>
+		</form>
 		<div><table id="recs" class="table table-striped">
 			<thead><tr><th>RedId</th><th>speed</th><th>action</th></tr></thead>
 			<tbody> <tr>
-				<td><select class="form-contorl" id="recid"> {{range .Recs}} <option value="{{.}}">{{.}}</option> {{end}} </select></td>
+				<td><select class="form-contorl" id="recid"> {{range .Recs}} <option value="{{.Id}}">{{.Id}} {{.User}} {{.Name}} {{.Command}} {{recTime .Start}} {{printf "%.0fs" .Duration}}</option> {{end}} </select></td>
 				<td><select class="form-contorl" id="recSpeed"> <option value="1">1x</option><option value="2">2x</option><option falue="4">4x</option> </select></td>
 				<td>
 					<button class="btn btn-default" value="play" id="recPlay">play</button>
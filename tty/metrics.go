@@ -0,0 +1,181 @@
+package tty
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	AUTH_F_BASIC = "basic"
+	AUTH_F_TOKEN = "token"
+	AUTH_F_WS    = "websocket"
+	AUTH_F_OIDC  = "oidc"
+)
+
+// daemonMetrics are the counters which can't be derived from the
+// session table at scrape time
+type daemonMetrics struct {
+	recBytes     uint64
+	ipRejects    uint64
+	authFailures [4]uint64
+	// clients which lost output to a screen snapshot, or were
+	// disconnected, because their send queue was full
+	slowSnapshots uint64
+	slowCloses    uint64
+}
+
+var (
+	metrics         daemonMetrics
+	authFailSources = []string{AUTH_F_BASIC, AUTH_F_TOKEN, AUTH_F_WS, AUTH_F_OIDC}
+)
+
+func authFailed(source string) {
+	for i, s := range authFailSources {
+		if s == source {
+			atomic.AddUint64(&metrics.authFailures[i], 1)
+			return
+		}
+	}
+}
+
+// metricWriter renders the prometheus text exposition format
+type metricWriter struct {
+	buf bytes.Buffer
+}
+
+func (m *metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one value, labels are name, value pairs
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&m.buf, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetrics(m *metricWriter) {
+	type methodStatus struct{ method, status string }
+	var websockets, players int
+	bySM := make(map[methodStatus]int)
+	keys := []ConnKey{}
+
+	for key, s := range daemon.session {
+		bySM[methodStatus{s.method, s.status}]++
+		if s.status == CONN_S_CONNECTED {
+			websockets++
+		}
+		if s.method == CONN_M_PLAY && s.status != CONN_S_CLOSED {
+			players++
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	m.header("gotty_sessions", "gauge", "Sessions by method and status.")
+	sms := make([]methodStatus, 0, len(bySM))
+	for sm := range bySM {
+		sms = append(sms, sm)
+	}
+	sort.Slice(sms, func(i, j int) bool {
+		return sms[i].method+"/"+sms[i].status < sms[j].method+"/"+sms[j].status
+	})
+	for _, sm := range sms {
+		m.sample("gotty_sessions", float64(bySM[sm]),
+			"method", sm.method, "status", sm.status)
+	}
+
+	m.header("gotty_websockets", "gauge", "Connected websocket clients.")
+	m.sample("gotty_websockets", float64(websockets))
+
+	m.header("gotty_player_sessions", "gauge", "Sessions replaying a recording.")
+	m.sample("gotty_player_sessions", float64(players))
+
+	push, pop := daemon.waitingConn.Stats()
+	m.header("gotty_waiting_conns", "gauge", "Sessions waiting for their first websocket.")
+	m.sample("gotty_waiting_conns", float64(daemon.waitingConn.Len()))
+	m.header("gotty_waiting_conns_pushed_total", "counter", "Sessions added to the waiting queue.")
+	m.sample("gotty_waiting_conns_pushed_total", float64(push))
+	m.header("gotty_waiting_conns_popped_total", "counter", "Sessions removed from the waiting queue.")
+	m.sample("gotty_waiting_conns_popped_total", float64(pop))
+
+	m.header("gotty_session_received_bytes_total", "counter", "Input bytes received from the session's client.")
+	for _, key := range keys {
+		s := daemon.session[key]
+		m.sample("gotty_session_received_bytes_total",
+			float64(atomic.LoadUint64(&s.bytesIn)),
+			"name", key.Name, "addr", key.Addr, "method", s.method)
+	}
+	m.header("gotty_session_sent_bytes_total", "counter", "Pty output bytes sent by the session.")
+	for _, key := range keys {
+		s := daemon.session[key]
+		m.sample("gotty_session_sent_bytes_total",
+			float64(atomic.LoadUint64(&s.bytesOut)),
+			"name", key.Name, "addr", key.Addr, "method", s.method)
+	}
+
+	m.header("gotty_session_send_queue_bytes", "gauge", "Bytes queued for the session's client.")
+	for _, key := range keys {
+		s := daemon.session[key]
+		if s.status != CONN_S_CONNECTED || s.context == nil || s.context.connection == nil {
+			continue
+		}
+		m.sample("gotty_session_send_queue_bytes",
+			float64(s.context.connection.queueLen()),
+			"name", key.Name, "addr", key.Addr, "method", s.method)
+	}
+	m.header("gotty_slow_consumers_total", "counter", "Clients whose send queue overflowed, by what was done.")
+	m.sample("gotty_slow_consumers_total",
+		float64(atomic.LoadUint64(&metrics.slowSnapshots)), "action", SLOW_SNAPSHOT)
+	m.sample("gotty_slow_consumers_total",
+		float64(atomic.LoadUint64(&metrics.slowCloses)), "action", SLOW_CLOSE)
+
+	m.header("gotty_recorded_bytes_total", "counter", "Bytes written to recordings.")
+	m.sample("gotty_recorded_bytes_total", float64(atomic.LoadUint64(&metrics.recBytes)))
+
+	m.header("gotty_auth_failures_total", "counter", "Rejected authentication attempts.")
+	for i, source := range authFailSources {
+		m.sample("gotty_auth_failures_total",
+			float64(atomic.LoadUint64(&metrics.authFailures[i])), "source", source)
+	}
+
+	m.header("gotty_ip_filter_rejects_total", "counter", "Websockets refused by the session's addr filter.")
+	m.sample("gotty_ip_filter_rejects_total", float64(atomic.LoadUint64(&metrics.ipRejects)))
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := &metricWriter{}
+	writeMetrics(m)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.buf.Bytes())
+}
+
+// metricsListenAddr is where the unauthenticated metrics listener binds,
+// the loopback interface unless addr names a host
+func metricsListenAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
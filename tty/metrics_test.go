@@ -0,0 +1,65 @@
+package tty
+
+import (
+	"container/list"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	// other tests count failures too
+	metrics = daemonMetrics{}
+	key := ConnKey{Name: "abc", Addr: "127.0.0.1/32"}
+	daemon = &Daemon{
+		waitingConn: &Slist{list: list.New()},
+		session: map[ConnKey]*session{
+			key: &session{key: key, method: CONN_M_EXEC,
+				status: CONN_S_CONNECTED, options: &CmdOptions{},
+				bytesIn: 3, bytesOut: 1024},
+			ConnKey{Name: "rec"}: &session{key: ConnKey{Name: "rec"},
+				method: CONN_M_PLAY, status: CONN_S_WAITING,
+				options: &CmdOptions{}},
+		},
+	}
+	daemon.waitingConn.Push(daemon.session[ConnKey{Name: "rec"}])
+	authFailed(AUTH_F_BASIC)
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, line := range []string{
+		"# TYPE gotty_sessions gauge",
+		`gotty_sessions{method="exec",status="connected"} 1`,
+		`gotty_sessions{method="play",status="waiting"} 1`,
+		"gotty_websockets 1",
+		"gotty_player_sessions 1",
+		"gotty_waiting_conns 1",
+		"gotty_waiting_conns_pushed_total 1",
+		`gotty_session_received_bytes_total{name="abc",addr="127.0.0.1/32",method="exec"} 3`,
+		`gotty_session_sent_bytes_total{name="abc",addr="127.0.0.1/32",method="exec"} 1024`,
+		`gotty_auth_failures_total{source="basic"} 1`,
+		"# TYPE gotty_slow_consumers_total counter",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+
+	m := &metricWriter{}
+	m.sample("x", 1, "l", "a\"b\\c\nd")
+	if got := m.buf.String(); got != `x{l="a\"b\\c\nd"} 1`+"\n" {
+		t.Errorf("label escaping: %q", got)
+	}
+
+	for addr, want := range map[string]string{
+		":9100":        "127.0.0.1:9100",
+		"0.0.0.0:9100": "0.0.0.0:9100",
+		"[::1]:9100":   "[::1]:9100",
+	} {
+		if got := metricsListenAddr(addr); got != want {
+			t.Errorf("metrics_addr %q listens on %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package tty
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	oidcCallbackPath  = "/oauth2/callback"
+	oidcStateCookie   = "gotty_oidc_state"
+	oidcSessionCookie = "gotty_session"
+	// allowed drift between our clock and the one of the provider
+	oidcClockSkew = time.Minute
+	// the key set is refetched for an unknown key id at most this often
+	oidcJwksInterval = time.Minute
+)
+
+type OidcOptions struct {
+	Issuer        string   `hcl:"issuer"`
+	ClientId      string   `hcl:"client_id"`
+	ClientSecret  string   `hcl:"client_secret"`
+	RedirectUrl   string   `hcl:"redirect_url"`
+	Scopes        []string `hcl:"scopes"`
+	UsernameClaim string   `hcl:"username_claim"`
+	GroupsClaim   string   `hcl:"groups_claim"`
+	AllowedGroups []string `hcl:"allowed_groups"`
+	Role          string   `hcl:"role"`
+	SessionTTL    int      `hcl:"session_ttl"`
+}
+
+// oidcProvider logs web users in with the authorization code flow of
+// an OpenID Connect identity provider, the result is kept in a signed
+// session cookie
+type oidcProvider struct {
+	sync.Mutex
+	opt    *OidcOptions
+	tokens *tokenIssuer
+	client *http.Client
+	keys   map[string]*rsa.PublicKey
+	config *oidcConfig
+	// when the key set was last fetched
+	jwksTime time.Time
+}
+
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// oidcState travels through the identity provider in a cookie
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Redirect string `json:"r"`
+	Expire   int64  `json:"e"`
+}
+
+type oidcSession struct {
+	User   string `json:"u"`
+	Role   string `json:"r"`
+	Expire int64  `json:"e"`
+}
+
+func newOidcProvider(opt *OidcOptions, tokens *tokenIssuer) (*oidcProvider, error) {
+	if opt.ClientId == "" {
+		return nil, errors.New("oidc client_id is required")
+	}
+	if _, ok := roleLevel[opt.Role]; !ok {
+		return nil, fmt.Errorf("oidc unknown role %q", opt.Role)
+	}
+	return &oidcProvider{
+		opt:    opt,
+		tokens: tokens,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}, nil
+}
+
+func (p *oidcProvider) getJson(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover fetches the provider metadata once
+func (p *oidcProvider) discover() (*oidcConfig, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+
+	var c oidcConfig
+	if err := p.getJson(strings.TrimRight(p.opt.Issuer, "/")+
+		"/.well-known/openid-configuration", &c); err != nil {
+		return nil, err
+	}
+	if c.Issuer != p.opt.Issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch %q", c.Issuer)
+	}
+	p.config = &c
+	return p.config, nil
+}
+
+// key returns the signing key kid, the key set is refetched on a miss
+// to follow key rotation, but not for every token with a made up kid
+func (p *oidcProvider) key(c *oidcConfig, kid string) (*rsa.PublicKey, error) {
+	p.Lock()
+	defer p.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.jwksTime) < oidcJwksInterval {
+		return nil, fmt.Errorf("oidc unknown key id %q", kid)
+	}
+	p.jwksTime = time.Now()
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJson(c.JwksUri, &jwks); err != nil {
+		return nil, err
+	}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc unknown key id %q", kid)
+}
+
+func (p *oidcProvider) redirectUrl(r *http.Request) string {
+	if p.opt.RedirectUrl != "" {
+		return p.opt.RedirectUrl
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallbackPath
+}
+
+func (p *oidcProvider) setCookie(w http.ResponseWriter, r *http.Request,
+	name, value string, expire int64) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Unix(expire, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// login sends the browser to the identity provider
+func (p *oidcProvider) login(w http.ResponseWriter, r *http.Request) {
+	c, err := p.discover()
+	if err != nil {
+		glog.Errorf("oidc discovery %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	st := &oidcState{
+		State:    generateRandomString(32),
+		Nonce:    generateRandomString(32),
+		Redirect: r.URL.RequestURI(),
+		Expire:   time.Now().Add(10 * time.Minute).Unix(),
+	}
+	p.setCookie(w, r, oidcStateCookie, p.tokens.seal(TOKEN_OIDC_STATE, st), st.Expire)
+
+	scopes := p.opt.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile"}
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.opt.ClientId)
+	v.Set("redirect_uri", p.redirectUrl(r))
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", st.State)
+	v.Set("nonce", st.Nonce)
+
+	sep := "?"
+	if strings.Contains(c.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, c.AuthorizationEndpoint+sep+v.Encode(), http.StatusFound)
+}
+
+// callback finishes the login, the code is exchanged for an id token
+// whose claims become the session cookie
+func (p *oidcProvider) callback(w http.ResponseWriter, r *http.Request) {
+	var st oidcState
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || p.tokens.unseal(TOKEN_OIDC_STATE, cookie.Value, &st) != nil ||
+		st.Expire < time.Now().Unix() {
+		http.Error(w, "login expired, please retry", http.StatusBadRequest)
+		return
+	}
+	p.setCookie(w, r, oidcStateCookie, "", 1)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		glog.Infof("oidc login failed: %s %s", e, q.Get("error_description"))
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+	if q.Get("state") != st.State {
+		http.Error(w, "state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	sess, err := p.exchange(r, q.Get("code"), st.Nonce)
+	if err != nil {
+		glog.Infof("oidc login failed from %s: %v", r.RemoteAddr, err)
+		authFailed(AUTH_F_OIDC)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	glog.V(2).Infof("oidc user %s logged in from %s", sess.User, r.RemoteAddr)
+	p.setCookie(w, r, oidcSessionCookie, p.tokens.seal(TOKEN_OIDC_LOGIN, sess), sess.Expire)
+	if !strings.HasPrefix(st.Redirect, "/") || strings.HasPrefix(st.Redirect, "//") {
+		st.Redirect = "/"
+	}
+	http.Redirect(w, r, st.Redirect, http.StatusFound)
+}
+
+func (p *oidcProvider) exchange(r *http.Request, code, nonce string) (*oidcSession, error) {
+	c, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectUrl(r))
+	form.Set("client_id", p.opt.ClientId)
+	req, err := http.NewRequest("POST", c.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.opt.ClientId),
+		url.QueryEscape(p.opt.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var tr struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, err
+	}
+
+	claims, err := p.verify(c, tr.IdToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.newSession(claims)
+}
+
+// verify checks an RS256 signed id token and returns its claims
+func (p *oidcProvider) verify(c *oidcConfig, token, nonce string) (map[string]interface{}, error) {
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims map[string]interface{}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(buf, &header) != nil {
+		return nil, errors.New("malformed id token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id token alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token signature")
+	}
+	key, err := p.key(c, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+		return nil, errors.New("bad id token signature")
+	}
+
+	buf, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(buf, &claims) != nil {
+		return nil, errors.New("malformed id token claims")
+	}
+	if claims["iss"] != c.Issuer {
+		return nil, fmt.Errorf("id token issuer %v", claims["iss"])
+	}
+	if !claimHas(claims["aud"], p.opt.ClientId) {
+		return nil, fmt.Errorf("id token audience %v", claims["aud"])
+	}
+	now := time.Now()
+	if exp, _ := claims["exp"].(float64); int64(exp) < now.Unix() {
+		return nil, errors.New("id token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok &&
+		int64(nbf) > now.Add(oidcClockSkew).Unix() {
+		return nil, errors.New("id token not valid yet")
+	}
+	if iat, ok := claims["iat"].(float64); ok &&
+		int64(iat) > now.Add(oidcClockSkew).Unix() {
+		return nil, errors.New("id token issued in the future")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func (p *oidcProvider) newSession(claims map[string]interface{}) (*oidcSession, error) {
+	name, _ := claims[p.opt.UsernameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("id token has no %s claim", p.opt.UsernameClaim)
+	}
+
+	if len(p.opt.AllowedGroups) > 0 {
+		ok := false
+		for _, g := range p.opt.AllowedGroups {
+			if claimHas(claims[p.opt.GroupsClaim], g) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("user %s is not in an allowed group", name)
+		}
+	}
+
+	sess := &oidcSession{
+		User:   name,
+		Role:   p.opt.Role,
+		Expire: time.Now().Add(time.Duration(p.opt.SessionTTL) * time.Second).Unix(),
+	}
+	// the user database may grant another role
+	if daemon.users != nil {
+		if u, ok := daemon.users.users[name]; ok {
+			sess.Role = u.Role
+		}
+	}
+	return sess, nil
+}
+
+// claimHas reports whether a string or string list claim contains s
+func claimHas(claim interface{}, s string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == s
+	case []interface{}:
+		for _, e := range v {
+			if e == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *oidcProvider) sessionUser(r *http.Request) *authUser {
+	var sess oidcSession
+
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil || p.tokens.unseal(TOKEN_OIDC_LOGIN, cookie.Value, &sess) != nil ||
+		sess.Expire < time.Now().Unix() {
+		return nil
+	}
+	if _, ok := roleLevel[sess.Role]; !ok || sess.User == "" {
+		return nil
+	}
+	return &authUser{Name: sess.User, Role: sess.Role}
+}
+
+// wrap requires a logged in user, browsers are sent to the identity
+// provider and api clients get a 401
+func (p *oidcProvider) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := p.sessionUser(r); u != nil {
+			handler.ServeHTTP(w, withUser(r, u))
+			return
+		}
+		if r.Method != "GET" || strings.HasPrefix(r.URL.Path, apiPrefix) {
+			http.Error(w, "login required", http.StatusUnauthorized)
+			return
+		}
+		p.login(w, r)
+	})
+}
@@ -0,0 +1,222 @@
+package tty
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIdp is a minimal stand-in OpenID Connect provider which logs in
+// every visitor as user with groups
+type testIdp struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	user   string
+	groups []string
+	nonces map[string]string
+	// key id of the tokens, and how often the key set was fetched
+	kid   string
+	fetch int32
+}
+
+func newTestIdp(t *testing.T) *testIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdp{key: key, nonces: make(map[string]string), kid: "test"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		RenderJson(w, &oidcConfig{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JwksUri:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&idp.fetch, 1)
+		RenderJson(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := generateRandomString(8)
+		idp.nonces[code] = q.Get("nonce")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+
+			"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		nonce, ok := idp.nonces[r.FormValue("code")]
+		if id != "gotty" || secret != "secret" || !ok {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		RenderJson(w, map[string]string{"id_token": idp.sign(t, map[string]interface{}{
+			"iss":                idp.URL,
+			"aud":                "gotty",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              nonce,
+			"preferred_username": idp.user,
+			"groups":             idp.groups,
+		})})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *testIdp) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": idp.kid})
+	payload, _ := json.Marshal(claims)
+	s := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	h := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := newTestIdp(t)
+	defer idp.Close()
+
+	tokens, _ := newTokenIssuer(time.Minute)
+	daemon = &Daemon{tokens: tokens}
+	opt := DefaultOptions.Oidc
+	opt.Issuer = idp.URL
+	opt.ClientId = "gotty"
+	opt.ClientSecret = "secret"
+	opt.AllowedGroups = []string{"ops"}
+	p, err := newOidcProvider(&opt, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.oidc = p
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcCallbackPath, p.callback)
+	mux.Handle("/", p.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := requestUser(r)
+		w.Write([]byte(u.Name + ":" + u.Role))
+	})))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(path string) (int, string) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if resp, _ := http.Get(srv.URL + apiPrefix + "sessions"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("api without login: %d", resp.StatusCode)
+	}
+
+	idp.user, idp.groups = "alice", []string{"dev"}
+	if code, _ := get("/"); code != http.StatusUnauthorized {
+		t.Fatalf("login outside allowed groups: %d", code)
+	}
+
+	idp.groups = []string{"dev", "ops"}
+	if code, body := get("/?name=x"); code != http.StatusOK || body != "alice:operator" {
+		t.Fatalf("login: %d %q", code, body)
+	}
+	if code, body := get(apiPrefix + "sessions"); code != http.StatusOK || body != "alice:operator" {
+		t.Fatalf("cookie session: %d %q", code, body)
+	}
+
+	// a forged session cookie is ignored
+	u, _ := url.Parse(srv.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: oidcSessionCookie, Path: "/",
+		Value: (&tokenIssuer{secret: []byte("x")}).seal(TOKEN_OIDC_LOGIN, &oidcSession{User: "bob",
+			Role: ROLE_ADMIN, Expire: time.Now().Add(time.Hour).Unix()})}})
+	if code, _ := get(apiPrefix + "sessions"); code != http.StatusUnauthorized {
+		t.Fatalf("forged cookie: %d", code)
+	}
+
+	// nor is a share token of the same daemon
+	jar.SetCookies(u, []*http.Cookie{{Name: oidcSessionCookie, Path: "/",
+		Value: tokens.mint(&sessionToken{Key: ConnKey{Name: "abc",
+			Addr: "1.2.3.4/32"}, Once: true})}})
+	if code, _ := get(apiPrefix + "sessions"); code != http.StatusUnauthorized {
+		t.Fatalf("share token as cookie: %d", code)
+	}
+}
+
+func TestOidcVerify(t *testing.T) {
+	idp := newTestIdp(t)
+	defer idp.Close()
+
+	tokens, _ := newTokenIssuer(time.Minute)
+	opt := DefaultOptions.Oidc
+	opt.Issuer = idp.URL
+	opt.ClientId = "gotty"
+	p, err := newOidcProvider(&opt, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	verify := func(claims map[string]interface{}) error {
+		claims["iss"], claims["aud"], claims["nonce"] = idp.URL, "gotty", "n"
+		claims["exp"] = now.Add(time.Hour).Unix()
+		_, err := p.verify(c, idp.sign(t, claims), "n")
+		return err
+	}
+
+	if err := verify(map[string]interface{}{"iat": now.Unix(),
+		"nbf": now.Add(30 * time.Second).Unix()}); err != nil {
+		t.Fatalf("within the clock skew: %v", err)
+	}
+	if verify(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}) == nil {
+		t.Fatal("token not valid yet accepted")
+	}
+	if verify(map[string]interface{}{"iat": now.Add(time.Hour).Unix()}) == nil {
+		t.Fatal("token issued in the future accepted")
+	}
+
+	// a made up key id doesn't refetch the key set for every token
+	idp.kid = "other"
+	for i := 0; i < 3; i++ {
+		if verify(map[string]interface{}{}) == nil {
+			t.Fatal("unknown key id accepted")
+		}
+	}
+	if n := atomic.LoadInt32(&idp.fetch); n != 1 {
+		t.Fatalf("key set fetched %d times", n)
+	}
+	p.jwksTime = now.Add(-oidcJwksInterval)
+	verify(map[string]interface{}{})
+	if n := atomic.LoadInt32(&idp.fetch); n != 2 {
+		t.Fatalf("key set fetched %d times, want a refetch", n)
+	}
+}
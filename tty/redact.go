@@ -0,0 +1,144 @@
+package tty
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// output is held back this many bytes, so a match split between two
+	// pty reads is still found. Longer secrets may leak across reads.
+	REDACT_HOLD = 256
+	// held output is let through when the pty stays quiet this long
+	REDACT_FLUSH = 20 * time.Millisecond
+	REDACT_MASK  = "[REDACTED]"
+)
+
+// redactRules mask what their regexps match in the output of a session.
+// If a regexp has a group, only the first group is masked, e.g.
+// `password=(\S+)` keeps "password=".
+type redactRules struct {
+	res  []*regexp.Regexp
+	mask []byte
+	// also mask what the clients see, not only the recording
+	live bool
+}
+
+func newRedactRules(patterns []string, mask string, live bool) (*redactRules, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if mask == "" {
+		mask = REDACT_MASK
+	}
+	r := &redactRules{mask: []byte(mask), live: live}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.res = append(r.res, re)
+	}
+	return r, nil
+}
+
+// sessionRedactRules merges the rules of the config with the ones of a
+// session
+func sessionRedactRules(opt *CmdOptions) (*redactRules, error) {
+	var patterns []string
+	if !opt.NoRedact {
+		patterns = append(patterns, daemon.options.Redact...)
+	}
+	patterns = append(patterns, opt.Redact...)
+	return newRedactRules(patterns, daemon.options.RedactMask,
+		daemon.options.RedactLive || opt.RedactLive)
+}
+
+// redact masks the matches in d and returns the part of d which is
+// done. Unless final, a match could continue in the next write, so
+// the last REDACT_HOLD bytes and any match reaching into them are
+// returned as keep.
+func (r *redactRules) redact(d []byte, final bool) (out, keep []byte) {
+	split := len(d)
+	if !final && split > REDACT_HOLD {
+		split -= REDACT_HOLD
+	} else if !final {
+		split = 0
+	}
+
+	var spans [][2]int
+	for _, re := range r.res {
+		for _, m := range re.FindAllSubmatchIndex(d, -1) {
+			if m[1] > split {
+				if m[0] < split {
+					split = m[0]
+				}
+				continue
+			}
+			if len(m) >= 4 && m[2] >= 0 {
+				m = m[2:4]
+			}
+			if m[1] > m[0] {
+				spans = append(spans, [2]int{m[0], m[1]})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	out = make([]byte, 0, split)
+	pos := 0
+	for _, s := range spans {
+		if s[0] >= split {
+			break
+		}
+		if s[1] <= pos {
+			continue
+		}
+		// overlapping spans share a mask
+		if s[0] >= pos {
+			out = append(out, d[pos:s[0]]...)
+			out = append(out, r.mask...)
+		}
+		pos = s[1]
+	}
+	// a hold moved back by a later rule may be inside a masked span
+	if pos > split {
+		split = pos
+	}
+	out = append(out, d[pos:split]...)
+	return out, d[split:]
+}
+
+// redactor masks a stream of output, writes are split anywhere
+type redactor struct {
+	rules   *redactRules
+	pending []byte
+}
+
+// write returns the masked output which can be let through
+func (r *redactor) write(d []byte) []byte {
+	out, keep := r.rules.redact(append(r.pending, d...), false)
+	r.pending = append([]byte(nil), keep...)
+	return out
+}
+
+// flush returns all the output held back
+func (r *redactor) flush() []byte {
+	out, _ := r.rules.redact(r.pending, true)
+	r.pending = nil
+	return out
+}
+
+// stringList is a flag which can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
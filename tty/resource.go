@@ -280,7 +280,7 @@ func staticJsFetchJs() (*asset, error) {
 	return a, nil
 }
 
-var _staticJsGottyJs = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x94\x56\x4f\x6f\xdb\x36\x14\xbf\xe7\x53\x10\xba\x84\xda\x5c\xc5\x6e\x77\x18\x6c\x64\x43\x57\x64\x40\xb7\x61\x29\x6a\x6f\x39\x04\x41\x41\x4b\xcf\x96\x16\x9a\x14\x48\x2a\x82\x57\xe8\xbb\xf7\x3d\x49\x96\x65\x99\xaa\x5b\x1e\x12\x99\xef\xdf\xef\xfd\x27\xdf\x14\x2a\x76\x99\x56\x3c\x64\x9f\xaf\x18\x9e\x17\x61\x58\xea\x5c\x6e\xef\x94\x58\x4b\x48\xd8\x2d\x2b\x33\x95\xe8\x32\x92\x3a\x16\xc4\x1a\xe5\x46\x3b\x1d\x6b\xc9\x6e\x6f\x59\x50\xf3\xce\x83\x45\x27\x2c\xcc\xd6\x7a\x84\x2c\x08\x13\xa7\x47\xb6\xc2\xa0\x3c\xe3\x27\xa6\x7e\x65\xd7\xa5\xb5\xf3\x9b\x9b\x6b\x36\xa7\x4f\xfa\x0a\xd9\x8f\x67\xba\x52\x6d\x9d\xe7\x3a\x17\x2e\x55\x62\x07\x48\x42\xe1\xeb\xa3\xad\x03\x60\xc2\xf5\x18\x6c\xb5\x73\xfb\xe0\xa9\x87\xb8\x70\xfa\x23\xc4\x5a\x29\x88\x1d\xb2\xbc\x9a\x2d\xae\x3a\xa2\xce\x41\x3d\x90\xe0\x59\xa4\x0e\x1c\x25\x51\x15\x94\xec\x01\xd6\x4b\x1d\x3f\x83\xe3\xe8\xdc\xe4\x68\x35\x6c\xd5\x1d\x04\x1c\x98\xdd\xe0\x2a\xcf\xd4\x76\x95\xed\xc0\xf4\xee\x4b\x1b\x69\x45\xe6\xfb\xc6\xe1\x05\x94\xeb\x23\x68\x39\x2d\xa8\x84\xff\xb1\xbc\xff\x3b\xb2\xce\xa0\xb2\x6c\xb3\xe7\x9f\xd9\x5b\xb3\x2d\x76\x28\x60\xe7\x75\x5a\x26\xec\x6d\xe1\xd2\x95\x7e\x06\x35\x67\x75\x18\x3e\xa1\xef\xe9\x27\x47\x37\x93\x2a\x0c\x17\x27\x6a\x3b\x50\x08\xc0\x82\x7b\xaf\x10\xf8\x8b\x90\x9c\x6c\x7d\x40\xda\x84\xbd\x99\xb2\x1f\xd8\x6c\x3a\x9d\x4e\x10\x43\xdf\x4d\x3a\x29\xf9\x19\x25\xb0\x11\x85\x74\x4b\xa7\x8d\xd8\x42\x1b\x29\x99\xad\xa3\xf6\x26\xfa\x0b\xd3\x27\xf9\xc0\xb4\x4f\x36\x8a\x25\xd6\x10\x1f\x9a\x21\xce\x56\x6d\x23\xb5\xc2\x3f\x99\x6a\x74\x9e\x71\x46\x5b\x70\x1f\x0c\x6c\x2c\x0f\x31\x66\x8e\x07\xe4\xcc\x2b\x50\xb1\x4e\xd0\xa3\x60\xc2\x02\x23\xca\xc0\x2b\xa9\xd5\x41\xf3\x47\x10\xc9\x7e\xac\x24\xfa\x69\xcd\x34\x72\xd5\xc2\x99\x8e\xf2\xc2\xa6\x67\x98\xe8\x20\x4d\xab\x7f\x57\x7f\xc2\x1e\x73\x87\xa9\xe8\x6b\xc6\x1b\x9f\xf2\x7e\xd6\x83\x69\x80\x15\x4f\x8c\x8b\x33\xbe\xca\x6f\x8e\xe4\x96\x75\x9d\xa0\xad\xa1\xf9\x31\x84\x47\xef\x6d\xf6\xff\x09\x48\x2c\xf2\x62\xa7\xb0\xbc\x8c\xc6\x32\xb8\x00\xd7\x4b\xa4\x13\xbc\x26\x3f\x06\x35\x3c\xca\x4d\xc7\x6f\xa8\x7f\x5a\x64\xf3\xc3\xc7\xe4\xa2\x04\xb9\x30\xaf\xff\x7e\x9d\xb7\x1a\xa5\x86\x5e\xca\xf9\xad\x2f\x37\x4d\xad\x28\xeb\x84\x94\x98\x90\xb5\x16\x26\x19\xf6\xc6\x50\xae\x6d\x95\x18\x9b\xc4\x01\x4f\x74\x5c\xb7\x3c\x15\xfa\x9d\x04\xfa\xfc\x6d\xff\x1e\xab\xc4\xb5\xe9\x0b\xfa\x6d\x5e\x0d\xe7\xcd\x0e\xac\x6d\xfa\xf4\xeb\x23\x27\x11\x4e\x20\x53\x4d\x8b\xe8\x47\x64\x65\x16\x03\x9f\x0d\xc0\xda\x32\x73\x71\xca\x8f\x7c\x8f\xd3\xa7\xa1\xae\x58\x58\x60\xd7\xd3\xeb\xf9\x48\x38\x74\x54\x9a\xcc\xc1\x3f\xab\xdf\x7f\xe6\xed\xcc\x17\x4e\xaf\x39\xa9\x1b\xce\x2c\x3a\x6b\x03\xe2\x79\xe1\x31\x31\xf3\x98\xb8\xb9\x61\xb9\x56\xdb\x6f\x57\xf2\x7a\x0c\x27\x8e\x93\x87\x1a\xdd\x2a\x73\x12\x1a\x74\xdf\x01\xee\x8d\x47\x6f\x8e\x93\x0a\x0c\x4e\x27\xa0\x1d\x53\xb7\x46\x2e\x8c\x1d\x55\x7e\xbf\xfe\x0f\x57\x58\xf4\x8c\xad\xcc\x7b\xb2\x61\xb4\xd1\xe6\x4e\x60\x1e\xba\xa4\x22\xcb\x58\xa3\xe2\x22\xb4\x5a\x02\xee\xd5\x2d\x0f\x96\xe0\x1c\x8d\x09\x6a\x4d\x94\xc1\xbf\xc1\xbc\xfe\xd1\xc7\xf6\x88\x94\x27\x0f\x9c\x2e\x34\x83\xa1\x8b\xec\x93\x6f\x91\xaf\xbe\x27\x7e\x3f\x79\xe2\x37\xdc\xec\x97\x23\x78\xe2\x7c\xfd\x2e\x21\xef\xcd\x41\x47\xe3\xfb\xa9\x5a\x0c\x09\xae\x47\xfc\x95\xd8\xe0\xbc\xcb\x3d\x78\xab\xf1\xf6\x8b\xa5\xb6\x97\x9b\x2f\xdb\x30\x4e\x71\xf5\x65\xb0\x8e\x77\xa1\x2e\xcc\x90\x8e\x93\x96\x41\xaa\xcb\xfb\x17\x30\x52\xec\x79\xf0\xae\x71\x0a\x4d\xb3\x77\x84\x25\xc1\x95\xa8\x0a\x29\x87\x33\xe8\x34\x01\xb4\x98\xbb\xe7\x41\xf7\x6c\x18\xc8\x10\xea\xd3\xc8\xfd\xc2\xa6\x3e\x17\xb0\x44\x48\x5e\x17\x8e\x37\xaf\xaf\xc9\x20\xe2\xcd\x9b\x63\x14\x52\xd5\x10\xaa\xab\xe3\x2b\xee\xf0\x64\xe9\x87\xf6\x74\x55\x75\xdb\x74\x16\x84\x9d\x3c\xfd\x6b\x20\x50\x00\xab\x90\x87\x57\x5f\x02\x00\x00\xff\xff\x9e\x90\xaa\xec\x32\x0b\x00\x00")
+var _staticJsGottyJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xcd\x5b\xeb\x73\xdb\x36\x12\xff\x9e\xbf\x02\x61\xa7\x09\xd5\xca\x8c\xec\x34\x8f\xca\xe7\x76\xda\x5c\x3a\xed\xf5\x91\x4c\xe3\x5e\x3f\xe4\x32\x1d\x88\x84\x24\xd6\x14\xc1\x23\x20\xcb\x6a\xa2\xff\xfd\x76\x17\x20\x05\x92\x20\x25\x27\x9d\x6b\x35\x99\x84\x0f\x60\x5f\xd8\x5d\xfc\x76\x89\x84\xf3\x75\x1e\xeb\x54\xe6\xe1\x88\xbd\xbd\xc3\xe0\x77\xcd\x4b\xb6\xd4\xba\x50\xcf\x73\x3e\xcb\x44\xc2\x2e\xd8\x26\xcd\x13\xb9\x89\x32\x19\x73\x1c\x1a\x15\xa5\xd4\x32\x96\x19\xbb\xb8\x60\x01\x8d\x9d\x06\xe7\xf5\x64\x5e\x2e\x94\x67\x92\x12\xbc\x8c\x97\xfb\x61\xeb\x12\xe6\xb3\xb0\xc1\xea\x4b\x76\x7f\xa3\xd4\xf4\xc1\x83\xfb\x6c\x8a\x97\x78\x35\x62\x9f\x76\x68\x2d\xa5\xd2\x9e\xc7\x05\xd7\xcb\x9c\xaf\x04\xbc\x82\xc9\xf7\x0d\xaf\x07\x0f\xd8\x42\x6a\xbd\x8d\xae\xcf\x98\x12\x79\xa2\x98\x5e\x0a\x26\xd7\xba\x58\x6b\x96\xe6\x6c\x96\xe6\xbc\xdc\xb2\x79\x09\x13\xd5\x18\x86\x94\xd7\xa2\x54\x40\x5b\x2f\x25\x8e\xd0\x15\x95\x22\x8d\xaf\x0c\xa9\x5a\x87\xca\x10\xa8\xef\xeb\xa0\x62\x13\x8c\x99\xb9\x0e\xde\x38\x56\x59\x6b\xf9\xb3\x88\x65\x9e\x8b\x58\xc3\xf0\x93\xd3\xf3\x3b\x15\x65\x14\x48\x8b\x72\x05\x92\x64\x8c\xe7\x09\x3d\x98\x71\x90\x82\x97\x82\x5d\x89\x42\xb3\xcd\x52\xe4\xf4\x58\x09\xa5\x40\x57\x96\x2a\x56\x0a\xb5\x5e\x89\xa4\x66\x81\x24\x2c\x51\x12\x2e\xe3\x5b\x51\x3a\x0f\xae\xc4\x76\x26\x79\x99\x38\x8f\xe2\x25\xd7\x2d\x39\xe4\x15\x72\x92\x96\x7a\x83\x27\xda\x64\x5c\x0b\x28\xe7\x73\x25\x34\xfc\x83\x77\x15\x09\x6b\xd7\x4d\x99\x6a\x6d\xe8\xb8\xca\xd5\x7c\x0d\xf1\x4b\xe2\x75\xc1\xf2\x75\x96\xed\x2d\x65\xe9\x5e\xb0\xc9\x5e\x32\xb4\x9b\x5d\x3d\x69\xec\x10\xaf\xcb\x52\xe4\xc0\x49\xcc\x94\x8c\xaf\x84\xae\xe7\x6f\xd4\x9e\x16\xcd\xbb\xb0\x9e\x8d\x3f\xa4\x31\x65\xb5\xd3\x27\x5c\xf3\x91\xf3\x1a\x7f\xe9\x9c\x85\x1b\xc5\xee\xdd\x03\x4a\x51\x29\x78\xb2\x7d\xa5\xb9\x16\xe8\xec\xbf\x8a\xd9\x2b\x62\x16\xbd\x78\xf9\xfc\xa7\xf6\x44\xfc\xc1\x14\x64\x61\x08\x9f\x37\x5e\xef\xea\xbb\xdd\x98\x2e\x77\xce\x42\x58\xbb\x5d\xf4\x8b\x66\xcd\xf2\xe9\x05\xc3\x37\x51\x26\xf2\x85\x5e\xee\x39\xa0\x89\xa3\x54\x46\x68\x79\xf1\xcb\xe5\x37\x4f\x5d\x11\x1a\x9c\x0a\x91\xff\xaa\x5c\x4e\x2e\x17\x1c\x61\xec\x89\xeb\x22\x36\x7b\x95\x43\x08\xd8\xf1\xde\xe3\x1d\xe5\xcc\xf8\xc8\x04\xd2\xe5\xb6\x00\x53\xb1\x80\x97\x25\xdf\xce\xd6\xf3\xb9\x28\x83\xfd\xd0\x0d\x32\x36\xe3\xad\x48\xb5\xb7\xa6\xf9\xe2\x32\x5d\xd5\x0e\x6b\x17\x9e\xef\x9d\x6f\x99\xc6\x4b\x16\xf3\xfc\xbe\x66\x33\x51\x39\x3f\x8b\x33\x09\x23\xc8\x25\x6a\x57\x80\xf7\x73\x59\x0a\x97\x4e\xaa\xad\xfb\xf0\x7c\xab\x97\xc0\xab\xc1\x9c\x88\xc1\x43\x10\xce\xf5\xcc\xbb\xc6\x35\xd1\x15\xd0\xbc\xd5\xfd\x79\x6b\x6e\x2c\xd2\x6b\xca\x93\x73\x9e\x29\xe1\xc8\x6f\x0d\x23\x73\xb4\xb9\x6b\x71\x71\x0d\xae\xdb\x76\x1f\x24\x96\xe6\x29\x1a\xfe\x2d\xfb\xaa\x5c\x80\x18\xb9\x56\x53\xca\xa7\x63\xf6\xd5\x5a\x2f\x49\xaa\xa9\xc9\x41\xbf\x41\x42\x59\xfe\x46\xd1\x3a\xde\x9d\x77\x1c\xb8\xd2\xc8\xe7\xa3\xc8\x24\xfa\xb9\x11\x81\x8e\xd6\xe7\x43\xe3\x5f\x54\xb1\x69\xbc\xb1\xcf\xc1\x1d\xe5\x29\x1a\xfe\xf5\xea\xc5\x4f\x91\xd2\x25\x08\x94\xce\xb7\x21\x12\x1c\xb5\xa2\xa3\x5e\x7f\x74\x10\xa1\xbf\xcb\xc1\xe2\xd7\x3c\x0b\x71\xfe\x4b\x78\x37\x66\x0f\x27\xec\x13\x76\x3a\x99\x4c\xc6\x14\xd6\x23\xc7\xd0\xc7\x68\x6d\x02\x24\x57\x9a\x67\xd9\xf7\x36\x13\x86\xa3\xae\xb6\xa5\xd0\xeb\x32\x1f\xd2\xac\x93\xa0\xaa\xdf\x92\x78\x24\x62\xce\xd7\x99\x7e\xa5\x65\xc9\x17\xc2\x86\x51\x96\xce\x22\xfb\x24\xfa\x01\xf6\xab\xac\xcd\xda\x37\x37\x8a\x33\xd8\x34\xc3\xb6\xa6\xe4\x8b\x86\xac\x99\x75\x69\xd3\xab\x77\x64\xb4\x10\xfa\x65\x29\xe6\x2a\x1c\xc1\x62\xe8\x30\x40\x8b\x9e\x88\x3c\x96\x09\x58\x0a\xf7\xaa\x92\x6f\x02\xef\x4c\x99\x57\x94\x7f\xc6\x34\xd8\x97\x33\x1a\x0e\x2c\x61\x54\x95\x8b\x8a\xb5\x5a\x76\x64\xa2\xb5\x92\x40\xfb\xdf\x97\xb0\x0c\xe0\x14\xe0\x74\x2e\x65\x78\xe2\x23\x8e\x3f\x5c\x76\xe3\x50\xc1\x24\x80\x4d\x1e\x87\x76\x17\x70\xe7\x67\x88\xf3\x5e\x91\x0b\x02\xb7\xb6\x00\x7d\x32\xee\xf5\x57\xe9\x1f\x0d\x31\x21\x07\xae\x57\x39\x04\x66\x29\x37\xea\xb0\xc0\xde\xd7\xf8\x0b\xce\x50\x93\x56\x80\xf4\x8e\xc6\xdf\xdb\xc1\xb7\x86\x2d\xc9\x36\xad\x2e\xc6\x07\x67\xa0\x12\x53\xfa\x7b\x78\xec\xae\xf7\xed\xe8\xce\x71\x4f\x7d\xab\x73\x4c\x68\xee\x7c\x0e\x9a\x00\xa0\x2a\x61\x6f\x0e\x13\x19\x53\xba\x44\x67\x7f\x9e\x09\xbc\xfc\x7a\xfb\x1d\xf8\x49\x85\x3c\x02\x37\xdf\xec\x7c\x39\x1a\xd0\x9f\x32\xf1\x3a\x9c\xa6\xdd\x3c\x83\x3b\xc3\xdd\x6a\x03\xe8\x4d\x3a\x6a\x29\x37\x2f\x00\x54\x02\x1e\x0b\x83\x1a\x03\x8a\x04\x62\x0f\xd3\xd9\x68\x28\xd7\x38\xbb\x8b\x2e\xd7\xa2\x9b\xe8\x49\xc6\x08\xf7\x7a\x46\x16\xcc\x63\x01\x88\xec\x2b\xdc\x7d\xbf\xa6\xdd\xb7\x2f\x52\x67\x5b\x2d\x94\x4d\x23\xbf\xa4\xb9\x7e\x4a\x73\x1c\x7a\x9e\xd0\x42\x86\x34\xef\xf5\xe4\x0d\xe2\xa1\xc9\xcd\xc3\x49\x9f\xef\x1b\x44\x13\x1a\x54\x60\x02\xcf\xcc\x8d\xd4\x7a\x46\xe8\x20\x3c\x1d\x8d\x7c\xf1\xfb\x1e\x29\x99\xf4\xbf\x60\x7b\xe1\x23\x95\xa5\xb1\x00\x0e\xcd\x59\x0a\x20\x6c\xbc\x74\x94\x04\x45\xda\x0a\xc4\x5c\x09\x76\x7f\x72\x7f\x7a\xa7\x47\x25\x5b\x76\x70\x2d\x67\x06\x64\x79\x94\x98\x01\x70\xbc\x3a\xf7\xd0\x3d\xf5\xd0\xc5\xe2\x42\x3a\x90\xe4\x20\x91\x33\x0f\x11\xe3\x6a\x42\xff\x4a\xd2\x5d\xa6\x3a\x13\x61\xcf\x32\xf6\xd2\x7d\xe8\xa1\x5b\xc0\xde\x21\x00\x6a\xc7\xe4\x2d\x94\xa8\x0a\x28\x4d\x7a\x89\xbf\x98\xfd\x0e\xde\x1d\x41\xb1\xa1\x42\x67\xee\x28\x02\x48\xf6\x9c\x83\xf1\xeb\xf0\x82\x21\x03\x89\x53\xc9\x4c\x40\x69\xb7\x08\x83\x57\x42\x6b\x0c\x36\x4c\x94\x30\x07\xfe\x0e\xa6\x74\xe3\xca\xf6\x1a\xde\xbc\xf1\x88\xd3\xb7\x0d\xc2\xf0\xf1\x31\xf3\x77\xb7\xb1\xdf\x67\x1e\xfb\xb5\x0b\xbf\xc3\x16\x6c\x28\x4f\xa5\x31\x6a\x5f\x56\x34\x8c\xee\x4d\xb2\x60\x12\x40\x4d\x70\x97\xa8\x60\x74\xbc\xbc\x8f\x3c\xf2\x62\x8c\xdf\x35\xb5\x63\xdf\xea\x98\xb7\xa0\x4b\x0c\x84\xb5\x78\x49\xb7\xa1\x05\x65\x87\xc3\xd9\x4c\x8f\xd6\x45\x82\xa9\xbb\x6d\x8f\xdb\xd8\xfb\x71\x9f\xfc\x55\xa9\xdb\xa7\x41\xf5\xbe\xd6\xa1\xde\x76\x8e\xd6\xa2\x22\xf1\x67\xe8\xf1\xa4\x4f\x0f\xac\xcf\x7b\x63\x04\xde\xd5\xf2\x3f\x83\x9b\xe3\x65\xc7\xa9\x11\x38\xbf\x42\xd7\xff\x20\xc9\x9f\xfe\x15\x92\xdb\xad\xfa\x83\x04\xff\xdc\x23\xf8\xbe\x33\x71\x54\x9c\xd6\x48\x40\x44\x54\x87\xf5\xa9\x5b\x7a\x4a\x2d\x33\xe3\x18\x8d\xb1\x08\x9e\x43\x06\x83\x52\xb8\x14\x09\x60\x75\xdb\x71\x61\x0a\xec\x67\x9b\x42\xb1\x5c\xe7\x50\xe0\x6a\x5e\x6a\xa8\x70\x17\x3c\xcd\xbb\x7b\x57\x55\xb4\x58\xfe\xbe\x02\xae\xc7\x60\xbb\x61\xe0\x44\x15\xf8\x9f\x0b\x9b\x6c\x2f\xca\xe9\x75\x2d\x64\x2e\xee\x0c\x1b\xb6\x59\x9a\x77\x8d\x69\x8b\xf5\x3a\x11\x37\x33\xe8\x17\x6c\x82\x62\x85\xbe\xfa\xff\xdd\x3b\x14\xd7\x96\x96\x5d\x08\x86\xdb\x4b\x2f\xf8\x5b\xe7\x47\xd4\x9c\x55\xc1\xe4\x22\xc5\xbd\xa8\x5f\xb2\x3d\x6a\xc4\x92\x8d\xc1\x0e\xf0\xcc\xde\x82\x75\x9e\xa1\xfd\x11\x4c\xa2\xac\x83\x60\x92\xca\xc9\xba\xb2\xae\x2b\x6e\x8f\x4a\x36\xc3\xe3\x3a\x39\x82\xc0\xad\xc7\x3c\x23\x9f\xee\x36\xc7\x2b\x2d\x0b\x9f\xc2\xf5\x0e\x72\x68\xd1\x8c\xdb\x58\x01\x7c\x8c\xc0\x89\x51\x09\x80\x66\xa1\xe9\x6c\x8d\x5b\x0b\xfb\xc9\x30\xc8\xb6\xbd\x93\x5d\xdd\x6a\x74\x01\x2b\x5b\xf1\x2b\xdb\x5c\x42\xe8\xca\x4c\x85\xc6\xea\x1e\x1b\xd3\xf8\x1e\x38\x42\xd4\x01\x1a\x64\x89\x14\xaa\x6e\xb4\x35\x08\x39\xe1\x41\x20\xb8\xd3\x73\xc3\x9e\x99\xd3\x28\x03\xb0\xc4\x42\x2a\xa8\xa9\xcf\x00\xff\xfc\xc3\x20\xf6\xaa\xf1\x07\x4f\x3e\xbd\x60\x4f\x4f\x3f\x3f\x6b\x5b\x45\xe1\x0b\xc3\x36\x9a\x97\x72\x05\x09\xb6\x7c\x26\x13\x11\xf1\xa2\xc8\xb6\x21\x5a\x7c\xcc\x5a\x48\x3c\x1d\x23\x3d\x43\xce\x2d\x94\xee\x34\x21\x38\x53\xdd\x7e\x62\xd5\xa0\x71\x55\x6c\x56\xc4\x55\x4f\x34\x38\x0d\x46\x6d\x6b\xbb\x08\x82\xf1\x24\x51\xe4\x1a\x33\x1e\x5f\x21\x0c\x82\xda\x3c\x53\x64\x0b\x4e\xa1\x5b\x26\xd4\x09\x32\xb9\x01\xfb\xf4\x15\x99\x7d\x63\x1f\xa7\x63\x26\x84\xd5\x32\x69\x13\xb2\x51\xb9\xc5\x7d\x23\x87\xe2\xce\x69\xae\x33\x71\xa3\x4b\x5e\xc8\x8c\x63\x1d\x94\xee\xfb\xc7\x0d\x91\x7a\x95\x22\xd5\x4d\x5f\x98\xbd\x2d\xf8\x1a\x42\x70\x6a\x1a\x80\x63\xa6\x0a\x81\x77\xa7\x00\x30\xa5\x4a\x71\xf2\x94\x4d\xc6\x2c\x59\x97\xdc\xde\xec\x9a\x3d\x44\x95\xc2\x3e\x0c\x84\xfe\x09\xf4\xa2\x5c\x6e\xdc\x90\x31\x36\x16\x57\xd6\xc4\xad\x16\xa3\x6d\x72\xa3\xa1\x5c\x59\xb1\x67\xd8\xf6\x8b\x7a\x19\x1e\x79\x3a\x0e\x34\xa1\xa7\x44\x26\x67\xe6\x68\x8b\xba\xcc\x36\x36\xb2\x95\x76\x18\x24\xe9\x75\xe0\x4c\x86\xc1\x40\x7a\x0b\x10\x36\x56\xea\x12\xec\x8c\xce\xbd\x37\x05\x9f\x01\xbc\x5d\x6b\x71\xce\x66\x52\x6b\xb9\x02\x7b\x14\x37\xe7\x2c\x13\x73\x6d\x2f\xcb\x74\xb1\xac\xae\x41\xd8\x86\x1a\xc1\x1f\x27\x50\xe1\x88\x1b\xb0\x2f\xc4\x45\x01\x3e\x03\x2a\x4c\xd9\x67\x38\x16\xfd\x66\x51\xc2\x66\x08\xd6\x2f\x17\x33\x1e\x82\xd5\xed\x9f\xe8\xc9\xc8\x43\x2b\x96\x99\x2c\xa7\xec\xa3\xf9\x7c\x7e\x0e\x7e\x96\x03\xd3\xd3\xb3\xe2\x86\x29\x9e\xab\x13\x70\xb1\x14\x1e\x27\xa9\x42\xa7\x82\xd5\xcd\x04\xf0\xe0\x59\xba\xc8\x4f\x20\x01\xac\xb0\xc1\x22\x30\x9b\x9e\x07\x6d\x6b\xad\x41\xaf\x46\xc7\x37\xe3\x33\x01\x61\xa7\xb1\x2c\x1b\x33\xdc\x34\xd3\xf8\xca\xd7\x01\x9e\x0d\x98\xd9\x90\x0d\x5a\xb9\x6c\x16\x69\x30\x31\x6c\x08\x1a\xbf\x87\x5c\x30\x62\xd5\x19\x82\x8c\xb1\x89\x80\xff\xb6\x5f\x5a\x71\xb0\xad\x6b\xae\x5a\x03\x60\x39\x21\x77\x80\xef\x3c\x5b\xa6\x59\x12\xce\x5a\x02\xd8\xdc\x30\xeb\xf7\x1e\x2d\x17\x0b\x62\x6f\x34\x08\x83\x77\xef\xb0\xf1\x48\x61\xf3\xc0\x6c\x29\x70\xdf\xdb\x5c\xb4\x0e\x1e\xbe\xe5\xb1\xf1\xa0\xc0\x10\x0c\xdc\xe2\xcc\xbd\xae\xd8\x7c\x41\x6c\x72\xf4\x40\xfa\xbe\x77\x3b\x26\x4a\x8b\xa2\xc3\xa2\x19\x97\x18\xe8\x03\x0b\xa6\x44\x06\x5b\x90\xbb\x60\xaf\x27\xd1\xd9\x23\x74\x47\xf8\x0b\xf2\xc3\xd9\x98\x7d\x36\x66\x4f\xe1\xfa\xf1\x9b\x6e\x81\xac\x7c\x0e\x22\x07\xf8\xc9\x02\xe7\xb5\x1d\x44\x46\xb0\xdd\xaf\xd1\xfc\xaa\xfd\xa2\xe9\x39\x0a\xeb\xc8\x9b\xa0\xd5\x32\x41\x1d\x1b\xeb\x2f\x7b\xac\x6e\x46\x82\x0b\x99\x5c\x7b\x71\x2b\x5b\xe3\xdc\xa0\x4e\x9d\x84\xbb\xbf\xc9\x24\x94\x06\x86\x2a\x69\x30\xda\x35\x33\x54\x9f\x83\xd2\x94\xce\x5a\x65\x69\x22\x86\x92\x58\x9a\x17\xeb\xc6\x5a\x99\x19\x91\xb6\x5f\xb4\x4a\xd4\x2a\xe8\xbc\x06\x50\x68\x3e\x05\xb4\x9e\xa3\xf7\xe0\x8b\xe8\xd4\xf3\x0a\x73\x23\xa6\x13\xa4\x7b\xda\xa5\x29\x73\x12\x66\xc8\x84\xfb\x2d\xa1\xd9\x17\xdc\x79\x88\x1d\x5e\x90\xce\x06\x73\x60\xb5\x60\x78\x80\xd9\x6c\x25\x9a\x6b\x65\x38\xde\x6e\xb1\x68\x4e\x7b\xb5\x28\x89\x0d\x45\x56\xc1\xf3\xf6\x96\xe3\x52\xa5\xf9\xce\xfb\x9a\xce\x4c\x26\xdb\x66\x36\xe3\x1d\xe6\x50\xd1\x50\x36\xac\xcd\xd5\x41\x9e\xb8\x34\x3f\x72\xbd\x84\x45\x94\xb2\x84\xf7\xe7\x9d\x38\x45\x44\xa7\xd9\xc7\xec\xf1\xc4\x9b\x2d\xdd\xd9\xec\x01\x8c\x1a\x51\x5f\x0b\xb7\xe6\x50\x01\xd8\x3b\x9d\x20\xee\x9f\x10\xd8\x0f\xf0\x9d\xea\x4f\xaf\x25\x28\xd3\xc4\x2a\xbe\xc4\x01\x1b\x30\xc6\x38\x62\x96\xa8\xda\x8c\xbb\xc8\xff\xae\x1d\x40\x88\xc6\x8b\xec\x25\x01\xcc\x70\x8f\x55\xd8\x89\x01\x30\x23\xd0\x03\xb1\x36\x40\x6e\x43\x84\xe2\x70\x08\xdd\x1b\x91\xc8\x14\x10\x46\x21\xdc\x8e\xed\xd4\x0a\x2b\x8d\x7c\x12\x1a\x67\xf5\x56\x03\x8e\x03\x02\x65\x20\x38\xc4\x9e\x9c\xa4\x95\x03\x03\xea\xae\x91\x07\xa0\x3c\xb4\x2a\xa0\xd7\xfe\x61\xaf\x7c\x2d\x4c\xa7\x3d\x1f\x33\xcd\x42\x41\xda\x7f\x34\x71\x7d\xce\xba\x44\x53\x1d\xd3\x56\x72\xce\x2a\x78\xbf\x32\x55\x10\x54\x75\x6b\xac\x21\x50\x59\xfb\x31\xed\xa3\xed\x6d\xc0\xae\x3e\x3a\xe0\x17\xe4\x80\xb0\x5d\x9f\xf7\xd9\x7a\xc5\x6f\x68\x4e\x65\x11\xcf\xc0\x7d\x06\xa7\x91\x1e\xb7\x30\x56\x40\xe3\x74\xbe\xfb\x34\x3f\x48\x61\x41\x39\x1d\xfe\x0c\xd9\xac\x72\xb5\xa7\xc2\x75\x68\xee\xfc\x05\x49\x55\xac\x33\xac\xc8\x15\xdb\x2c\x25\x5b\xca\xcc\x96\x18\x75\xf3\x50\xce\x9d\xf3\x09\xd4\x7d\xb1\xa7\x71\x80\x0e\x9e\x95\x61\x27\x90\x78\x13\x39\x9f\x8f\x01\xdc\x6a\x7b\xf0\x08\xfe\xc2\x83\x3d\xea\x8a\xea\x9a\x54\xd7\x35\x09\x32\x00\x8f\x81\x87\xce\x79\x1a\x12\x07\x1e\x15\x5c\x61\x91\x02\x00\xad\x55\xa7\x7c\xbf\xef\x64\x0e\x54\x2a\xb7\x2d\x11\x9e\xfc\x6d\x4a\x04\x5a\xef\xbf\x5d\x51\x10\xbc\xc7\xce\x42\xa7\x59\xaa\x63\x2b\xb5\xd7\xd4\x85\x6b\xc6\x95\x66\xb6\xb3\xd9\x6e\xe2\xd9\x1a\x90\x8e\x32\xb5\xcd\x4e\x07\xde\x9c\x75\x2d\xda\x8b\x6a\xb3\x4b\x11\x41\x4c\x97\x10\xd3\xf6\x02\xf3\x5a\x88\xcb\x5c\x44\xf6\xcc\x5c\x30\xc2\x60\x37\xb7\xbd\x69\x4d\xb5\xd8\xf1\x31\x9b\xf5\x70\xe4\x86\xf0\x05\xc0\x7e\x73\x75\xef\x1e\x3c\x83\xb5\x29\xcd\x33\xbc\xea\xe5\xd3\x5b\x42\xfd\xff\x6b\xa7\x0f\x28\x8f\x76\x7f\x4e\x96\x47\x16\x69\x9e\x8b\xf2\xdb\xcb\x1f\x7f\x68\x75\x8a\x5c\x03\x98\x1c\x75\x41\x8b\x14\xaa\xc8\x64\x94\x31\xa6\x5c\x91\xcd\x47\xfe\x39\xb7\x45\x5a\x83\x9b\x67\x95\x1a\xcd\x37\xaa\xd0\xc8\x03\xdb\xc8\x56\xae\x19\x3a\x57\xee\x0a\x66\xf6\x56\x1f\xed\x3e\x34\xe7\x6d\xc1\x1b\x26\xd8\xaa\xb4\x6a\xdb\x9c\x59\xeb\xdd\xd7\x97\xaf\xaa\xc4\x12\xaa\x34\xae\x06\x8b\xc3\x61\x38\x5c\x11\xd8\xf5\x7c\x7f\xf4\x3d\xdf\x31\x01\x40\xdb\x00\x9a\x5a\x03\x05\xbb\x69\x0e\xf1\x7f\x48\x62\x6c\x3b\x52\x5e\xfb\x10\x99\xaf\xe5\xd5\x7b\x8b\xac\xe8\x9c\x21\x1e\xda\xed\xe3\x4a\x67\x5e\xd5\x15\x95\xc7\x78\x8c\xf2\xbf\x6b\xa1\x34\x2c\x88\x84\x25\xea\xcf\x55\x9e\x2c\x42\x8b\x5a\x0c\x38\x71\x9f\xb8\x8e\xc1\x8c\x20\x5f\xa2\xd6\x24\x07\x94\x9a\x6c\x5a\xdf\x7d\x88\x11\x0d\x81\x5b\x59\xd1\xeb\xc4\x87\x56\xde\x31\x61\xa7\x4d\x30\x68\xc5\xca\x65\x16\xe9\x35\x1e\xee\xa5\xb8\xa4\x28\x2c\x46\x47\xaa\xed\x57\x7d\x01\xe5\x30\x5a\x4e\x4b\xd8\x32\xfa\xf4\x1f\x5a\x9a\xc3\xb6\x39\x0c\xd3\xf0\xdb\x21\xcb\x52\xb0\x0a\x41\xb4\x54\xe1\x81\x6e\x80\x64\x4b\x5e\x3a\x7b\x2c\x02\xac\x25\xb6\xe8\xe9\xeb\x61\x45\x81\xba\x42\xad\xf3\xc9\x63\x84\x58\x40\x05\x51\x5f\x8e\xd9\x5e\x30\x74\x58\x99\x0b\xe3\xfa\xbf\xcb\x34\x57\x88\xd0\xe8\x0b\x80\x6a\x41\xb1\x67\xe6\xb3\xe6\x00\x0c\x83\x54\x3a\x98\x6b\x5b\x20\x89\x86\xdf\x02\x26\x3d\x3c\x8c\x93\x36\x69\xa2\x97\x53\x76\xf6\x98\x5e\x0f\xa0\xa6\xba\xeb\x99\x83\xf6\x1e\x42\x87\x50\xd5\x71\x20\xaa\x69\x1d\x21\x0b\xea\x12\xde\xca\x3c\xee\x3e\x61\x28\x74\x7a\x09\x72\x71\x3c\x51\x18\xdc\xb5\x38\xd4\x38\x27\x4b\x61\xec\x7a\x36\x21\xd3\xc9\x6b\x51\x42\xf9\xbe\x39\x01\x13\xe1\x07\xa7\x73\xb6\x02\x80\x9c\xe6\x64\x3e\x36\x71\x11\x62\x57\x4e\x60\xd2\x16\xb2\x6a\xf8\x1c\xdd\x9d\xa2\x07\x75\x73\x0a\xf7\xe1\xa0\xfd\x12\x16\x30\x16\xb6\xac\x80\x31\xe8\xfd\x9d\x31\x1d\x5d\xad\x87\x40\x21\xff\x31\x36\xe8\x6f\x4e\x54\xfa\x07\xb9\xc5\x4c\x96\x40\xe8\x04\x1e\x9d\x77\xa8\xc8\x1c\x76\xfe\x04\x83\xc6\xfd\x0e\xdc\xce\x2a\x10\x75\x57\x42\x14\x55\x11\x05\x11\xb9\xe1\xf8\x3f\x2f\xe4\xaa\xfb\xbf\x04\xaa\x9f\xa0\x6f\x88\x2f\x4b\x59\x00\x24\x36\xb9\xca\x73\x08\x0e\x8f\x1b\xe1\x37\x2e\x04\x96\xa7\x0f\x71\x2b\x35\x72\x51\xd1\x19\x41\x09\xb3\x82\x14\x77\xf7\x82\x5a\x29\xfd\x87\xf6\x83\xa7\x9e\xb2\xe7\x2d\x9a\x76\xea\xd2\xdb\xf9\x0e\x1a\x38\xef\x3d\x00\x6d\xe7\x83\xb9\x5d\xbf\x20\x22\x47\x75\xad\x68\xee\xe8\xaf\x2d\x05\xb2\x34\x6f\xb0\x43\x43\x8d\x4d\xe0\xfb\xd0\x79\x72\x7c\x10\x92\xee\x2d\x6c\x89\x77\xdd\x95\xf7\x72\x33\xd3\xad\x67\xe3\x00\x3c\x6c\x82\xff\x0e\x36\x83\x20\xf0\x5d\x0b\x27\x2d\x79\x28\x31\xc4\xb0\x09\x66\x97\x12\x1b\xbb\xfb\xfb\x6f\x29\x33\xdc\x02\xf4\x57\x27\x6f\x1c\xd8\xef\x3d\x40\x80\x0a\xae\xf0\x0c\x8a\x4e\xe3\x14\x56\x5c\x57\x5f\x7f\xd9\x17\xec\xb4\xf7\x70\x96\xb3\x69\xd8\x24\x8e\x0e\x39\xc3\xe6\x55\x70\xd4\xf1\x2c\x4a\xa2\x2d\xeb\xb7\xc4\x58\xf1\xe2\x08\xfc\x61\x95\xb7\x70\x03\x0b\x82\xc2\xfc\xff\x14\x84\x62\xec\x3f\xeb\xb3\x27\x13\x61\x1b\x9c\xbe\x83\x77\x11\x6e\xb7\x21\x7e\xc1\xe9\x39\x7c\xb3\x8a\xe8\xa8\x09\xfd\x37\x34\x40\xe0\xd7\x40\xec\xdd\x3b\x76\x6b\x8b\xa1\x1f\x87\x24\x64\x63\x2a\x09\xec\xb2\x40\x71\x02\x12\x1d\xaf\x2c\x86\xa4\xcf\x96\x01\x60\xa9\xe0\x23\xce\x79\x70\x1b\x50\x83\x3f\x5b\xf0\x1f\xf2\x04\xda\x21\x12\x5a\x07\x6c\x68\x61\x84\x3e\xc0\x80\x5d\xd5\x01\x6b\x6f\xa9\xba\xde\xdf\x76\x83\xa6\x06\x9c\x42\xe4\xaf\xd3\xe4\xcd\xf0\xda\x1d\xe3\x2f\x15\x25\xef\x11\xe5\xf7\xf0\x48\x6a\x9e\xda\x83\xc9\xd8\xbb\x0c\x8d\xce\xbe\x12\x14\x17\x0e\xf6\x40\x49\xff\xa3\x42\xe0\x39\x10\x7b\xd0\xd8\x96\x96\x66\x05\x29\xc7\x91\x63\xa0\xb1\x46\xfb\xf3\xa3\xc6\x3e\xc7\x36\x07\xcd\xf1\x12\xdc\x7e\x76\xa3\x70\x74\xe7\x7f\x28\x36\xf3\x0a\x3a\x39\x00\x00")
 
 func staticJsGottyJsBytes() ([]byte, error) {
 	return bindataRead(
@@ -295,7 +295,7 @@ func staticJsGottyJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "static/js/gotty.js", size: 2866, mode: os.FileMode(436), modTime: time.Unix(1458817538, 0)}
+	info := bindataFileInfo{name: "static/js/gotty.js", size: 14650, mode: os.FileMode(436), modTime: time.Unix(1792290558, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
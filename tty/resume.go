@@ -0,0 +1,141 @@
+package tty
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/yubo/gotty/rec"
+)
+
+// resumeToken lets a client which lost its websocket take its session
+// back, the nonce changes with every connection
+type resumeToken struct {
+	Key   ConnKey `json:"k"`
+	Nonce string  `json:"n"`
+}
+
+// resumeMessage is sent after the output a client joined with, and
+// after a snapshot replaced the output it was too slow for
+type resumeMessage struct {
+	Token  string `json:"token,omitempty"`
+	Offset uint64 `json:"offset"`
+}
+
+func newResumeMessage(token string, offset uint64) []byte {
+	buf, _ := json.Marshal(&resumeMessage{Token: token, Offset: offset})
+	return append([]byte{rec.SetResume}, buf...)
+}
+
+// newResumeToken replaces the resume token of s, the one handed out
+// before can no longer be used
+func newResumeToken(s *session) string {
+	s.resumeNonce = generateRandomString(16)
+	return daemon.tokens.seal(TOKEN_RESUME, &resumeToken{Key: s.key, Nonce: s.resumeNonce})
+}
+
+// resumable reports whether the client of s may take it back now with
+// its token, within reconnect_grace of losing it. Later a persistent
+// session is reattached with the normal authentication.
+func (s *session) resumable(now int64) bool {
+	if s.status != CONN_S_DETACHED || s.resumeNonce == "" ||
+		s.root().status == CONN_S_CLOSED {
+		return false
+	}
+	return s.resumeUntil != 0 && now <= s.resumeUntil
+}
+
+// sendResume gives the client a new resume token and the offset of the
+// output it got
+func (context *clientContext) sendResume(offset uint64) error {
+	return context.connection.write(
+		newResumeMessage(newResumeToken(context.session), offset))
+}
+
+// ws_resume hands a session back to the client which lost its websocket,
+// the token proves it is the same client so it is not authenticated
+// again. It returns false if the token can not be used, the client is
+// then authenticated as any other.
+func ws_resume(init *InitMessage, r *http.Request, conn *websocket.Conn,
+	cip string) bool {
+	var t resumeToken
+	if err := daemon.tokens.unseal(TOKEN_RESUME, init.ResumeToken, &t); err != nil {
+		glog.Infof("Failed to resume websocket connection: %v", err)
+		return false
+	}
+	session, ok := daemon.session[t.Key]
+	if !ok {
+		glog.V(2).Infof("name:%s addr:%s is not exist\n", t.Key.Name, t.Key.Addr)
+		return false
+	}
+	if !ipFilter(cip, session.root().nets) {
+		glog.V(2).Infof("RemoteAddr:%s is not allowed to access name:%s addr:%s\n",
+			cip, t.Key.Name, t.Key.Addr)
+		atomic.AddUint64(&metrics.ipRejects, 1)
+		conn.Close()
+		return true
+	}
+
+	session.Lock()
+	defer session.Unlock()
+
+	if !hmac.Equal([]byte(t.Nonce), []byte(session.resumeNonce)) ||
+		!session.resumable(time.Now().Unix()) {
+		glog.V(2).Infof("name:%s addr:%s can not be resumed\n",
+			t.Key.Name, t.Key.Addr)
+		return false
+	}
+	// a user logged in by now must be the one of the session
+	u := session.user
+	if authEnabled() {
+		if wu := webUser(r); wu != nil {
+			if u != nil && wu.Name != u.Name {
+				glog.V(2).Infof("name:%s addr:%s can not be resumed by %s\n",
+					t.Key.Name, t.Key.Addr, wu.Name)
+				return false
+			}
+			u = wu
+		}
+	}
+	r = withUser(r, u)
+
+	session.resumeUntil = 0
+	session.connTime = time.Now().Unix()
+	session.status = CONN_S_CONNECTED
+	session.setClient(r)
+	session.context.request = r
+	session.context.connection = newWebConn(conn)
+	glog.V(2).Infof("name:%s addr:%s resumed from %s at %d\n",
+		t.Key.Name, t.Key.Addr, r.RemoteAddr, init.ResumeOffset)
+	daemon.audit.log(AUDIT_RESUME, session)
+	session.context.goHandleClientJoin(int64(init.ResumeOffset))
+	return true
+}
+
+// cleanDetached releases the sessions whose clients did not come back
+// within reconnect_grace, persistent ones only lose their resume token
+func cleanDetached(now int64) {
+	for key, s := range daemon.session {
+		if s.status != CONN_S_DETACHED || s.resumeUntil == 0 ||
+			now <= s.resumeUntil {
+			continue
+		}
+		if s.persistent() {
+			s.resumeUntil, s.resumeNonce = 0, ""
+			continue
+		}
+		glog.V(2).Infof("name:%s addr:%s not resumed\n", key.Name, key.Addr)
+		s.resumeUntil = 0
+		daemon.audit.log(AUDIT_EXPIRE, s)
+		root := s.root()
+		s.context.release(key)
+		if root.context.orphaned() {
+			// nobody is left to see the pty
+			root.context.terminate()
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package tty
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestResume(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{EnableReconnect: true,
+		ReconnectGrace: 30})
+	defer closeServer()
+	daemon.tokens, _ = newTokenIssuer(time.Minute)
+
+	conns := map[ConnKey]*webConn{}
+	s := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 1,
+		method: CONN_M_EXEC, status: CONN_S_CONNECTED, options: &CmdOptions{}}
+	s.context = &clientContext{session: s, connections: &conns,
+		history: newRingBuffer(16)}
+	daemon.session[s.key] = s
+
+	read := func(c *websocket.Conn) string {
+		t.Helper()
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	resume := func(c *websocket.Conn, offset uint64) string {
+		t.Helper()
+		var m resumeMessage
+		data := read(c)
+		if data[0] != '9' || json.Unmarshal([]byte(data[1:]), &m) != nil ||
+			m.Token == "" || m.Offset != offset {
+			t.Fatalf("got %q, want the token and offset %d", data, offset)
+		}
+		return m.Token
+	}
+
+	c, _, wc := dial(WS_PROTOCOL_BINARY)
+	s.context.connection = wc
+	if err := s.context.joinConnection(REPLAY_ALL); err != nil {
+		t.Fatal(err)
+	}
+	token := resume(c, 0)
+	s.context.writeOutput([]byte("hello "))
+	if got := read(c); got != "0hello " {
+		t.Fatalf("output %q", got)
+	}
+
+	// the client goes away, the pty keeps running for the grace period
+	c.Close()
+	s.context.close(s.key)
+	s.context.writeOutput([]byte("world"))
+	now := time.Now().Unix()
+	if s.status != CONN_S_DETACHED || !s.resumable(now) || s.context.orphaned() {
+		t.Fatalf("status %s, not resumable", s.status)
+	}
+	var rt resumeToken
+	if err := daemon.tokens.unseal(TOKEN_RESUME, token, &rt); err != nil || rt.Key != s.key ||
+		rt.Nonce != s.resumeNonce {
+		t.Fatalf("token %+v %v", rt, err)
+	}
+
+	// and gets what it missed
+	c, _, wc = dial(WS_PROTOCOL_BINARY)
+	defer c.Close()
+	s.status, s.resumeUntil = CONN_S_CONNECTED, 0
+	s.context.connection = wc
+	if err := s.context.joinConnection(6); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(c); got != "0world" {
+		t.Fatalf("missed output %q", got)
+	}
+	resume(c, 11)
+	if rt.Nonce == s.resumeNonce {
+		t.Fatal("resume token not replaced")
+	}
+
+	// too late for the output it missed, it gets the screen
+	s.context.connection = wc
+	s.context.writeOutput([]byte("0123456789\nab"))
+	read(c)
+	if err := s.context.joinConnection(6); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(c); got != "0"+SCREEN_RESET+"ab" {
+		t.Fatalf("screen %q", got)
+	}
+	resume(c, 24)
+
+	// nobody came back
+	s.context.close(s.key)
+	cleanDetached(now + 31)
+	if _, ok := daemon.session[s.key]; ok || s.status != CONN_S_CLOSED {
+		t.Fatalf("session %s kept", s.status)
+	}
+}
+
+func TestCloseDetached(t *testing.T) {
+	daemon = &Daemon{options: &Options{CloseSignal: 15},
+		session: map[ConnKey]*session{}}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer cmd.Process.Kill()
+
+	// the owner is connected, a viewer waits to resume
+	conns := map[ConnKey]*webConn{}
+	root := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 2,
+		method: CONN_M_EXEC, status: CONN_S_CONNECTED, options: &CmdOptions{}}
+	root.context = &clientContext{session: root, connections: &conns, command: cmd}
+	conns[root.key] = nil
+	viewer := &session{key: ConnKey{Name: "def", Addr: "127.0.0.1/32"}, linkNb: 1,
+		linkTo: root, method: CONN_M_ATTACH, status: CONN_S_DETACHED,
+		resumeNonce: "x", resumeUntil: time.Now().Unix() + 30,
+		options: &CmdOptions{}, context: root.context}
+	daemon.session[root.key], daemon.session[viewer.key] = root, viewer
+
+	var keys []ConnKey
+	if err := new(Cmd).Close(&CallOptions{Opt: CmdOptions{Name: "def",
+		Addr: "127.0.0.1/32"}}, &keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := daemon.session[viewer.key]; ok || viewer.status != CONN_S_CLOSED {
+		t.Fatalf("viewer %s kept", viewer.status)
+	}
+	select {
+	case <-exited:
+		t.Fatal("closing a viewer ended the pty of the session")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if root.status != CONN_S_CONNECTED || root.linkNb != 1 {
+		t.Fatalf("owner %s linkNb %d", root.status, root.linkNb)
+	}
+}
+
+func TestReattachGrace(t *testing.T) {
+	daemon = &Daemon{options: &Options{}, session: map[ConnKey]*session{},
+		upgrader: &websocket.Upgrader{}}
+	daemon.tokens, _ = newTokenIssuer(time.Minute)
+	handled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(w, r)
+		close(handled)
+	}))
+	defer srv.Close()
+
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	conns := map[ConnKey]*webConn{}
+	s := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 1,
+		method: CONN_M_EXEC, status: CONN_S_DETACHED, options: &CmdOptions{},
+		nets: &[]*net.IPNet{lo}, resumeNonce: "x",
+		resumeUntil: time.Now().Unix() + 30}
+	s.context = &clientContext{session: s, connections: &conns}
+	daemon.session[s.key] = s
+
+	// the page token of anybody is not enough to take over the session
+	// of a client which may still resume it
+	c, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.WriteJSON(&InitMessage{AuthToken: daemon.tokens.mint(&sessionToken{}),
+		Arguments: "?name=abc&addr=127.0.0.1/32"})
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running")
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := c.ReadMessage(); err == nil {
+		t.Fatalf("reattached, got %q", data)
+	}
+	if s.status != CONN_S_DETACHED || s.context.connection != nil {
+		t.Fatalf("session %s taken over", s.status)
+	}
+}
+
+func TestResumePersistent(t *testing.T) {
+	daemon = &Daemon{options: &Options{}, session: map[ConnKey]*session{}}
+
+	conns := map[ConnKey]*webConn{}
+	s := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 1,
+		method: CONN_M_EXEC, status: CONN_S_DETACHED,
+		options: &CmdOptions{Persist: true}, resumeNonce: "x"}
+	s.context = &clientContext{session: s, connections: &conns}
+	daemon.session[s.key] = s
+
+	// the token of a persistent session expires too
+	now := time.Now().Unix()
+	if s.resumable(now) {
+		t.Fatal("resumable without a grace period")
+	}
+	s.resumeUntil = now + 30
+	if !s.resumable(now) || s.resumable(now+31) {
+		t.Fatal("not resumable within the grace period only")
+	}
+
+	// and the session waits for a reattach
+	cleanDetached(now + 31)
+	if daemon.session[s.key] != s || s.status != CONN_S_DETACHED ||
+		s.resumeNonce != "" || s.resumeUntil != 0 {
+		t.Fatalf("session %s nonce %q until %d", s.status, s.resumeNonce,
+			s.resumeUntil)
+	}
+}
+
+func TestPersistDetach(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{CloseSignal: 15})
+	defer closeServer()
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer cmd.Process.Kill()
+
+	conns := map[ConnKey]*webConn{}
+	s := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 1,
+		method: CONN_M_EXEC, status: CONN_S_CONNECTED,
+		options: &CmdOptions{Persist: true}}
+	s.context = &clientContext{session: s, connections: &conns, command: cmd}
+	daemon.session[s.key] = s
+
+	// the client goes away, the session waits for a reattach
+	c, _, wc := dial(WS_PROTOCOL_BINARY)
+	defer c.Close()
+	conns[s.key] = wc
+	s.context.close(s.key)
+	if daemon.session[s.key] != s || s.status != CONN_S_DETACHED ||
+		s.linkNb != 1 || s.context.orphaned() {
+		t.Fatalf("session %s linkNb %d released", s.status, s.linkNb)
+	}
+	select {
+	case <-exited:
+		t.Fatal("detaching ended the pty")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// there is no connection to close, the pty is ended instead
+	var keys []ConnKey
+	if err := new(Cmd).Close(&CallOptions{Opt: CmdOptions{Name: "abc",
+		Addr: "127.0.0.1/32"}}, &keys); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pty of the detached session still running")
+	}
+}
+
+func TestReattachOperator(t *testing.T) {
+	// password of both users is "secret"
+	hash := []byte("$2a$04$Cd.ZXkr.H4oQTFUz68mNau94LnESStKk/94S/UtltnbGKWmaJ4/FW")
+	daemon = &Daemon{options: &Options{}, session: map[ConnKey]*session{},
+		upgrader: &websocket.Upgrader{},
+		users: &userDB{users: map[string]*authUser{
+			"alice": &authUser{Name: "alice", Role: ROLE_OPERATOR, hash: hash},
+			"bob":   &authUser{Name: "bob", Role: ROLE_VIEWER, hash: hash},
+		}}}
+	daemon.tokens, _ = newTokenIssuer(time.Minute)
+	daemon.titleTemplate = template.Must(template.New("title").Parse("{{ .Command }}"))
+	handled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(w, r)
+		handled <- struct{}{}
+	}))
+	defer srv.Close()
+
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	conns := map[ConnKey]*webConn{}
+	s := &session{key: ConnKey{Name: "abc", Addr: "127.0.0.1/32"}, linkNb: 1,
+		method: CONN_M_EXEC, status: CONN_S_DETACHED,
+		options: &CmdOptions{Persist: true}, nets: &[]*net.IPNet{lo}}
+	s.context = &clientContext{session: s, connections: &conns,
+		command: &exec.Cmd{Process: &os.Process{}},
+		connRx:  make(chan *connRx, 16), history: newRingBuffer(16)}
+	daemon.session[s.key] = s
+
+	reattach := func(name string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(
+			"ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{
+				"Authorization": {"Basic " + base64.StdEncoding.EncodeToString(
+					[]byte(name+":secret"))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.WriteJSON(&InitMessage{AuthToken: daemon.tokens.mint(&sessionToken{}),
+			Arguments: "?name=abc&addr=127.0.0.1/32"})
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler still running")
+		}
+		return c
+	}
+
+	// the read loop of a reattached client runs on after the handler,
+	// the audit log tells what it did
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	daemon.audit = &auditLogger{f: pw}
+	events := json.NewDecoder(pr)
+	event := func() *auditEvent {
+		t.Helper()
+		var e auditEvent
+		if err := events.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		// the logger's lock orders what was done before with the test
+		daemon.audit.Lock()
+		daemon.audit.Unlock()
+		return &e
+	}
+
+	// a viewer may watch, not take over the pty
+	reattach("bob").Close()
+	c := reattach("alice")
+	if e := event(); e.Event != AUDIT_REATTACH || e.User != "alice" {
+		t.Fatalf("event %+v, want the operator to reattach", e)
+	}
+	c.Close()
+	if e := event(); e.Event != AUDIT_DETACH {
+		t.Fatalf("event %+v, want a detach", e)
+	}
+}
@@ -0,0 +1,85 @@
+package tty
+
+import "sync"
+
+// ringBuffer keeps the last len(buf) bytes written to a pty, so that
+// a client joining a running session can be shown the current screen.
+type ringBuffer struct {
+	sync.Mutex
+	buf     []byte
+	start   int
+	n       int
+	wrapped bool
+	// bytes written since the start, the offset of the end of buf
+	total uint64
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+// write appends p, discarding the oldest data when full.
+// caller must hold the lock
+func (r *ringBuffer) write(p []byte) {
+	r.total += uint64(len(p))
+	size := len(r.buf)
+	if len(p) >= size {
+		copy(r.buf, p[len(p)-size:])
+		r.start = 0
+		r.wrapped = r.wrapped || r.n > 0 || len(p) > size
+		r.n = size
+		return
+	}
+
+	end := (r.start + r.n) % size
+	c := copy(r.buf[end:], p)
+	copy(r.buf, p[c:])
+
+	if r.n+len(p) > size {
+		r.start = (r.start + r.n + len(p)) % size
+		r.n = size
+		r.wrapped = true
+	} else {
+		r.n += len(p)
+	}
+}
+
+// bytes returns a copy of the buffered data. Once old data has been
+// dropped, everything up to the first newline is skipped too, so the
+// replay does not begin in the middle of an escape sequence.
+// caller must hold the lock
+func (r *ringBuffer) bytes() []byte {
+	data := make([]byte, r.n)
+	c := copy(data, r.buf[r.start:])
+	if c < r.n {
+		copy(data[c:], r.buf[:r.n-c])
+	}
+
+	if r.wrapped {
+		for i, b := range data {
+			if b == '\n' {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+// since returns what was written after offset, false if it is no longer
+// buffered. caller must hold the lock
+func (r *ringBuffer) since(offset uint64) ([]byte, bool) {
+	if offset > r.total || r.total-offset > uint64(r.n) {
+		return nil, false
+	}
+	missed := int(r.total - offset)
+	data := make([]byte, missed)
+	from := (r.start + r.n - missed) % len(r.buf)
+	c := copy(data, r.buf[from:])
+	if c < missed {
+		copy(data[c:], r.buf[:missed-c])
+	}
+	return data, true
+}
@@ -0,0 +1,51 @@
+package tty
+
+import "testing"
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(8)
+
+	r.write([]byte("abc"))
+	if got := string(r.bytes()); got != "abc" {
+		t.Fatalf("bytes() = %q, want %q", got, "abc")
+	}
+
+	r.write([]byte("de\nfgh"))
+	if got := string(r.bytes()); got != "fgh" {
+		t.Fatalf("bytes() = %q, want %q", got, "fgh")
+	}
+
+	r.write([]byte("0123456789\nxy"))
+	if got := string(r.bytes()); got != "xy" {
+		t.Fatalf("bytes() = %q, want %q", got, "xy")
+	}
+
+	if newRingBuffer(0) != nil {
+		t.Fatalf("newRingBuffer(0) should be disabled")
+	}
+}
+
+func TestRingBufferSince(t *testing.T) {
+	r := newRingBuffer(8)
+	r.write([]byte("abc"))
+	if got, ok := r.since(1); !ok || string(got) != "bc" {
+		t.Fatalf("since(1) = %q %v", got, ok)
+	}
+	r.write([]byte("defghij"))
+	if r.total != 10 {
+		t.Fatalf("total %d", r.total)
+	}
+	if got, ok := r.since(4); !ok || string(got) != "efghij" {
+		t.Fatalf("since(4) = %q %v", got, ok)
+	}
+	if got, ok := r.since(10); !ok || len(got) != 0 {
+		t.Fatalf("since(10) = %q %v", got, ok)
+	}
+	// dropped already, or not written yet
+	if _, ok := r.since(1); ok {
+		t.Fatal("since(1) after it was dropped")
+	}
+	if _, ok := r.since(11); ok {
+		t.Fatal("since(11) before it was written")
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"os/user"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/pkg/namesgenerator"
@@ -50,13 +52,36 @@ func (c *Cmd) Exec(arg *CallOptions, info *Session_info) error {
 		if err := keyGenerator(&info.Key); err != nil {
 			return err
 		}
+	} else if _, ok := daemon.session[info.Key]; ok {
+		// before a recording is made for it
+		return fmt.Errorf("the key name[%s] addr[%s] is exsit",
+			info.Key.Name, info.Key.Addr)
+	}
+	redact, err := sessionRedactRules(&arg.Opt)
+	if err != nil {
+		return err
+	}
+	if redact != nil && !arg.Opt.Rec && !redact.live &&
+		!daemon.audit.logsInput() {
+		// nothing to mask
+		redact = nil
 	}
 	if arg.Opt.Rec {
-		if recorder, err = rec.NewRecorder(env["TERM"], env["SHELL"],
-			arg.Args[0], expandHomeDir(daemon.options.RecFileDir)); err != nil {
+		if arg.Opt.RecFormat == "" {
+			arg.Opt.RecFormat = daemon.options.RecFormat
+		}
+		if recorder, err = rec.NewRecorder(arg.Opt.RecFormat, env["TERM"],
+			env["SHELL"], arg.Args[0],
+			expandHomeDir(daemon.options.RecFileDir)); err != nil {
 			return err
 		}
 		info.RecId = path.Base(recorder.FileName)
+		recorder.Meta.Name = info.Key.Name
+		recorder.Meta.Addr = info.Key.Addr
+		recorder.Meta.User = arg.Opt.Creator
+		if err = recorder.SaveMeta(); err != nil {
+			glog.Errorf("recording %s: %v", recorder.FileName, err)
+		}
 	}
 	sess := &session{
 		key:        info.Key,
@@ -68,9 +93,29 @@ func (c *Cmd) Exec(arg *CallOptions, info *Session_info) error {
 		command:    arg.Args,
 		nets:       parseAddr(info.Key.Addr),
 		recorder:   recorder,
+		redact:     redact,
 		context:    &clientContext{},
 	}
-	return daemon.newWaitingConn(sess)
+	if arg.Opt.Handoff {
+		sess.control = newWriteControl(info.Key)
+	}
+	if arg.Opt.IdleTimeout == 0 {
+		arg.Opt.IdleTimeout = daemon.options.IdleTimeout
+	}
+	if arg.Opt.MaxLifetime == 0 {
+		arg.Opt.MaxLifetime = daemon.options.MaxLifetime
+	}
+	if err = daemon.newWaitingConn(sess); err != nil {
+		if recorder != nil {
+			recorder.Close()
+			rec.Remove(recorder.FileName)
+		}
+		return err
+	}
+	daemon.audit.log(AUDIT_CREATE, sess)
+	info.Token = daemon.tokens.mint(&sessionToken{Key: info.Key,
+		Write: arg.Opt.PermitWrite, Once: true})
+	return nil
 }
 
 func (c *Cmd) Play(arg *CallOptions, info *Session_info) error {
@@ -93,6 +138,14 @@ func (c *Cmd) Play(arg *CallOptions, info *Session_info) error {
 		glog.V(2).Info(err.Error())
 		return err
 	}
+	player.ShowInput(arg.Opt.ShowInput)
+	if arg.Opt.Start > 0 {
+		if _, err = player.Control(&rec.PlayControl{Action: rec.PLAY_SEEK,
+			Time: player.PlayTime(arg.Opt.Start)}); err != nil {
+			player.Close()
+			return err
+		}
+	}
 	sess := &session{
 		key:        info.Key,
 		linkNb:     1,
@@ -105,16 +158,23 @@ func (c *Cmd) Play(arg *CallOptions, info *Session_info) error {
 		player:     player,
 		context:    &clientContext{},
 	}
-	return daemon.newWaitingConn(sess)
+	if err = daemon.newWaitingConn(sess); err != nil {
+		player.Close()
+		return err
+	}
+	daemon.audit.log(AUDIT_CREATE, sess)
+	return nil
 }
 
-func (c *Cmd) Attach(arg CallOptions, key *ConnKey) error {
+func (c *Cmd) Attach(arg CallOptions, info *Session_info) error {
+	key := &info.Key
 	key.Name = arg.Opt.Name
 	key.Addr = arg.Opt.Addr
 	skey := ConnKey{
 		Name: arg.Opt.SName,
 		Addr: arg.Opt.SAddr,
 	}
+	info.PKey = skey
 
 	if s, ok := daemon.session[skey]; ok {
 		if key.Name == "" {
@@ -126,7 +186,7 @@ func (c *Cmd) Attach(arg CallOptions, key *ConnKey) error {
 		s.Lock()
 		defer s.Unlock()
 
-		if s.status != CONN_S_CONNECTED {
+		if s.status != CONN_S_CONNECTED && s.status != CONN_S_DETACHED {
 			return fmt.Errorf("session{name:\"%s\", addr:\"%s\"} is not connected",
 				s.key.Name, s.key.Addr)
 		}
@@ -143,12 +203,39 @@ func (c *Cmd) Attach(arg CallOptions, key *ConnKey) error {
 			command:    arg.Args,
 			context:    &clientContext{},
 		}
-		return daemon.newWaitingConn(sess)
+		if err := daemon.newWaitingConn(sess); err != nil {
+			return err
+		}
+		daemon.audit.log(AUDIT_CREATE, sess)
+		info.Token = daemon.tokens.mint(&sessionToken{Key: *key,
+			Write: arg.Opt.PermitWrite, Once: true})
+		return nil
 	} else {
 		return fmt.Errorf("session{name:\"%s\", addr:\"%s\"} is not exist",
 			skey.Name, skey.Addr)
 	}
 }
+
+// Token mints a one-time token for the session, so its url can be
+// handed to someone else
+func (c *Cmd) Token(arg *CallOptions, info *Session_info) error {
+	info.Key = ConnKey{Name: arg.Opt.Name, Addr: arg.Opt.Addr}
+
+	s, ok := daemon.session[info.Key]
+	if !ok {
+		return fmt.Errorf("session{name:\"%s\", addr:\"%s\"} is not exist",
+			info.Key.Name, info.Key.Addr)
+	}
+
+	t := &sessionToken{Key: info.Key,
+		Write: arg.Opt.PermitWrite && s.options.PermitWrite, Once: true}
+	if arg.Opt.TTL > 0 {
+		t.Expire = time.Now().Unix() + int64(arg.Opt.TTL)
+	}
+	info.Token = daemon.tokens.mint(t)
+	return nil
+}
+
 func (c *Cmd) Close(arg *CallOptions, keys *[]ConnKey) error {
 	key := ConnKey{Name: arg.Opt.Name, Addr: arg.Opt.Addr}
 
@@ -158,6 +245,22 @@ func (c *Cmd) Close(arg *CallOptions, keys *[]ConnKey) error {
 			key.Name, key.Addr)
 	}
 
+	if s.status == CONN_S_DETACHED {
+		*keys = append(*keys, key)
+		if s.persistent() {
+			// no connection to close, end the pty instead
+			return s.context.terminate()
+		}
+		// a client waiting to resume, the others keep the pty
+		s.resumeUntil = 0
+		root := s.root()
+		s.context.release(key)
+		if root.context.orphaned() {
+			return root.context.terminate()
+		}
+		return nil
+	}
+
 	if !arg.Opt.All {
 		*keys = append(*keys, key)
 		s.context.close(key)
@@ -166,6 +269,12 @@ func (c *Cmd) Close(arg *CallOptions, keys *[]ConnKey) error {
 			*keys = append(*keys, k)
 			s.context.close(k)
 		}
+		if s.linkTo != nil {
+			s = s.linkTo
+		}
+		if s.persistent() {
+			return s.context.terminate()
+		}
 	}
 	return nil
 }
@@ -220,9 +329,73 @@ func rpc_done() {
 }
 
 func Call(serviceMethod string, args interface{}, reply interface{}) error {
+	// the daemon's audit log records who asked for a new session
+	if opt, ok := args.(*CallOptions); ok && opt.Opt.Creator == "" {
+		if u, err := user.Current(); err == nil {
+			opt.Opt.Creator = u.Username
+		}
+	}
 	client, err := rpc.Dial("unix", GlobalOpt.UnixSocket)
 	if err != nil {
 		return err
 	}
 	return client.Call(serviceMethod, args, reply)
 }
+
+// Control grants or revokes the keyboard of a session started with
+// -handoff, and returns who holds it
+func (c *Cmd) Control(arg *CallOptions, state *ControlState) error {
+	key := ConnKey{Name: arg.Opt.Name, Addr: arg.Opt.Addr}
+	s, ok := daemon.session[key]
+	if !ok {
+		return fmt.Errorf("session{name:\"%s\", addr:\"%s\"} is not exist",
+			key.Name, key.Addr)
+	}
+	root := s.root()
+	if root.control == nil {
+		return fmt.Errorf("session{name:\"%s\", addr:\"%s\"} was not started with -handoff",
+			key.Name, key.Addr)
+	}
+
+	if arg.Opt.Action != "" {
+		m := &controlMessage{Action: arg.Opt.Action}
+		if m.Action == CONTROL_GRANT {
+			to, err := findParticipant(root, arg.Opt.ControlTo)
+			if err != nil {
+				return err
+			}
+			m.To = participant{Name: to.Name, Addr: to.Addr}
+		}
+		if err := root.control.apply(nil, m); err != nil {
+			return err
+		}
+		daemon.audit.logControl(root, m)
+		sendControl(root)
+	}
+
+	root.control.Lock()
+	defer root.control.Unlock()
+	*state = *root.control.state(root)
+	return nil
+}
+
+// findParticipant looks up a participant of root by NAME or NAME/ADDR
+func findParticipant(root *session, to string) (ConnKey, error) {
+	if i := strings.Index(to, "/"); i > 0 {
+		// the addr may be a net with a slash of its own
+		return ConnKey{Name: to[:i], Addr: to[i+1:]}, nil
+	}
+	var found []ConnKey
+	for k, s := range daemon.session {
+		if k.Name == to && s.root() == root {
+			found = append(found, k)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return ConnKey{}, fmt.Errorf("%s is not a participant", to)
+	case 1:
+		return found[0], nil
+	}
+	return ConnKey{}, fmt.Errorf("%s is ambiguous, use NAME/ADDR", to)
+}
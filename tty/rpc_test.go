@@ -0,0 +1,38 @@
+package tty
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExecTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	daemon = &Daemon{options: &Options{RecFileDir: dir},
+		session: map[ConnKey]*session{}, waitingConn: &Slist{list: list.New()}}
+	daemon.tokens, _ = newTokenIssuer(time.Minute)
+
+	arg := &CallOptions{Opt: CmdOptions{Name: "abc", Addr: "127.0.0.1/32",
+		Rec: true}, Args: []string{"bash"}}
+	if err := new(Cmd).Exec(arg, &Session_info{}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+
+	// the name is taken, nothing is left of a second recording
+	arg = &CallOptions{Opt: CmdOptions{Name: "abc", Addr: "127.0.0.1/32",
+		Rec: true}, Args: []string{"bash"}}
+	if err := new(Cmd).Exec(arg, &Session_info{}); err == nil {
+		t.Fatal("two sessions with the same key")
+	}
+	if again, _ := ioutil.ReadDir(dir); len(again) != len(files) {
+		t.Fatalf("%d files, want %d", len(again), len(files))
+	}
+}
@@ -32,6 +32,11 @@ func (l *Slist) Push(v interface{}) *list.Element {
 	return l.list.PushBack(v)
 }
 
+// Stats returns how many elements have been pushed and popped
+func (l *Slist) Stats() (push, pop uint64) {
+	return atomic.LoadUint64(&l.push_cnt), atomic.LoadUint64(&l.pop_cnt)
+}
+
 func (l *Slist) Len() int {
 	l.RLock()
 	defer l.RUnlock()
@@ -9,8 +9,10 @@ import (
 	"os/user"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/braintree/manners"
+	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
 	"github.com/yubo/gotty/rec"
 )
@@ -25,6 +27,8 @@ type clientContext struct {
 	fd          uintptr
 	writeMutex  *sync.Mutex
 	connRx      chan *connRx
+	history     *ringBuffer
+	chat        *chatLog
 }
 
 type argResizeTerminal struct {
@@ -37,10 +41,15 @@ type ContextVars struct {
 	Pid        int
 	Hostname   string
 	RemoteAddr string
+	User       string
 }
 type InitMessage struct {
 	Arguments string `json:"Arguments,omitempty"`
 	AuthToken string `json:"AuthToken,omitempty"`
+	// a client which lost its websocket takes its session back with the
+	// last resume token it got, and gets the output after ResumeOffset
+	ResumeToken  string `json:"ResumeToken,omitempty"`
+	ResumeOffset uint64 `json:"ResumeOffset,omitempty"`
 }
 
 type ConnKey struct {
@@ -64,6 +73,10 @@ type Daemon struct {
 	waitingConn   *Slist
 	user          *user.User
 	ugroups       []uint32
+	users         *userDB
+	tokens        *tokenIssuer
+	audit         *auditLogger
+	oidc          *oidcProvider
 }
 
 type Session_info struct {
@@ -76,6 +89,7 @@ type Session_info struct {
 	ConnTime   int64
 	LinkNb     int32
 	RecId      string
+	Token      string
 	Cmd        string
 	Share      bool
 	Time       string
@@ -110,6 +124,49 @@ type session struct {
 	nets       *[]*net.IPNet
 	recorder   *rec.Recorder
 	player     *rec.Player
+	redact     *redactRules
+	control    *writeControl
+	user       *authUser
+	readOnly   bool
+	bytesIn    uint64
+	bytesOut   uint64
+	// unix nano time of the last input to the pty
+	lastInput int64
+	// masks the input of this participant with the rules of the root
+	// before it is logged or recorded
+	inputRedact *redactor
+	// the nonce of the resume token handed to the client, and until when
+	// it may resume after it lost its websocket
+	resumeNonce string
+	resumeUntil int64
+}
+
+// setClient records who is behind the websocket connected to s
+func (s *session) setClient(r *http.Request) {
+	s.user = requestUser(r)
+	if t := requestToken(r); t != nil {
+		s.readOnly = !t.Write
+	}
+	if s.recorder != nil {
+		if s.user != nil {
+			s.recorder.SetUser(s.user.Name)
+		}
+		s.recorder.Meta.RemoteAddr = r.RemoteAddr
+		if err := s.recorder.SaveMeta(); err != nil {
+			glog.Errorf("recording %s: %v", s.recorder.FileName, err)
+		}
+	}
+}
+
+// writable reports whether input from this session's client reaches the pty
+func (s *session) writable() bool {
+	return s.options.PermitWrite && !s.readOnly &&
+		(s.user == nil || s.user.can(ROLE_OPERATOR))
+}
+
+// persistent sessions own a pty which outlives its websocket
+func (s *session) persistent() bool {
+	return s.linkTo == nil && s.method == CONN_M_EXEC && s.options.Persist
 }
 
 type Options struct {
@@ -120,6 +177,7 @@ type Options struct {
 	DemoDir             string                 `hcl:"demo_dir"`
 	DemoEnable          bool                   `hcl:"demo_enable"`
 	DemoAddr            string                 `hcl:"demo_addr"`
+	ApiEnable           bool                   `hcl:"api_enable"`
 	EnableTLS           bool                   `hcl:"enable_tls"`
 	TLSCrtFile          string                 `hcl:"tls_crt_file"`
 	TLSKeyFile          string                 `hcl:"tls_key_file"`
@@ -135,12 +193,41 @@ type Options struct {
 	RawPreferences      map[string]interface{} `hcl:"preferences"`
 	WaitingConnTime     int                    `hcl:"waiting_conn_time"`
 	RecFileDir          string                 `hcl:"rec_file_dir"`
+	RecFormat           string                 `hcl:"rec_format"`
+	RecMaxAge           int                    `hcl:"rec_max_age"`
+	RecMaxSize          int                    `hcl:"rec_max_size"`
+	RecKeepLast         int                    `hcl:"rec_keep_last"`
+	RecKeyFile          string                 `hcl:"rec_key_file"`
 	SkipTlsVerify       bool                   `hcl:"skip_tls_verify"`
 	UnixSocket          string                 `hcl:"unix_socket"`
 	Debug               bool                   `hcl:"debug"`
 	Resourses           string                 `hcl:"resources"`
 	Chuser              string                 `hcl:"chuser"`
 	Env                 map[string]string      `hcl:"env"`
+	UserFile            string                 `hcl:"user_file"`
+	AuditLog            string                 `hcl:"audit_log"`
+	AuditInput          bool                   `hcl:"audit_input"`
+	MetricsEnable       bool                   `hcl:"metrics_enable"`
+	MetricsAddr         string                 `hcl:"metrics_addr"`
+	Oidc                OidcOptions            `hcl:"oidc"`
+	TokenTTL            int                    `hcl:"token_ttl"`
+	ScrollbackSize      int                    `hcl:"scrollback_size"`
+	Redact              []string               `hcl:"redact"`
+	RedactMask          string                 `hcl:"redact_mask"`
+	RedactLive          bool                   `hcl:"redact_live"`
+	WsCompression       bool                   `hcl:"ws_compression"`
+	WsCompressionLevel  int                    `hcl:"ws_compression_level"`
+	OutputCoalesce      int                    `hcl:"output_coalesce"`
+	OutputCoalesceMax   int                    `hcl:"output_coalesce_max"`
+	SendQueue           int                    `hcl:"send_queue"`
+	SlowConsumer        string                 `hcl:"slow_consumer"`
+	SendTimeout         int                    `hcl:"send_timeout"`
+	WsPingInterval      int                    `hcl:"ws_ping_interval"`
+	WsPongTimeout       int                    `hcl:"ws_pong_timeout"`
+	IdleTimeout         int                    `hcl:"idle_timeout"`
+	MaxLifetime         int                    `hcl:"max_lifetime"`
+	TimeoutWarning      int                    `hcl:"timeout_warning"`
+	ReconnectGrace      int                    `hcl:"reconnect_grace"`
 }
 
 type CallOptions struct {
@@ -149,33 +236,84 @@ type CallOptions struct {
 }
 
 type CmdOptions struct {
-	All              bool    `json:"all"`
-	PermitWrite      bool    `json:"write"`
-	PermitShare      bool    `json:"share"`
-	PermitShareWrite bool    `json:"sharew"`
-	Rec              bool    `json:"rec"`
-	Repeat           bool    `json:"repeat"`
-	MaxWait          int64   `json:"maxwait"`
-	Speed            float64 `json:"speed"`
-	Name             string  `json:"name"`
-	Addr             string  `json:"addr"`
-	Cmd              string  `json:"cmd"`
-	SName            string  `json:"sname"`
-	SAddr            string  `json:"saddr"`
-	RecId            string  `json:"recid"`
-	Action           string  `json:"action"`
+	All              bool       `json:"all"`
+	PermitWrite      bool       `json:"write"`
+	PermitShare      bool       `json:"share"`
+	PermitShareWrite bool       `json:"sharew"`
+	Rec              bool       `json:"rec"`
+	RecFormat        string     `json:"recformat"`
+	RecInput         bool       `json:"recinput"`
+	Handoff          bool       `json:"handoff"`
+	ControlTo        string     `json:"controlto"`
+	Persist          bool       `json:"persist"`
+	Repeat           bool       `json:"repeat"`
+	MaxWait          int64      `json:"maxwait"`
+	Speed            float64    `json:"speed"`
+	Start            float64    `json:"start"`
+	Export           string     `json:"export"`
+	ShowInput        string     `json:"showinput"`
+	Redact           stringList `json:"redact"`
+	NoRedact         bool       `json:"noredact"`
+	RedactLive       bool       `json:"redactlive"`
+	IdleTimeout      int        `json:"idletimeout"`
+	MaxLifetime      int        `json:"maxlifetime"`
+	Name             string     `json:"name"`
+	Addr             string     `json:"addr"`
+	Cmd              string     `json:"cmd"`
+	SName            string     `json:"sname"`
+	SAddr            string     `json:"saddr"`
+	RecId            string     `json:"recid"`
+	Action           string     `json:"action"`
+	Role             string     `json:"role"`
+	TTL              int        `json:"ttl"`
+	Creator          string     `json:"-"`
 }
 
 type connRx struct {
 	key         ConnKey
+	conn        *webConn
 	messageType int
 	p           []byte
 	err         error
+	// a line of the daemon for the clients instead of a message
+	notice string
 }
 
 type webConn struct {
 	sync.Mutex
 	conn *websocket.Conn
+	// the client speaks WS_PROTOCOL_BINARY
+	binary bool
+	// output is held back this long, or until this much is pending, and
+	// sent as one frame
+	window time.Duration
+	max    int
+	// messages waiting for the writer, queued bytes of at most limit
+	queue   []wsMessage
+	queued  int
+	limit   int
+	policy  string
+	timeout time.Duration
+	wake    chan struct{}
+	full    chan struct{}
+	// the output was dropped, the next is replaced by a snapshot
+	resync  bool
+	closing bool
+	// the writer failed, the next write returns it
+	err error
+	// a ping is sent this often, the client is dropped if nothing is
+	// read for pongWait
+	ping     time.Duration
+	pongWait time.Duration
+	// bytes written since writeEach last counted them
+	sent uint64
+}
+
+type wsMessage struct {
+	messageType int
+	// pty output, encoded in the client's protocol when it is written
+	output bool
+	data   []byte
 }
 
 type connErr struct {
@@ -190,11 +328,25 @@ const (
 	CONN_S_WAITING   = "waiting"
 	CONN_S_CONNECTED = "connected"
 	CONN_S_CLOSED    = "closed"
+	CONN_S_DETACHED  = "detached"
 	CONN_M_EXEC      = "exec"
 	CONN_M_SHARE     = "share"
 	CONN_M_ATTACH    = "attach"
 	CONN_M_PLAY      = "play"
 	NULL_FILE        = "/dev/null"
+	// websocket subprotocols, the binary one sends the output as binary
+	// frames of its type byte and the raw bytes instead of base64 text
+	WS_PROTOCOL        = "gotty"
+	WS_PROTOCOL_BINARY = "gotty.v2"
+	// what happens to a client whose send queue is full, its queued
+	// output is replaced by the screen, or it is disconnected
+	SLOW_SNAPSHOT = "snapshot"
+	SLOW_CLOSE    = "close"
+	// a joining client gets all of the history, not the output after
+	// the offset it resumes from
+	REPLAY_ALL = -1
+	// seconds between two runs of the recording retention janitor
+	REC_CLEAN_INTERVAL = 60
 )
 
 var (
@@ -209,6 +361,7 @@ var (
 		DemoDir:             "/var/lib/gotty/static",
 		DemoEnable:          true,
 		DemoAddr:            "127.0.0.0/8",
+		ApiEnable:           false,
 		EnableTLS:           false,
 		TLSCrtFile:          "/etc/gotty/gotty.crt",
 		TLSKeyFile:          "/etc/gotty/gotty.key",
@@ -222,12 +375,47 @@ var (
 		Preferences:         HtermPrefernces{},
 		WaitingConnTime:     10,
 		RecFileDir:          "/var/lib/gotty",
+		RecFormat:           rec.FORMAT_GOB,
+		RecMaxAge:           0,
+		RecMaxSize:          0,
+		RecKeepLast:         0,
+		RecKeyFile:          "",
 		SkipTlsVerify:       false,
 		UnixSocket:          "/tmp/gotty.sock",
 		Debug:               false,
 		Resourses:           "./resources",
 		Chuser:              "",
 		Env:                 map[string]string{},
+		UserFile:            "",
+		AuditLog:            "",
+		AuditInput:          false,
+		MetricsEnable:       false,
+		MetricsAddr:         "",
+		TokenTTL:            300,
+		ScrollbackSize:      64 * 1024,
+		Redact:              []string{},
+		RedactMask:          REDACT_MASK,
+		RedactLive:          false,
+		WsCompression:       true,
+		WsCompressionLevel:  1, // flate.BestSpeed
+		OutputCoalesce:      5,
+		OutputCoalesceMax:   32 * 1024,
+		SendQueue:           256 * 1024,
+		SlowConsumer:        SLOW_SNAPSHOT,
+		SendTimeout:         10,
+		WsPingInterval:      30,
+		WsPongTimeout:       60,
+		IdleTimeout:         0,
+		MaxLifetime:         0,
+		TimeoutWarning:      60,
+		ReconnectGrace:      30,
+		Oidc: OidcOptions{
+			Scopes:        []string{"openid", "profile", "email", "groups"},
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+			Role:          ROLE_OPERATOR,
+			SessionTTL:    43200,
+		},
 	}
 	DefaultCmdOptions = CmdOptions{
 		All:              false,
@@ -235,6 +423,8 @@ var (
 		PermitShare:      false,
 		PermitShareWrite: false,
 		Rec:              false,
+		RecInput:         false,
+		Persist:          false,
 		Repeat:           true,
 		Speed:            1.0,
 		Addr:             "127.0.0.0/8",
@@ -0,0 +1,117 @@
+package tty
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// why a session was closed by the daemon
+	TIMEOUT_IDLE     = "idle"
+	TIMEOUT_LIFETIME = "lifetime"
+	// how often the timeouts are checked
+	TIMEOUT_CHECK = time.Second
+)
+
+// timeoutDeadline is the first timeout of the session to expire, and
+// why, "" if it has none
+func timeoutDeadline(opt *CmdOptions, start, lastInput time.Time) (string, time.Time) {
+	var reason string
+	var deadline time.Time
+	if opt.MaxLifetime > 0 {
+		reason = TIMEOUT_LIFETIME
+		deadline = start.Add(time.Duration(opt.MaxLifetime) * time.Second)
+	}
+	if opt.IdleTimeout > 0 {
+		idle := lastInput.Add(time.Duration(opt.IdleTimeout) * time.Second)
+		if reason == "" || idle.Before(deadline) {
+			reason, deadline = TIMEOUT_IDLE, idle
+		}
+	}
+	return reason, deadline
+}
+
+func timeoutMessage(opt *CmdOptions, reason string) string {
+	if reason == TIMEOUT_IDLE {
+		return fmt.Sprintf("no input for %s",
+			time.Duration(opt.IdleTimeout)*time.Second)
+	}
+	return fmt.Sprintf("the session ran for %s",
+		time.Duration(opt.MaxLifetime)*time.Second)
+}
+
+// notice writes a line of the daemon to the clients' terminals, it is
+// neither recorded nor kept in the history. It runs in processReceive,
+// other goroutines use postNotice.
+func (context *clientContext) notice(text string) {
+	msg := []byte("\r\n\x1b[1;33m[gotty] " + text + "\x1b[0m\r\n")
+	for _, e := range context.writeEach(func(wc *webConn) error {
+		return wc.writeOutput(msg, nil)
+	}) {
+		glog.V(2).Infof("notice to %s: %v", e.key, e.err)
+	}
+}
+
+// postNotice hands a notice to processReceive, which owns the
+// connections with processSend, false if the session ended
+func (context *clientContext) postNotice(text string, done <-chan struct{}) bool {
+	select {
+	case context.connRx <- &connRx{notice: text}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// watchTimeouts closes the session of context when nobody typed for
+// its idle timeout or it ran for its max lifetime, the clients are
+// warned timeout_warning seconds before
+func (context *clientContext) watchTimeouts(done <-chan struct{}) {
+	s := context.session
+	if s.method != CONN_M_EXEC || s.options.IdleTimeout <= 0 &&
+		s.options.MaxLifetime <= 0 {
+		return
+	}
+	start := time.Now()
+	atomic.StoreInt64(&s.lastInput, start.UnixNano())
+	warning := time.Duration(daemon.options.TimeoutWarning) * time.Second
+	warned := ""
+
+	tick := time.NewTicker(TIMEOUT_CHECK)
+	defer tick.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-done:
+			return
+		case now = <-tick.C:
+		}
+
+		reason, deadline := timeoutDeadline(s.options, start,
+			time.Unix(0, atomic.LoadInt64(&s.lastInput)))
+		left := deadline.Sub(now)
+		switch {
+		case left <= 0:
+			glog.Infof("session %s closed: %s", s.key,
+				timeoutMessage(s.options, reason))
+			context.postNotice("session closed, "+timeoutMessage(s.options, reason), done)
+			daemon.audit.logTimeout(s, reason)
+			if err := context.terminate(); err != nil {
+				glog.Errorf("close %s: %v", s.key, err)
+			}
+			return
+		case left <= warning && warned != reason:
+			warned = reason
+			if !context.postNotice(fmt.Sprintf("%s, the session is closed in %s",
+				timeoutMessage(s.options, reason), left.Round(time.Second)), done) {
+				return
+			}
+		case left > warning:
+			// someone typed after the warning
+			warned = ""
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tty
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutDeadline(t *testing.T) {
+	start := time.Unix(1000, 0)
+	opt := &CmdOptions{}
+	if reason, _ := timeoutDeadline(opt, start, start); reason != "" {
+		t.Fatalf("no timeout, got %s", reason)
+	}
+
+	opt = &CmdOptions{IdleTimeout: 60, MaxLifetime: 3600}
+	reason, deadline := timeoutDeadline(opt, start, start.Add(10*time.Second))
+	if reason != TIMEOUT_IDLE || !deadline.Equal(start.Add(70*time.Second)) {
+		t.Fatalf("idle %s %v", reason, deadline)
+	}
+	// typing does not extend the lifetime
+	reason, deadline = timeoutDeadline(opt, start, start.Add(3590*time.Second))
+	if reason != TIMEOUT_LIFETIME || !deadline.Equal(start.Add(time.Hour)) {
+		t.Fatalf("lifetime %s %v", reason, deadline)
+	}
+	if m := timeoutMessage(opt, TIMEOUT_IDLE); m != "no input for 1m0s" {
+		t.Fatalf("message %q", m)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{WsPingInterval: 1,
+		WsPongTimeout: 2})
+	defer closeServer()
+
+	read := func(wc *webConn) chan error {
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := wc.conn.ReadMessage()
+			errc <- err
+		}()
+		return errc
+	}
+	// a client which reads answers the pings, the other one is dropped
+	live, _, liveConn := dial(WS_PROTOCOL_BINARY)
+	defer live.Close()
+	go live.ReadMessage()
+	dead, _, deadConn := dial(WS_PROTOCOL_BINARY)
+	defer dead.Close()
+
+	liveErr, deadErr := read(liveConn), read(deadConn)
+	select {
+	case err := <-deadErr:
+		if err == nil {
+			t.Fatal("read a message")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("dead client kept")
+	}
+	select {
+	case err := <-liveErr:
+		t.Fatalf("live client dropped: %v", err)
+	default:
+	}
+}
+
+func TestPostNotice(t *testing.T) {
+	dial, closeServer := testServer(t, &Options{})
+	defer closeServer()
+
+	c, _, wc := dial(WS_PROTOCOL_BINARY)
+	defer c.Close()
+	key := ConnKey{Name: "abc", Addr: "127.0.0.1/32"}
+	conns := map[ConnKey]*webConn{key: wc}
+	context := &clientContext{connections: &conns, connRx: make(chan *connRx)}
+	done := make(chan struct{})
+	go func() {
+		context.processReceive()
+		close(done)
+	}()
+
+	// the notice is written by processReceive
+	if !context.postNotice("hello", done) {
+		t.Fatal("notice not posted")
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := c.ReadMessage(); err != nil ||
+		!strings.Contains(string(data), "[gotty] hello") {
+		t.Fatalf("got %q %v", data, err)
+	}
+
+	// an empty message ends processReceive
+	context.connRx <- &connRx{key: key}
+	<-done
+	if context.postNotice("bye", done) {
+		t.Fatal("notice posted after the session ended")
+	}
+}
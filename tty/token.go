@@ -0,0 +1,153 @@
+package tty
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionToken is handed to web clients instead of a long-lived
+// credential. A token bound to a session key can be used once, an
+// unbound one (served by auth_token.js) until it expires.
+type sessionToken struct {
+	Key    ConnKey `json:"k"`
+	Write  bool    `json:"w,omitempty"`
+	User   string  `json:"u,omitempty"`
+	Role   string  `json:"r,omitempty"`
+	Once   bool    `json:"o,omitempty"`
+	Expire int64   `json:"e"`
+	Nonce  string  `json:"n"`
+}
+
+// the purposes of sealed values, each is signed with its own key so
+// that one can not be passed off as another
+const (
+	TOKEN_SESSION    = "session"
+	TOKEN_OIDC_STATE = "oidc-state"
+	TOKEN_OIDC_LOGIN = "oidc-login"
+	TOKEN_RESUME     = "resume"
+)
+
+type tokenCtxKey struct{}
+
+type tokenIssuer struct {
+	sync.Mutex
+	secret []byte
+	ttl    time.Duration
+	used   map[string]int64
+}
+
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+	errTokenUsed    = errors.New("token already used")
+)
+
+func newTokenIssuer(ttl time.Duration) (*tokenIssuer, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &tokenIssuer{secret: secret, ttl: ttl, used: make(map[string]int64)}, nil
+}
+
+// sign signs payload with the key of purpose typ, derived from the secret
+func (ti *tokenIssuer) sign(typ, payload string) string {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte("gotty token " + typ))
+	mac = hmac.New(sha256.New, mac.Sum(nil))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal returns v json encoded and signed for purpose typ, for cookies
+// and tokens
+func (ti *tokenIssuer) seal(typ string, v interface{}) string {
+	buf, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	return payload + "." + ti.sign(typ, payload)
+}
+
+// unseal checks that s was sealed for purpose typ and decodes it into v
+func (ti *tokenIssuer) unseal(typ, s string, v interface{}) error {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return errTokenInvalid
+	}
+	payload, sig := s[:i], s[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(ti.sign(typ, payload))) {
+		return errTokenInvalid
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errTokenInvalid
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return errTokenInvalid
+	}
+	return nil
+}
+
+// mint fills in the nonce and, if unset, the expiry of t and returns
+// its signed form
+func (ti *tokenIssuer) mint(t *sessionToken) string {
+	if t.Expire == 0 {
+		t.Expire = time.Now().Add(ti.ttl).Unix()
+	}
+	t.Nonce = generateRandomString(16)
+	return ti.seal(TOKEN_SESSION, t)
+}
+
+// verify checks the signature and expiry of s and that it is either
+// unbound or bound to key, one-time tokens are consumed by a successful call
+func (ti *tokenIssuer) verify(s string, key ConnKey) (*sessionToken, error) {
+	var t sessionToken
+
+	if err := ti.unseal(TOKEN_SESSION, s, &t); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > t.Expire {
+		return nil, errTokenExpired
+	}
+	if t.Key != (ConnKey{}) && t.Key != key {
+		return nil, errTokenInvalid
+	}
+
+	if t.Once {
+		ti.Lock()
+		defer ti.Unlock()
+		if _, ok := ti.used[t.Nonce]; ok {
+			return nil, errTokenUsed
+		}
+		ti.used[t.Nonce] = t.Expire
+	}
+	return &t, nil
+}
+
+func withToken(r *http.Request, t *sessionToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenCtxKey{}, t))
+}
+
+func requestToken(r *http.Request) *sessionToken {
+	t, _ := r.Context().Value(tokenCtxKey{}).(*sessionToken)
+	return t
+}
+
+// clean forgets consumed tokens which would be rejected as expired anyway
+func (ti *tokenIssuer) clean(now int64) {
+	ti.Lock()
+	defer ti.Unlock()
+	for nonce, expire := range ti.used {
+		if expire < now {
+			delete(ti.used, nonce)
+		}
+	}
+}
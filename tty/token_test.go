@@ -0,0 +1,46 @@
+package tty
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	ti, err := newTokenIssuer(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ConnKey{Name: "abc", Addr: "127.0.0.1/32"}
+
+	page := ti.mint(&sessionToken{Write: true})
+	for i := 0; i < 2; i++ {
+		if tok, err := ti.verify(page, key); err != nil || !tok.Write {
+			t.Fatalf("page token: %v", err)
+		}
+	}
+
+	once := ti.mint(&sessionToken{Key: key, Once: true})
+	if _, err := ti.verify(once, ConnKey{Name: "other"}); err != errTokenInvalid {
+		t.Fatalf("token used for another session: %v", err)
+	}
+	if tok, err := ti.verify(once, key); err != nil || tok.Write {
+		t.Fatalf("session token: %v", err)
+	}
+	if _, err := ti.verify(once, key); err != errTokenUsed {
+		t.Fatalf("one-time token reused: %v", err)
+	}
+
+	if _, err := ti.verify(page[:len(page)-2]+"xx", key); err != errTokenInvalid {
+		t.Fatalf("forged token: %v", err)
+	}
+
+	expired := ti.mint(&sessionToken{Expire: time.Now().Unix() - 1})
+	if _, err := ti.verify(expired, key); err != errTokenExpired {
+		t.Fatalf("expired token: %v", err)
+	}
+
+	ti.clean(time.Now().Unix() + 3600)
+	if len(ti.used) != 0 {
+		t.Fatalf("clean left %d tokens", len(ti.used))
+	}
+}
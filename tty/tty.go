@@ -1,6 +1,7 @@
 package tty
 
 import (
+	"compress/flate"
 	"container/list"
 	"crypto/rand"
 	"crypto/tls"
@@ -20,6 +21,7 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"text/template"
 	"time"
@@ -31,6 +33,7 @@ import (
 	"github.com/yubo/gotool/flags"
 	"github.com/yubo/gotool/utils"
 	"github.com/yubo/gotty/hcl"
+	"github.com/yubo/gotty/rec"
 )
 
 var (
@@ -73,10 +76,11 @@ func cleanWaitingConn(options *Options) {
 			//remove from deamon.session
 			sess.status = CONN_S_CLOSED
 			delete(daemon.session, sess.key)
+			daemon.audit.log(AUDIT_EXPIRE, sess)
 			if sess.options.Rec && sess.recorder != nil {
 				name := sess.recorder.FileName
 				sess.recorder.Close()
-				os.Remove(name)
+				rec.Remove(name)
 				sess.recorder = nil
 			}
 			sess.Unlock()
@@ -85,12 +89,48 @@ func cleanWaitingConn(options *Options) {
 	}
 }
 
+// cleanRecordings applies the retention options to rec_file_dir, the
+// recordings of open sessions are kept
+func cleanRecordings(options *Options) {
+	r := &rec.Retention{
+		MaxAge:   int64(options.RecMaxAge),
+		MaxSize:  int64(options.RecMaxSize) << 20,
+		KeepLast: options.RecKeepLast,
+	}
+	if !r.Enabled() {
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, s := range daemon.session {
+		if s.recorder != nil {
+			active[s.recorder.FileName] = true
+		}
+		if s.player != nil {
+			active[s.player.FileName] = true
+		}
+	}
+	removed, err := rec.Prune(expandHomeDir(options.RecFileDir), r,
+		func(filename string) bool { return active[filename] })
+	for _, m := range removed {
+		glog.V(2).Infof("recording %s removed by the retention policy", m.Id)
+	}
+	if err != nil {
+		glog.Errorf("clean recordings: %v", err)
+	}
+}
+
 func cleanWorker(options *Options) {
 	t := time.NewTicker(time.Second).C
-	for {
+	for n := 0; ; n++ {
 		select {
 		case <-t:
 			cleanWaitingConn(options)
+			cleanDetached(time.Now().Unix())
+			daemon.tokens.clean(time.Now().Unix())
+			if n%REC_CLEAN_INTERVAL == 0 {
+				cleanRecordings(options)
+			}
 		}
 	}
 }
@@ -111,13 +151,67 @@ func daemonInit(options *Options, command []string) error {
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			Subprotocols:    []string{"gotty"},
+			// the first one the client offers is taken
+			Subprotocols: []string{WS_PROTOCOL_BINARY, WS_PROTOCOL},
+			// permessage-deflate, for the clients which offer it
+			EnableCompression: options.WsCompression,
 		},
 		titleTemplate: titleTemplate,
 		session:       make(map[ConnKey]*session),
 		waitingConn:   &Slist{list: list.New()},
 	}
 
+	daemon.tokens, err = newTokenIssuer(
+		time.Duration(options.TokenTTL) * time.Second)
+	if err != nil {
+		return err
+	}
+
+	if err = loadRecKeys(); err != nil {
+		return err
+	}
+
+	if options.WsCompression && (options.WsCompressionLevel < flate.HuffmanOnly ||
+		options.WsCompressionLevel > flate.BestCompression) {
+		return fmt.Errorf("ws_compression_level %d is not between %d and %d",
+			options.WsCompressionLevel, flate.HuffmanOnly, flate.BestCompression)
+	}
+
+	if options.SlowConsumer != SLOW_SNAPSHOT && options.SlowConsumer != SLOW_CLOSE {
+		return fmt.Errorf("slow_consumer %q is not %q or %q",
+			options.SlowConsumer, SLOW_SNAPSHOT, SLOW_CLOSE)
+	}
+
+	if _, err = newRedactRules(options.Redact, "", false); err != nil {
+		return fmt.Errorf("redact: %v", err)
+	}
+
+	if GlobalOpt.UserFile != "" {
+		if daemon.users, err = loadUserFile(GlobalOpt.UserFile); err != nil {
+			return err
+		}
+	}
+
+	if GlobalOpt.AuditLog != "" {
+		daemon.audit, err = newAuditLogger(GlobalOpt.AuditLog,
+			GlobalOpt.AuditInput)
+		if err != nil {
+			return err
+		}
+	}
+
+	if GlobalOpt.Oidc.Issuer != "" {
+		daemon.oidc, err = newOidcProvider(&GlobalOpt.Oidc, daemon.tokens)
+		if err != nil {
+			return err
+		}
+	}
+
+	if GlobalOpt.ApiEnable && !authEnabled() && !GlobalOpt.EnableBasicAuth {
+		// anyone who can reach the api could run commands
+		return errors.New("api_enable needs user_file, oidc or enable_basic_auth")
+	}
+
 	if GlobalOpt.Chuser != "" {
 		daemon.user, _ = user.Lookup(GlobalOpt.Chuser)
 	} else {
@@ -132,6 +226,20 @@ func daemonInit(options *Options, command []string) error {
 	return rpcInit()
 }
 
+// loadRecKeys reads rec_key_file, new recordings are encrypted with
+// its first key
+func loadRecKeys() error {
+	if GlobalOpt.RecKeyFile == "" {
+		return nil
+	}
+	k, err := rec.LoadKeyring(expandHomeDir(GlobalOpt.RecKeyFile))
+	if err != nil {
+		return err
+	}
+	rec.SetKeyring(k)
+	return nil
+}
+
 func applyConfigFile(options *Options, filePath string) error {
 	filePath = expandHomeDir(filePath)
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -193,14 +301,43 @@ func run() error {
 		siteMux.HandleFunc("/cmd", demoCmdHandler)
 	}
 
+	if GlobalOpt.ApiEnable {
+		siteMux.HandleFunc(apiPrefix, apiHandler)
+	}
+
+	if GlobalOpt.MetricsEnable {
+		if GlobalOpt.MetricsAddr == "" {
+			siteMux.HandleFunc("/metrics", metricsHandler)
+		} else {
+			// a separate listener is not behind authentication, it
+			// is bound to localhost unless a host is given
+			addr := metricsListenAddr(GlobalOpt.MetricsAddr)
+			go func() {
+				mux := http.NewServeMux()
+				mux.HandleFunc("/metrics", metricsHandler)
+				glog.V(0).Infof("Metrics: http://%s/metrics", addr)
+				if err := http.ListenAndServe(addr, mux); err != nil {
+					glog.Errorf("metrics listener %v", err)
+				}
+			}()
+		}
+	}
+
 	siteHandler := http.Handler(siteMux)
 
-	if GlobalOpt.EnableBasicAuth {
+	if daemon.oidc != nil {
+		glog.V(3).Infof("Using OpenID Connect Authentication")
+		siteHandler = daemon.oidc.wrap(siteHandler)
+	} else if GlobalOpt.EnableBasicAuth || daemon.users != nil {
 		glog.V(3).Infof("Using Basic Authentication")
 		siteHandler = wrapBasicAuth(siteHandler, GlobalOpt.Credential)
 	}
 
 	wsMux := http.NewServeMux()
+	if daemon.oidc != nil {
+		wsMux.Handle(oidcCallbackPath,
+			wrapHeaders(http.HandlerFunc(daemon.oidc.callback)))
+	}
 	wsMux.Handle("/", wrapHeaders(siteHandler))
 	wsMux.Handle("/ws", http.HandlerFunc(wsHandler))
 	siteHandler = wrapLogger(http.Handler(wsMux))
@@ -308,7 +445,9 @@ func ws_clone(sess *session, r *http.Request,
 	}
 	sess.linkNb += 1
 	opt := *sess.options
-	if !(opt.PermitWrite && opt.PermitShare && opt.PermitShareWrite) {
+	// with handoff the joined clients may type once they get the keyboard
+	if !(opt.PermitWrite && opt.PermitShare &&
+		(opt.PermitShareWrite || sess.control != nil)) {
 		opt.PermitWrite = false
 	}
 	s := &session{
@@ -323,16 +462,35 @@ func ws_clone(sess *session, r *http.Request,
 		command:    sess.command,
 		context: &clientContext{
 			request:     r,
-			connection:  &webConn{conn: conn},
+			connection:  newWebConn(conn),
 			connections: sess.context.connections,
 			command:     sess.context.command,
 			pty:         sess.context.pty,
 			connRx:      sess.context.connRx,
+			history:     sess.context.history,
+			chat:        sess.context.chat,
 		},
 	}
 	s.context.session = s
+	s.setClient(r)
 	daemon.session[key] = s
-	return s.context.goHandleClientJoin()
+	daemon.audit.log(AUDIT_SHARE, s)
+	return s.context.goHandleClientJoin(REPLAY_ALL)
+}
+
+// ws_reattach hands a detached persistent session to a new websocket
+func ws_reattach(session *session, r *http.Request,
+	conn *websocket.Conn) error {
+	session.connTime = time.Now().Unix()
+	session.status = CONN_S_CONNECTED
+	session.resumeUntil = 0
+	session.setClient(r)
+	session.context.request = r
+	session.context.connection = newWebConn(conn)
+	glog.V(2).Infof("name:%s addr:%s reattached from %s\n",
+		session.key.Name, session.key.Addr, r.RemoteAddr)
+	daemon.audit.log(AUDIT_REATTACH, session)
+	return session.context.goHandleClientJoin(REPLAY_ALL)
 }
 
 func ws_connect(session *session, r *http.Request,
@@ -340,12 +498,15 @@ func ws_connect(session *session, r *http.Request,
 
 	session.connTime = time.Now().Unix()
 	session.status = CONN_S_CONNECTED
+	session.setClient(r)
 	session.context.session = session
 	session.context.request = r
 	conns := make(map[ConnKey]*webConn)
 	session.context.connections = &conns
-	session.context.connection = &webConn{conn: conn}
+	session.context.connection = newWebConn(conn)
 	session.context.connRx = make(chan *connRx)
+	session.context.history = newRingBuffer(daemon.options.ScrollbackSize)
+	session.context.chat = &chatLog{}
 
 	if session.method == CONN_M_EXEC {
 		argv := session.command[1:]
@@ -370,6 +531,7 @@ func ws_connect(session *session, r *http.Request,
 		session.context.command = &exec.Cmd{Process: &os.Process{}}
 		//player := daemon.player
 	}
+	daemon.audit.log(AUDIT_CONNECT, session)
 	session.context.goHandleClient()
 
 }
@@ -407,11 +569,6 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 		return
 	}
-	if init.AuthToken != GlobalOpt.Credential {
-		glog.Infof("Failed to authenticate websocket connection")
-		conn.Close()
-		return
-	}
 
 	//if GlobalOpt.PermitArguments {
 	if init.Arguments == "" {
@@ -442,6 +599,41 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		key.Addr = cip
 	}
 
+	if init.ResumeToken != "" && ws_resume(&init, r, conn, cip) {
+		return
+	}
+
+	// a token from a shared url takes precedence over the page token
+	token := init.AuthToken
+	if params := query.Query()["token"]; len(params) != 0 {
+		token = params[0]
+	}
+	t, err := daemon.tokens.verify(token, key)
+	if err != nil {
+		glog.Infof("Failed to authenticate websocket connection: %v", err)
+		authFailed(AUTH_F_TOKEN)
+		conn.Close()
+		return
+	}
+	r = withToken(r, t)
+
+	if authEnabled() {
+		var u *authUser
+		if t.Key == (ConnKey{}) {
+			if u = webUser(r); u == nil {
+				glog.Infof("Failed to authenticate websocket user from %s",
+					r.RemoteAddr)
+				authFailed(AUTH_F_WS)
+				conn.Close()
+				return
+			}
+		} else if t.User != "" {
+			// the issuer of a session token has been authenticated
+			u = &authUser{Name: t.User, Role: t.Role}
+		}
+		r = withUser(r, u)
+	}
+
 	if session, ok = daemon.session[key]; !ok {
 		glog.V(2).Infof("name:%s addr:%s is not exist\n", key.Name, key.Addr)
 		conn.Close()
@@ -451,6 +643,7 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	if !ipFilter(cip, session.nets) {
 		glog.V(2).Infof("RemoteAddr:%s is not allowed to access name:%s addr:%s\n",
 			cip, key.Name, key.Addr)
+		atomic.AddUint64(&metrics.ipRejects, 1)
 		conn.Close()
 		return
 	}
@@ -465,6 +658,16 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		} else if session.status == CONN_S_WAITING {
 			ws_connect(session, r, query, conn)
 			return
+		} else if session.status == CONN_S_DETACHED && session.persistent() {
+			// others detached only while their client may resume, which
+			// takes the resume token
+			if !allowed(r, ROLE_OPERATOR) {
+				glog.V(2).Infof("name:%s addr:%s reattach permission denied\n",
+					key.Name, key.Addr)
+				conn.Close()
+				return
+			}
+			ws_reattach(session, r, conn)
 		} else {
 			glog.V(2).Infof("name:%s addr:%s status is %s, not allow to connect\n",
 				key.Name, key.Addr, session.status)
@@ -483,16 +686,20 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 
 		session.connTime = time.Now().Unix()
 		session.status = CONN_S_CONNECTED
+		session.setClient(r)
 		session.context = &clientContext{
 			session:     session,
 			request:     r,
-			connection:  &webConn{conn: conn},
+			connection:  newWebConn(conn),
 			connections: session.linkTo.context.connections,
 			command:     session.linkTo.context.command,
 			pty:         session.linkTo.context.pty,
 			connRx:      session.linkTo.context.connRx,
+			history:     session.linkTo.context.history,
+			chat:        session.linkTo.context.chat,
 		}
-		session.context.goHandleClientJoin()
+		daemon.audit.log(AUDIT_ATTACH, session)
+		session.context.goHandleClientJoin(REPLAY_ALL)
 	}
 }
 
@@ -503,7 +710,13 @@ func (tty *Daemon) handleCustomIndex(w http.ResponseWriter, r *http.Request) {
 */
 
 func (tty *Daemon) handleAuthToken(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("var gotty_auth_token = '" + GlobalOpt.Credential + "';"))
+	t := &sessionToken{Write: allowed(r, ROLE_OPERATOR)}
+	if u := requestUser(r); u != nil {
+		t.User = u.Name
+		t.Role = u.Role
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("var gotty_auth_token = '" + tty.tokens.mint(t) + "';"))
 }
 
 func deamonExit() (firstCall bool) {
@@ -551,7 +764,20 @@ func wrapBasicAuth(handler http.Handler, credential string) http.Handler {
 			return
 		}
 
-		if credential != string(payload) {
+		if users := daemon.users; users != nil {
+			var u *authUser
+			if pair := strings.SplitN(string(payload), ":", 2); len(pair) == 2 {
+				u = users.authenticate(pair[0], pair[1])
+			}
+			if u == nil {
+				authFailed(AUTH_F_BASIC)
+				w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
+				http.Error(w, "authorization failed", http.StatusUnauthorized)
+				return
+			}
+			r = withUser(r, u)
+		} else if credential != string(payload) {
+			authFailed(AUTH_F_BASIC)
 			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
 			http.Error(w, "authorization failed", http.StatusUnauthorized)
 			return
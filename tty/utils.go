@@ -86,12 +86,17 @@ func parseFuncFiles(funcs template.FuncMap, filenames ...string) (*template.Temp
 }
 
 func RenderJson(w http.ResponseWriter, v interface{}) {
+	RenderJsonStatus(w, http.StatusOK, v)
+}
+
+func RenderJsonStatus(w http.ResponseWriter, code int, v interface{}) {
 	bs, err := json.Marshal(v)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
 	w.Write(bs)
 }
 
@@ -0,0 +1,287 @@
+package tty
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// the screen is cleared before a snapshot redraws it
+const SCREEN_RESET = "\x1b[H\x1b[2J"
+
+var (
+	errSlowConsumer = errors.New("send queue full, client too slow")
+	errConnClosing  = errors.New("connection closing")
+)
+
+// newWebConn wraps a websocket in a send queue, written by its own
+// goroutine so that a slow client does not hold up the others
+func newWebConn(conn *websocket.Conn) *webConn {
+	o := daemon.options
+	wc := &webConn{conn: conn,
+		binary:   conn.Subprotocol() == WS_PROTOCOL_BINARY,
+		window:   time.Duration(o.OutputCoalesce) * time.Millisecond,
+		max:      o.OutputCoalesceMax,
+		limit:    o.SendQueue,
+		policy:   o.SlowConsumer,
+		timeout:  time.Duration(o.SendTimeout) * time.Second,
+		wake:     make(chan struct{}, 1),
+		full:     make(chan struct{}, 1),
+		ping:     time.Duration(o.WsPingInterval) * time.Second,
+		pongWait: time.Duration(o.WsPongTimeout) * time.Second,
+	}
+	if o.WsCompression {
+		// only used if the client negotiated permessage-deflate
+		conn.SetCompressionLevel(o.WsCompressionLevel)
+	}
+	wc.alive()
+	conn.SetPongHandler(func(string) error {
+		wc.alive()
+		return nil
+	})
+	go wc.writeLoop()
+	return wc
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// dropOutput removes the queued output, the caller holds the lock
+func (wc *webConn) dropOutput() {
+	queue := wc.queue[:0]
+	wc.queued = 0
+	for _, m := range wc.queue {
+		if !m.output {
+			queue = append(queue, m)
+			wc.queued += len(m.data)
+		}
+	}
+	wc.queue = queue
+}
+
+// send queues m. A client which does not keep up loses its queued
+// output and gets the screen from snapshot instead, or is disconnected
+// if it has no snapshot or its policy is SLOW_CLOSE.
+func (wc *webConn) send(m wsMessage, snapshot func() ([]byte, uint64)) error {
+	wc.Lock()
+	defer wc.Unlock()
+	if wc.err != nil {
+		return wc.err
+	}
+	if wc.closing {
+		return errConnClosing
+	}
+
+	if wc.limit > 0 && wc.queued+len(m.data) > wc.limit {
+		if wc.policy == SLOW_CLOSE {
+			return wc.slow()
+		}
+		wc.dropOutput()
+		wc.resync = true
+	}
+	var offset []byte
+	if m.output && wc.resync {
+		if snapshot == nil {
+			// nothing to redraw the screen with
+			return wc.slow()
+		}
+		atomic.AddUint64(&metrics.slowSnapshots, 1)
+		wc.resync = false
+		screen, total := snapshot()
+		// the client counts the output from there on
+		offset = newResumeMessage("", total)
+		if wc.limit > 0 {
+			// a screen larger than the queue would overflow it again
+			screen = tailLines(screen,
+				wc.limit-wc.queued-len(SCREEN_RESET)-len(offset))
+		}
+		m.data = append([]byte(SCREEN_RESET), screen...)
+	} else if wc.limit > 0 && wc.queued+len(m.data) > wc.limit {
+		return wc.slow()
+	}
+
+	wc.queue = append(wc.queue, m)
+	wc.queued += len(m.data)
+	if offset != nil {
+		wc.queue = append(wc.queue, wsMessage{
+			messageType: websocket.TextMessage, data: offset})
+		wc.queued += len(offset)
+	}
+	notify(wc.wake)
+	if !m.output || wc.queued >= wc.max {
+		// nothing to wait for
+		notify(wc.full)
+	}
+	return nil
+}
+
+// tailLines returns the lines at the end of data which fit in n bytes
+func tailLines(data []byte, n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	if len(data) <= n {
+		return data
+	}
+	data = data[len(data)-n:]
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i+1:]
+	}
+	return data
+}
+
+// slow fails the connection of a client which did not keep up, the
+// caller holds the lock
+func (wc *webConn) slow() error {
+	atomic.AddUint64(&metrics.slowCloses, 1)
+	wc.err = errSlowConsumer
+	return wc.err
+}
+
+// write queues a message after the output which is still pending
+func (wc *webConn) write(data []byte) error {
+	return wc.send(wsMessage{messageType: websocket.TextMessage, data: data}, nil)
+}
+
+// writeOutput queues pty output, which must not change afterwards.
+// snapshot is the screen to send instead if the client lost output, and
+// the offset of the output after it.
+func (wc *webConn) writeOutput(data []byte, snapshot func() ([]byte, uint64)) error {
+	return wc.send(wsMessage{output: true, data: data}, snapshot)
+}
+
+// alive pushes the read deadline back, after the client sent something
+func (wc *webConn) alive() {
+	if wc.pongWait > 0 {
+		wc.conn.SetReadDeadline(time.Now().Add(wc.pongWait))
+	}
+}
+
+// queueLen is the size of the messages waiting for the writer
+func (wc *webConn) queueLen() int {
+	wc.Lock()
+	defer wc.Unlock()
+	return wc.queued
+}
+
+// close lets the writer send what is queued and close the websocket,
+// at once if the client already failed
+func (wc *webConn) close() error {
+	wc.Lock()
+	defer wc.Unlock()
+	if wc.err != nil {
+		// the writer stops too
+		wc.queue, wc.queued = nil, 0
+		notify(wc.wake)
+		return wc.conn.Close()
+	}
+	wc.closing = true
+	notify(wc.wake)
+	notify(wc.full)
+	return nil
+}
+
+// frames coalesces the runs of output in queue, a frame holds at most
+// max bytes or a single larger output
+func (wc *webConn) frames(queue []wsMessage) []wsMessage {
+	var frames []wsMessage
+	for _, m := range queue {
+		if n := len(frames) - 1; m.output && n >= 0 && frames[n].output &&
+			len(frames[n].data)+len(m.data) <= wc.max {
+			frames[n].data = append(frames[n].data, m.data...)
+			continue
+		}
+		if m.output {
+			m.data = append([]byte(nil), m.data...)
+		}
+		frames = append(frames, m)
+	}
+	for i, f := range frames {
+		if !f.output {
+			continue
+		}
+		if wc.binary {
+			frames[i] = wsMessage{messageType: websocket.BinaryMessage,
+				data: binaryOutputMessage(f.data)}
+		} else {
+			frames[i] = wsMessage{messageType: websocket.TextMessage,
+				data: outputMessage(f.data)}
+		}
+	}
+	return frames
+}
+
+// writeLoop sends the queue, after waiting up to window for more
+// output, and the pings
+func (wc *webConn) writeLoop() {
+	var ping <-chan time.Time
+	if wc.ping > 0 {
+		t := time.NewTicker(wc.ping)
+		defer t.Stop()
+		ping = t.C
+	}
+	for {
+		select {
+		case <-wc.wake:
+		case <-ping:
+			if err := wc.conn.WriteControl(websocket.PingMessage, nil,
+				time.Now().Add(wc.ping)); err != nil {
+				wc.Lock()
+				if wc.err == nil {
+					wc.err = err
+				}
+				wc.Unlock()
+				wc.conn.Close()
+				return
+			}
+			continue
+		}
+		if wc.window > 0 {
+			t := time.NewTimer(wc.window)
+			select {
+			case <-t.C:
+			case <-wc.full:
+			}
+			t.Stop()
+		}
+
+		wc.Lock()
+		if wc.err != nil {
+			wc.Unlock()
+			return
+		}
+		queue, closing := wc.queue, wc.closing
+		wc.queue, wc.queued = nil, 0
+		select {
+		case <-wc.full:
+		default:
+		}
+		wc.Unlock()
+
+		for _, f := range wc.frames(queue) {
+			if wc.timeout > 0 {
+				wc.conn.SetWriteDeadline(time.Now().Add(wc.timeout))
+			}
+			if err := wc.conn.WriteMessage(f.messageType, f.data); err != nil {
+				wc.Lock()
+				wc.err = err
+				wc.queue, wc.queued = nil, 0
+				wc.Unlock()
+				wc.conn.Close()
+				return
+			}
+			atomic.AddUint64(&wc.sent, uint64(len(f.data)))
+		}
+		if closing {
+			wc.conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tty
+
+import (
+	"testing"
+)
+
+func TestSlowConsumer(t *testing.T) {
+	snapshot := func() ([]byte, uint64) { return []byte("screen"), 42 }
+
+	// no writer takes from these queues
+	wc := &webConn{limit: 30, max: 30, policy: SLOW_SNAPSHOT}
+	wc.writeOutput([]byte("abcdefghijklmnopqrst"), snapshot)
+	wc.write([]byte("1"))
+	if err := wc.writeOutput([]byte("uvwxyz123"), snapshot); err != nil || wc.queued != 30 {
+		t.Fatalf("queued %d %v", wc.queued, err)
+	}
+	// the output is dropped, the other messages stay and the client
+	// is told where the screen is at
+	if err := wc.writeOutput([]byte("hi"), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(wc.queue) != 3 || string(wc.queue[0].data) != "1" ||
+		string(wc.queue[1].data) != SCREEN_RESET+"screen" ||
+		string(wc.queue[2].data) != `9{"offset":42}` {
+		t.Fatalf("queue %v", wc.queue)
+	}
+	if err := wc.write([]byte("1234567890123456789012345678901234567890")); err != errSlowConsumer {
+		t.Fatalf("message larger than the queue %v", err)
+	}
+
+	// a screen larger than the queue is cut to the lines which fit
+	wc = &webConn{limit: 33, max: 33, policy: SLOW_SNAPSHOT}
+	wc.writeOutput(make([]byte, 33), snapshot)
+	if err := wc.writeOutput([]byte("b"), func() ([]byte, uint64) {
+		return []byte("old\nnew screen"), 42
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(wc.queue[0].data) != SCREEN_RESET+"new screen" || wc.queued > wc.limit {
+		t.Fatalf("queued %d %q", wc.queued, wc.queue[0].data)
+	}
+
+	// without a screen to redraw, or with SLOW_CLOSE, the client is dropped
+	wc = &webConn{limit: 4, max: 8, policy: SLOW_SNAPSHOT}
+	if err := wc.writeOutput([]byte("abcde"), nil); err != errSlowConsumer {
+		t.Fatalf("no snapshot %v", err)
+	}
+	wc = &webConn{limit: 4, max: 8, policy: SLOW_CLOSE}
+	wc.writeOutput([]byte("abc"), snapshot)
+	if err := wc.writeOutput([]byte("de"), snapshot); err != errSlowConsumer {
+		t.Fatalf("close %v", err)
+	}
+	if err := wc.write([]byte("1")); err != errSlowConsumer {
+		t.Fatal("closed client still queued")
+	}
+
+	// coalesced into frames of at most max bytes
+	wc = &webConn{max: 4, binary: true}
+	frames := wc.frames([]wsMessage{{output: true, data: []byte("ab")},
+		{output: true, data: []byte("cd")}, {output: true, data: []byte("e")},
+		{data: []byte("1")}, {output: true, data: []byte("f")}})
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f.data))
+	}
+	if len(got) != 4 || got[0] != "0abcd" || got[1] != "0e" || got[2] != "1" ||
+		got[3] != "0f" {
+		t.Fatalf("frames %q", got)
+	}
+}